@@ -0,0 +1,175 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/config"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+)
+
+// Config contains configuration params for nsqd authentication. The secret is
+// given inline, read from an environment variable, or read from a file which
+// is re-read periodically so that the secret can be rotated.
+type Config struct {
+	Secret         string        `json:"secret" envconfig:"AUTH_SECRET" yaml:"secret" secret:"true"`
+	SecretEnv      string        `json:"secret_env" envconfig:"AUTH_SECRET_ENV" yaml:"secret_env"`
+	SecretFile     string        `json:"secret_file" envconfig:"AUTH_SECRET_FILE" yaml:"secret_file"`
+	ReloadInterval time.Duration `json:"reload_interval" envconfig:"AUTH_RELOAD_INTERVAL" default:"1m" yaml:"reload_interval"`
+}
+
+// NewConfig creates a new Config with the default values of its default tags.
+func NewConfig() Config {
+	return config.Defaults[Config]()
+}
+
+// Validate validates the configuration.
+func (c Config) Validate() error {
+	set := 0
+	for _, s := range []string{c.Secret, c.SecretEnv, c.SecretFile} {
+		if s != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("only one field between secret, secret_env and secret_file can be specified")
+	}
+	if c.ReloadInterval < 0 {
+		return errors.New("auth reload interval must not be negative")
+	}
+	return nil
+}
+
+// String returns the configuration with its secrets redacted, so that it can
+// be printed or logged.
+func (c Config) String() string {
+	return config.Format(c)
+}
+
+// Get returns a Secret based on the configuration values of Config, or nil if
+// no secret is configured.
+func (c *Config) Get(f ifs.FS) (*Secret, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	s := &Secret{conf: *c, f: f}
+	switch {
+	case c.Secret != "":
+		s.value = c.Secret
+	case c.SecretEnv != "":
+		v, ok := os.LookupEnv(c.SecretEnv)
+		if !ok {
+			return nil, fmt.Errorf("auth secret env var %s is not set", c.SecretEnv)
+		}
+		s.value = v
+	case c.SecretFile != "":
+		v, err := s.readFile()
+		if err != nil {
+			return nil, err
+		}
+		s.value = v
+	default:
+		return nil, nil
+	}
+	return s, nil
+}
+
+// Secret holds the current nsqd auth secret.
+type Secret struct {
+	conf  Config
+	f     ifs.FS
+	mut   sync.RWMutex
+	value string
+}
+
+// Value returns the current secret.
+func (s *Secret) Value() string {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.value
+}
+
+func (s *Secret) readFile() (string, error) {
+	b, err := ifs.ReadFile(s.f, s.conf.SecretFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read auth secret file: %w", err)
+	}
+	v := strings.TrimSpace(string(b))
+	if v == "" {
+		return "", fmt.Errorf("auth secret file %s is empty", s.conf.SecretFile)
+	}
+	return v, nil
+}
+
+// Reload re-reads the secret file and reports whether the secret changed.
+// Secrets that are not read from a file never change.
+func (s *Secret) Reload() (bool, error) {
+	if s.conf.SecretFile == "" {
+		return false, nil
+	}
+	v, err := s.readFile()
+	if err != nil {
+		return false, err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if v == s.value {
+		return false, nil
+	}
+	s.value = v
+	return true, nil
+}
+
+// Watch reloads the secret file every ReloadInterval until the context is
+// cancelled, calling onChange with the new secret whenever it changes and
+// onError with reload failures, during which the previous secret is kept.
+// It returns immediately when there is nothing to watch.
+func (s *Secret) Watch(ctx context.Context, onChange func(secret string), onError func(err error)) {
+	if s.conf.SecretFile == "" || s.conf.ReloadInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.conf.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		changed, err := s.Reload()
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			}
+			continue
+		}
+		if changed && onChange != nil {
+			onChange(s.Value())
+		}
+	}
+}
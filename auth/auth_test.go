@@ -0,0 +1,76 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretSources(t *testing.T) {
+	s, err := (&Config{Secret: "inline"}).Get(ifs.OS())
+	require.NoError(t, err)
+	assert.Equal(t, "inline", s.Value())
+
+	t.Setenv("TEST_NSQ_SECRET", "from-env")
+	s, err = (&Config{SecretEnv: "TEST_NSQ_SECRET"}).Get(ifs.OS())
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", s.Value())
+
+	_, err = (&Config{SecretEnv: "TEST_NSQ_SECRET_MISSING"}).Get(ifs.OS())
+	assert.Error(t, err)
+
+	_, err = (&Config{Secret: "a", SecretFile: "b"}).Get(ifs.OS())
+	assert.Error(t, err)
+
+	s, err = (&Config{}).Get(ifs.OS())
+	require.NoError(t, err)
+	assert.Nil(t, s)
+}
+
+func TestSecretFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0o600))
+
+	s, err := (&Config{SecretFile: path, ReloadInterval: 5 * time.Millisecond}).Get(ifs.OS())
+	require.NoError(t, err)
+	assert.Equal(t, "first", s.Value())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan string, 1)
+	go s.Watch(ctx, func(secret string) {
+		changes <- secret
+	}, nil)
+
+	require.NoError(t, os.WriteFile(path, []byte("second\n"), 0o600))
+	select {
+	case secret := <-changes:
+		assert.Equal(t, "second", secret)
+		assert.Equal(t, "second", s.Value())
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for secret rotation")
+	}
+}
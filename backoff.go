@@ -0,0 +1,73 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nsqcc
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// DefaultRetryMin is the delay after a first failed read.
+	DefaultRetryMin = 10 * time.Millisecond
+	// DefaultRetryMax caps the delay between failed reads.
+	DefaultRetryMax = 5 * time.Second
+)
+
+// RetryBackoff spaces out retries after consecutive failures, such as the
+// read errors of a broken connection, so that they do not spin. The delay
+// starts at Min and doubles with every failure up to Max. The zero value
+// uses DefaultRetryMin and DefaultRetryMax.
+type RetryBackoff struct {
+	Min, Max time.Duration
+
+	failures int
+}
+
+// Wait blocks for the delay of the next retry, or until ctx is done, in
+// which case it returns ctx.Err().
+func (b *RetryBackoff) Wait(ctx context.Context) error {
+	timer := time.NewTimer(b.next())
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reset starts over from Min once a retry has succeeded.
+func (b *RetryBackoff) Reset() {
+	b.failures = 0
+}
+
+func (b *RetryBackoff) next() time.Duration {
+	lo, hi := b.Min, b.Max
+	if lo <= 0 {
+		lo = DefaultRetryMin
+	}
+	if hi <= 0 {
+		hi = DefaultRetryMax
+	}
+	d := lo
+	for i := 0; i < b.failures && d < hi; i++ {
+		d *= 2
+	}
+	b.failures++
+	return min(d, hi)
+}
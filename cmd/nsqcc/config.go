@@ -0,0 +1,103 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/deepauto-io/nsqcc/config"
+	"github.com/deepauto-io/nsqcc/in"
+	"github.com/deepauto-io/nsqcc/out"
+	ntls "github.com/deepauto-io/nsqcc/tls"
+)
+
+var describers = map[string]func() []config.Field{
+	"reader": in.Describe,
+	"writer": out.Describe,
+	"tls":    ntls.Describe,
+}
+
+func configDescribe(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("config describe", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print the fields as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	describe, ok := describers[flags.Arg(0)]
+	if flags.NArg() != 1 || !ok {
+		fmt.Fprintln(stderr, "usage: nsqcc config describe [-json] reader|writer|tls")
+		return 2
+	}
+
+	fields := describe()
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(fields)
+		return 0
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tENV\tTYPE\tDEFAULT")
+	for _, f := range fields {
+		env := orDash(f.Env)
+		if f.LegacyEnv != "" {
+			env += " (or " + f.LegacyEnv + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.YAML, env, f.Type, orDash(f.Default))
+	}
+	_ = w.Flush()
+	return 0
+}
+
+var schemas = map[string]func() *config.Schema{
+	"reader": in.Schema,
+	"writer": out.Schema,
+	"tls":    ntls.Schema,
+}
+
+func configSchema(args []string, stdout, stderr io.Writer) int {
+	schema, ok := schemas[firstArg(args)]
+	if len(args) != 1 || !ok {
+		fmt.Fprintln(stderr, "usage: nsqcc config schema reader|writer|tls")
+		return 2
+	}
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(schema())
+	return 0
+}
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
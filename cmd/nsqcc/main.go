@@ -0,0 +1,211 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command nsqcc provides tooling for nsqcc configurations.
+//
+// Usage:
+//
+//	nsqcc tls inspect [-config paths] [-env-prefix prefix] [-addr host:port] [-timeout 5s] [-json] reader|writer
+//	nsqcc config describe [-json] reader|writer|tls
+//	nsqcc config schema reader|writer|tls
+//
+// tls inspect loads the configuration of a reader or a writer exactly as
+// they load it themselves, and inspects its TLS and auth settings. With
+// -config the configuration is loaded from the comma separated list of
+// files, see in.LoadConfig and out.LoadConfig. Otherwise it is loaded from
+// the environment with the application prefix -env-prefix, see
+// in.LoadEnvConfig and out.LoadEnvConfig: readers read NSQ_TLS_ENABLE and
+// writers NSQ_WRITER_TLS_ENABLE, and without -env-prefix both fall back to
+// the legacy TLS_ENABLE.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/auth"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/deepauto-io/nsqcc/in"
+	"github.com/deepauto-io/nsqcc/out"
+	ntls "github.com/deepauto-io/nsqcc/tls"
+)
+
+const usage = `usage: nsqcc <command> [flags]
+
+commands:
+  tls inspect        inspect the TLS settings of a reader or writer and optionally handshake with nsqd
+  config describe    list the fields of the reader, writer or tls configuration
+  config schema      print the JSON Schema of reader, writer or tls configuration files
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) >= 2 && args[0] == "tls" && args[1] == "inspect" {
+		return tlsInspect(args[2:], stdout, stderr)
+	}
+	if len(args) >= 2 && args[0] == "config" && args[1] == "describe" {
+		return configDescribe(args[2:], stdout, stderr)
+	}
+	if len(args) >= 2 && args[0] == "config" && args[1] == "schema" {
+		return configSchema(args[2:], stdout, stderr)
+	}
+	fmt.Fprint(stderr, usage)
+	return 2
+}
+
+func tlsInspect(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("tls inspect", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", "", "nsqd TCP address to handshake with")
+	timeout := flags.Duration("timeout", 5*time.Second, "handshake timeout")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	cfgPath := flags.String("config", "", "comma separated list of configuration files, instead of the environment")
+	envPrefix := flags.String("env-prefix", "", "prefix the application loads its environment variables with")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || (flags.Arg(0) != "reader" && flags.Arg(0) != "writer") {
+		fmt.Fprintln(stderr, "usage: nsqcc tls inspect [flags] reader|writer")
+		return 2
+	}
+
+	conf, authConf, err := loadTLS(flags.Arg(0), *cfgPath, *envPrefix)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	opts := ntls.InspectOptions{Address: *addr, Timeout: *timeout}
+	secret, err := authConf.Get(ifs.OS())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if secret != nil {
+		opts.AuthSecret = secret.Value()
+	}
+
+	report, err := ntls.Inspect(conf, ifs.OS(), opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load tls config: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	} else {
+		printReport(stdout, report)
+	}
+
+	if err := report.Err(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// loadTLS loads the TLS and auth configuration of a reader or a writer from
+// the files in cfgPath, or from the environment when cfgPath is empty.
+func loadTLS(target, cfgPath, envPrefix string) (ntls.Config, auth.Config, error) {
+	if target == "writer" {
+		var (
+			conf out.Config
+			err  error
+		)
+		if cfgPath != "" {
+			conf, err = out.LoadConfig(ifs.OS(), strings.Split(cfgPath, ",")...)
+		} else {
+			conf, err = out.LoadEnvConfig(envPrefix)
+		}
+		return conf.TLS, conf.Auth, err
+	}
+
+	var (
+		conf in.Config
+		err  error
+	)
+	if cfgPath != "" {
+		conf, err = in.LoadConfig(ifs.OS(), strings.Split(cfgPath, ",")...)
+	} else {
+		conf, err = in.LoadEnvConfig(envPrefix)
+	}
+	return conf.TLS, conf.Auth, err
+}
+
+func printReport(w io.Writer, r *ntls.Report) {
+	fmt.Fprintf(w, "tls enabled: %v\n", r.Enabled)
+	if len(r.ClientCertificates) == 0 {
+		fmt.Fprintln(w, "no client certificates configured")
+	}
+	for i, cc := range r.ClientCertificates {
+		fmt.Fprintf(w, "\nclient certificate %d:\n", i)
+		printCertificate(w, "  ", cc.CertificateInfo)
+		for j, c := range cc.Chain {
+			fmt.Fprintf(w, "  chain %d:\n", j)
+			printCertificate(w, "    ", c)
+		}
+		if cc.VerifyError != "" {
+			fmt.Fprintf(w, "  verify:     FAILED: %s\n", cc.VerifyError)
+		} else {
+			fmt.Fprintln(w, "  verify:     ok")
+		}
+	}
+
+	if h := r.Handshake; h != nil {
+		fmt.Fprintf(w, "\nhandshake with %s:\n", h.Address)
+		if h.Version != "" {
+			fmt.Fprintf(w, "  version:    %s\n  cipher:     %s\n", h.Version, h.CipherSuite)
+		}
+		for i, c := range h.Server {
+			fmt.Fprintf(w, "  server certificate %d:\n", i)
+			printCertificate(w, "    ", c)
+		}
+		if h.Error != "" {
+			fmt.Fprintf(w, "  result:     FAILED: %s\n", h.Error)
+		} else {
+			fmt.Fprintln(w, "  result:     ok")
+		}
+	}
+}
+
+func printCertificate(w io.Writer, indent string, c ntls.CertificateInfo) {
+	var sans []string
+	sans = append(sans, c.DNSNames...)
+	sans = append(sans, c.IPAddresses...)
+	sans = append(sans, c.URIs...)
+	sans = append(sans, c.Emails...)
+
+	expiry := c.NotAfter.Format(time.RFC3339)
+	if c.Expired {
+		expiry += " (EXPIRED)"
+	}
+	fmt.Fprintf(w, "%ssubject:    %s\n", indent, c.Subject)
+	fmt.Fprintf(w, "%ssans:       %s\n", indent, strings.Join(sans, ", "))
+	fmt.Fprintf(w, "%sissuer:     %s\n", indent, c.Issuer)
+	fmt.Fprintf(w, "%sexpires:    %s\n", indent, expiry)
+	fmt.Fprintf(w, "%skey:        %s\n", indent, c.KeyType)
+	fmt.Fprintf(w, "%sspki pin:   %s\n", indent, c.SPKIPin)
+}
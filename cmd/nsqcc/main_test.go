@@ -0,0 +1,161 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deepauto-io/nsqcc/config"
+	ntls "github.com/deepauto-io/nsqcc/tls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runArgs(args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestRunUsage(t *testing.T) {
+	tests := map[string]struct {
+		args   []string
+		stderr string
+	}{
+		"no command":               {nil, "usage: nsqcc <command>"},
+		"unknown command":          {[]string{"tls", "verify"}, "usage: nsqcc <command>"},
+		"describe without config":  {[]string{"config", "describe"}, "usage: nsqcc config describe"},
+		"describe unknown config":  {[]string{"config", "describe", "lookupd"}, "usage: nsqcc config describe"},
+		"describe unknown flag":    {[]string{"config", "describe", "-yaml", "reader"}, "flag provided but not defined"},
+		"schema without config":    {[]string{"config", "schema"}, "usage: nsqcc config schema"},
+		"schema too many configs":  {[]string{"config", "schema", "reader", "writer"}, "usage: nsqcc config schema"},
+		"inspect unknown flag":     {[]string{"tls", "inspect", "-verbose"}, "flag provided but not defined"},
+		"inspect without target":   {[]string{"tls", "inspect"}, "usage: nsqcc tls inspect"},
+		"inspect unknown target":   {[]string{"tls", "inspect", "lookupd"}, "usage: nsqcc tls inspect"},
+		"inspect invalid duration": {[]string{"tls", "inspect", "-timeout", "soon"}, "invalid value"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			code, stdout, stderr := runArgs(test.args...)
+			assert.Equal(t, 2, code)
+			assert.Empty(t, stdout)
+			assert.Contains(t, stderr, test.stderr)
+		})
+	}
+}
+
+func TestConfigDescribe(t *testing.T) {
+	code, stdout, stderr := runArgs("config", "describe", "reader")
+	require.Equal(t, 0, code, stderr)
+	lines := strings.Split(stdout, "\n")
+	assert.Equal(t, []string{"KEY", "ENV", "TYPE", "DEFAULT"}, strings.Fields(lines[0]))
+	assert.Contains(t, stdout, "NSQ_TOPIC")
+	assert.Contains(t, stdout, "NSQ_TLS_ENABLE (or TLS_ENABLE)")
+
+	code, stdout, stderr = runArgs("config", "describe", "-json", "writer")
+	require.Equal(t, 0, code, stderr)
+	var fields []config.Field
+	require.NoError(t, json.Unmarshal([]byte(stdout), &fields))
+	require.NotEmpty(t, fields)
+	byYAML := map[string]config.Field{}
+	for _, f := range fields {
+		byYAML[f.YAML] = f
+	}
+	assert.Equal(t, "NSQ_WRITER_ADDRESS", byYAML["address"].Env)
+	assert.Equal(t, "NSQ_WRITER_TLS_ENABLE", byYAML["tls.enabled"].Env)
+	assert.Equal(t, "TLS_ENABLE", byYAML["tls.enabled"].LegacyEnv)
+	assert.True(t, byYAML["auth.secret"].Secret)
+}
+
+func TestConfigSchema(t *testing.T) {
+	for _, name := range []string{"reader", "writer", "tls"} {
+		t.Run(name, func(t *testing.T) {
+			code, stdout, stderr := runArgs("config", "schema", name)
+			require.Equal(t, 0, code, stderr)
+
+			var schema config.Schema
+			require.NoError(t, json.Unmarshal([]byte(stdout), &schema))
+			assert.Equal(t, config.SchemaDialect, schema.Dialect)
+			assert.Equal(t, "object", schema.Type)
+			assert.NotEmpty(t, schema.Properties)
+		})
+	}
+}
+
+func TestTLSInspect(t *testing.T) {
+	t.Setenv("NSQCC_TEST_NSQ_TLS_ENABLE", "true")
+
+	code, stdout, stderr := runArgs("tls", "inspect", "-env-prefix", "NSQCC_TEST", "reader")
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "tls enabled: true\nno client certificates configured\n", stdout)
+
+	code, stdout, stderr = runArgs("tls", "inspect", "-env-prefix", "NSQCC_TEST", "-json", "reader")
+	require.Equal(t, 0, code, stderr)
+	var report ntls.Report
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.True(t, report.Enabled)
+
+	// Writers read their own variables.
+	code, stdout, stderr = runArgs("tls", "inspect", "-env-prefix", "NSQCC_TEST", "writer")
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "tls enabled: false")
+
+	// A failed handshake is reported and fails the command.
+	code, stdout, stderr = runArgs("tls", "inspect", "-env-prefix", "NSQCC_TEST", "-addr", "127.0.0.1:1", "-timeout", "100ms", "reader")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stdout, "result:     FAILED")
+	assert.Contains(t, stderr, "handshake with 127.0.0.1:1 failed")
+
+	t.Setenv("NSQCC_TEST_NSQ_TLS_ENABLE", "maybe")
+	code, _, stderr = runArgs("tls", "inspect", "-env-prefix", "NSQCC_TEST", "reader")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "NSQCC_TEST_NSQ_TLS_ENABLE")
+}
+
+func TestTLSInspectLegacyEnv(t *testing.T) {
+	t.Setenv("TLS_ENABLE", "true")
+	t.Setenv("NSQ_WRITER_TLS_ENABLE", "false")
+
+	// Without a prefix the legacy variables are a fallback.
+	code, stdout, stderr := runArgs("tls", "inspect", "reader")
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "tls enabled: true")
+
+	code, stdout, stderr = runArgs("tls", "inspect", "writer")
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "tls enabled: false")
+}
+
+func TestTLSInspectConfigFile(t *testing.T) {
+	t.Setenv("NSQ_TLS_ENABLE", "false")
+	path := filepath.Join(t.TempDir(), "reader.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("tls:\n  enabled: true\n"), 0o600))
+
+	code, stdout, stderr := runArgs("tls", "inspect", "-config", path, "reader")
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "tls enabled: true")
+
+	require.NoError(t, os.WriteFile(path, []byte("tls:\n  enabled: maybe\n"), 0o600))
+	code, _, stderr = runArgs("tls", "inspect", "-config", path, "writer")
+	assert.Equal(t, 1, code)
+	assert.NotEmpty(t, stderr)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package codec
+
+import (
+	"encoding/json"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// Avro returns a Codec that encodes values as Avro binary using the provided
+// schema. Values are mapped to Avro through their JSON representation, so
+// struct fields are matched to record fields by their json tags and union
+// values must follow the Avro JSON encoding.
+func Avro(schema string) (Codec, error) {
+	c, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, err
+	}
+	return &avroCodec{codec: c, schema: schema}, nil
+}
+
+type avroCodec struct {
+	codec  *goavro.Codec
+	schema string
+}
+
+func (a *avroCodec) Name() string {
+	return "avro"
+}
+
+func (a *avroCodec) Marshal(v any) ([]byte, error) {
+	textual, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	native, _, err := a.codec.NativeFromTextual(textual)
+	if err != nil {
+		return nil, err
+	}
+	return a.codec.BinaryFromNative(nil, native)
+}
+
+func (a *avroCodec) Unmarshal(data []byte, v any) error {
+	native, _, err := a.codec.NativeFromBinary(data)
+	if err != nil {
+		return err
+	}
+	textual, err := a.codec.TextualFromNative(nil, native)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(textual, v)
+}
+
+// withSchema returns a codec that decodes data written with the provided
+// schema. As Avro binary can only be read with the schema it was written
+// with, data is decoded with the writer schema and then resolved to the
+// schema of a, so that reader defaults, promotions and field changes apply.
+func (a *avroCodec) withSchema(s Schema) (Codec, error) {
+	writer, err := goavro.NewCodec(s.Definition)
+	if err != nil {
+		return nil, err
+	}
+	res, err := newAvroResolver(a.schema, s.Definition)
+	if err != nil {
+		return nil, err
+	}
+	return &avroResolvingCodec{avroCodec: a, writer: writer, resolver: res}, nil
+}
+
+// avroResolvingCodec decodes data written with another schema than the one
+// of its avroCodec.
+type avroResolvingCodec struct {
+	*avroCodec
+	writer   *goavro.Codec
+	resolver *avroResolver
+}
+
+func (a *avroResolvingCodec) Unmarshal(data []byte, v any) error {
+	native, _, err := a.writer.NativeFromBinary(data)
+	if err != nil {
+		return err
+	}
+	if native, err = a.resolver.Resolve(native); err != nil {
+		return err
+	}
+	// Re-encoding with the reader schema fills in the defaults of the
+	// fields the writer did not know about.
+	b, err := a.codec.BinaryFromNative(nil, native)
+	if err != nil {
+		return err
+	}
+	return a.avroCodec.Unmarshal(b, v)
+}
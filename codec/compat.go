@@ -0,0 +1,212 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// avroPromotions lists the writer types each reader type can be promoted
+// from, following the schema resolution rules of the Avro specification.
+var avroPromotions = map[string][]string{
+	"long":   {"int"},
+	"float":  {"int", "long"},
+	"double": {"int", "long", "float"},
+	"string": {"bytes"},
+	"bytes":  {"string"},
+}
+
+// avroCompat checks whether data written with one Avro schema can be read
+// with another. Named types are indexed by their full name, as by
+// avroResolver, see collectAvroFullNames.
+type avroCompat struct {
+	readerNames map[string]avroType
+	writerNames map[string]avroType
+	seen        map[string]bool
+}
+
+func avroCompatible(reader, writer string) error {
+	var r, w any
+	if err := json.Unmarshal([]byte(reader), &r); err != nil {
+		return fmt.Errorf("failed to parse reader schema: %w", err)
+	}
+	if err := json.Unmarshal([]byte(writer), &w); err != nil {
+		return fmt.Errorf("failed to parse writer schema: %w", err)
+	}
+
+	c := avroCompat{
+		readerNames: map[string]avroType{},
+		writerNames: map[string]avroType{},
+		seen:        map[string]bool{},
+	}
+	collectAvroFullNames(r, "", c.readerNames)
+	collectAvroFullNames(w, "", c.writerNames)
+	return c.check(avroType{schema: r}, avroType{schema: w}, "")
+}
+
+func avroTypeName(s any) string {
+	switch t := s.(type) {
+	case string:
+		return t
+	case []any:
+		return "union"
+	case map[string]any:
+		if ts, ok := t["type"].(string); ok {
+			return ts
+		}
+		return avroTypeName(t["type"])
+	}
+	return ""
+}
+
+// avroName returns the unqualified name of a named type.
+func avroName(m map[string]any) string {
+	name, _ := m["name"].(string)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func (c *avroCompat) check(r, w avroType, path string) error {
+	r = deref(r, c.readerNames)
+	w = deref(w, c.writerNames)
+
+	if wu, ok := w.schema.([]any); ok {
+		for _, branch := range wu {
+			if err := c.check(r, avroType{schema: branch, namespace: w.namespace}, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if ru, ok := r.schema.([]any); ok {
+		for _, branch := range ru {
+			if c.check(avroType{schema: branch, namespace: r.namespace}, w, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: writer type %s not found in reader union", avroPath(path), avroTypeName(w.schema))
+	}
+
+	rt, wt := avroTypeName(r.schema), avroTypeName(w.schema)
+	if rt != wt {
+		for _, from := range avroPromotions[rt] {
+			if from == wt {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: reader type %s cannot read writer type %s", avroPath(path), rt, wt)
+	}
+
+	rm, _ := r.schema.(map[string]any)
+	wm, _ := w.schema.(map[string]any)
+	switch rt {
+	case "record":
+		return c.checkRecord(r, w, path)
+	case "enum":
+		if avroName(rm) != avroName(wm) {
+			return fmt.Errorf("%s: enum %s does not match %s", avroPath(path), avroName(rm), avroName(wm))
+		}
+		if _, hasDefault := rm["default"]; hasDefault {
+			return nil
+		}
+		rSymbols, _ := rm["symbols"].([]any)
+		wSymbols, _ := wm["symbols"].([]any)
+		symbols := map[any]bool{}
+		for _, s := range rSymbols {
+			symbols[s] = true
+		}
+		for _, s := range wSymbols {
+			if !symbols[s] {
+				return fmt.Errorf("%s: enum symbol %v missing from reader", avroPath(path), s)
+			}
+		}
+	case "fixed":
+		if avroName(rm) != avroName(wm) || rm["size"] != wm["size"] {
+			return fmt.Errorf("%s: fixed %s does not match %s", avroPath(path), avroName(rm), avroName(wm))
+		}
+	case "array":
+		return c.check(
+			avroType{schema: rm["items"], namespace: r.namespace},
+			avroType{schema: wm["items"], namespace: w.namespace}, path+"[]")
+	case "map":
+		return c.check(
+			avroType{schema: rm["values"], namespace: r.namespace},
+			avroType{schema: wm["values"], namespace: w.namespace}, path+"{}")
+	}
+	return nil
+}
+
+// checkRecord checks two records, whose names match when their unqualified
+// names do, as in the Avro specification.
+func (c *avroCompat) checkRecord(r, w avroType, path string) error {
+	rm, _ := r.schema.(map[string]any)
+	wm, _ := w.schema.(map[string]any)
+	if avroName(rm) != avroName(wm) {
+		return fmt.Errorf("%s: record %s does not match %s", avroPath(path), avroName(rm), avroName(wm))
+	}
+
+	// Each pair of records is only checked once, which stops recursive types
+	// from recursing forever.
+	key := unionName(r) + "<-" + unionName(w)
+	if c.seen[key] {
+		return nil
+	}
+	c.seen[key] = true
+
+	writerFields := map[string]any{}
+	wFields, _ := wm["fields"].([]any)
+	for _, f := range wFields {
+		if fm, ok := f.(map[string]any); ok {
+			name, _ := fm["name"].(string)
+			writerFields[name] = fm["type"]
+		}
+	}
+
+	rFields, _ := rm["fields"].([]any)
+	for _, f := range rFields {
+		fm, ok := f.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := fm["name"].(string)
+		ws, exists := writerFields[name]
+		if !exists {
+			if _, hasDefault := fm["default"]; !hasDefault {
+				return fmt.Errorf("%s: reader field %s is missing from writer and has no default", avroPath(path), name)
+			}
+			continue
+		}
+		err := c.check(
+			avroType{schema: fm["type"], namespace: childNamespace(r)},
+			avroType{schema: ws, namespace: childNamespace(w)}, path+"."+name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func avroPath(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
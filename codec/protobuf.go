@@ -0,0 +1,71 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package codec
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Protobuf returns a Codec for values implementing proto.Message. Unmarshal
+// accepts either a message or a pointer to a message pointer, which allows
+// it to be used with TypedReader[*pb.Message].
+func Protobuf() Codec {
+	return protobufCodec{}
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return "protobuf"
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("value of type %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Ptr {
+			return fmt.Errorf("value of type %T is not a proto.Message", v)
+		}
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if m, ok = rv.Elem().Interface().(proto.Message); !ok {
+			return fmt.Errorf("value of type %T is not a proto.Message", v)
+		}
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// protobufMessageName returns the full name of the message type of v, which is
+// what protobuf schemas are compared by.
+func protobufMessageName(v any) string {
+	if m, ok := v.(proto.Message); ok {
+		return string(m.ProtoReflect().Descriptor().FullName())
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/linkedin/goavro/v2"
+)
+
+type registryManifest struct {
+	Schemas []struct {
+		ID         uint32     `json:"id"`
+		Subject    string     `json:"subject"`
+		Type       SchemaType `json:"type"`
+		Schema     string     `json:"schema"`
+		SchemaFile string     `json:"schema_file"`
+	} `json:"schemas"`
+}
+
+// FileRegistry is a SchemaRegistry backed by a JSON manifest file, which
+// stands in for a schema registry service. The manifest lists schemas by ID,
+// either inline or by a schema_file path relative to the manifest:
+//
+//	{"schemas": [{"id": 1, "subject": "orders", "type": "AVRO", "schema_file": "orders.avsc"}]}
+type FileRegistry struct {
+	schemas map[uint32]Schema
+}
+
+var _ SchemaRegistry = (*FileRegistry)(nil)
+
+// NewFileRegistry loads the manifest name from f.
+func NewFileRegistry(f ifs.FS, name string) (*FileRegistry, error) {
+	b, err := ifs.ReadFile(f, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var m registryManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse schema manifest %s: %w", name, err)
+	}
+
+	r := &FileRegistry{schemas: map[uint32]Schema{}}
+	for _, s := range m.Schemas {
+		if _, exists := r.schemas[s.ID]; exists {
+			return nil, fmt.Errorf("duplicate schema id %d in %s", s.ID, name)
+		}
+
+		def := s.Schema
+		if s.SchemaFile != "" {
+			if def != "" {
+				return nil, fmt.Errorf("only one field between schema and schema_file can be specified for schema %d", s.ID)
+			}
+			b, err := ifs.ReadFile(f, path.Join(path.Dir(name), s.SchemaFile))
+			if err != nil {
+				return nil, err
+			}
+			def = string(b)
+		}
+
+		switch s.Type {
+		case SchemaAvro:
+			if _, err := goavro.NewCodec(def); err != nil {
+				return nil, fmt.Errorf("invalid avro schema %d: %w", s.ID, err)
+			}
+		case SchemaProtobuf:
+			def = strings.TrimSpace(def)
+		case SchemaJSON:
+		default:
+			return nil, fmt.Errorf("unknown type %q for schema %d", s.Type, s.ID)
+		}
+
+		r.schemas[s.ID] = Schema{
+			ID:         s.ID,
+			Subject:    s.Subject,
+			Type:       s.Type,
+			Definition: def,
+		}
+	}
+	return r, nil
+}
+
+// Lookup returns the schema registered with id.
+func (r *FileRegistry) Lookup(id uint32) (Schema, error) {
+	s, ok := r.schemas[id]
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: %d", ErrUnknownSchema, id)
+	}
+	return s, nil
+}
@@ -0,0 +1,356 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// avroType is a schema along with the namespace enclosing it, which names
+// referenced by the schema are relative to.
+type avroType struct {
+	schema    any
+	namespace string
+}
+
+// avroResolver converts values decoded with a writer schema into values of
+// a reader schema, following the schema resolution rules of the Avro
+// specification: writer fields unknown to the reader are dropped, numbers
+// are promoted, union branches are matched by type and unknown enum symbols
+// fall back to the reader default. Reader fields missing from the writer
+// are left out, for the reader codec to fill in with their defaults.
+//
+// Values are in the native form of goavro, and the schemas are expected to
+// have passed avroCompatible.
+type avroResolver struct {
+	reader, writer           avroType
+	readerNames, writerNames map[string]avroType
+}
+
+func newAvroResolver(reader, writer string) (*avroResolver, error) {
+	var r, w any
+	if err := json.Unmarshal([]byte(reader), &r); err != nil {
+		return nil, fmt.Errorf("failed to parse reader schema: %w", err)
+	}
+	if err := json.Unmarshal([]byte(writer), &w); err != nil {
+		return nil, fmt.Errorf("failed to parse writer schema: %w", err)
+	}
+	res := &avroResolver{
+		reader:      avroType{schema: r},
+		writer:      avroType{schema: w},
+		readerNames: map[string]avroType{},
+		writerNames: map[string]avroType{},
+	}
+	collectAvroFullNames(r, "", res.readerNames)
+	collectAvroFullNames(w, "", res.writerNames)
+	return res, nil
+}
+
+// collectAvroFullNames indexes the named types of a schema by their full
+// name, along with the namespace they define for the types they contain.
+func collectAvroFullNames(s any, namespace string, names map[string]avroType) {
+	switch t := s.(type) {
+	case []any:
+		for _, b := range t {
+			collectAvroFullNames(b, namespace, names)
+		}
+	case map[string]any:
+		if _, ok := t["name"].(string); ok {
+			full := avroFullName(t, namespace)
+			names[full] = avroType{schema: t, namespace: avroNamespace(full)}
+			namespace = avroNamespace(full)
+		}
+		collectAvroFullNames(t["type"], namespace, names)
+		collectAvroFullNames(t["items"], namespace, names)
+		collectAvroFullNames(t["values"], namespace, names)
+		if fields, ok := t["fields"].([]any); ok {
+			for _, f := range fields {
+				if fm, ok := f.(map[string]any); ok {
+					collectAvroFullNames(fm["type"], namespace, names)
+				}
+			}
+		}
+	}
+}
+
+// avroFullName returns the full name of a named type defined in namespace.
+func avroFullName(m map[string]any, namespace string) string {
+	name, _ := m["name"].(string)
+	if strings.Contains(name, ".") {
+		return name
+	}
+	if ns, _ := m["namespace"].(string); ns != "" {
+		namespace = ns
+	}
+	if namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func avroNamespace(full string) string {
+	if i := strings.LastIndex(full, "."); i >= 0 {
+		return full[:i]
+	}
+	return ""
+}
+
+// deref resolves references to named types and unwraps primitive types
+// written as {"type": "int"}.
+func deref(t avroType, names map[string]avroType) avroType {
+	switch s := t.schema.(type) {
+	case string:
+		if avroPrimitives[s] {
+			return t
+		}
+		if t.namespace != "" && !strings.Contains(s, ".") {
+			if named, ok := names[t.namespace+"."+s]; ok {
+				return named
+			}
+		}
+		if named, ok := names[s]; ok {
+			return named
+		}
+	case map[string]any:
+		if _, named := s["name"]; named || s["logicalType"] != nil {
+			return t
+		}
+		if inner, ok := s["type"]; ok && s["items"] == nil && s["values"] == nil {
+			if ts, ok := inner.(string); !ok || (ts != "array" && ts != "map") {
+				return deref(avroType{schema: inner, namespace: t.namespace}, names)
+			}
+		}
+	}
+	return t
+}
+
+// unionName returns the name goavro keys union values of a dereferenced
+// type with.
+func unionName(t avroType) string {
+	m, ok := t.schema.(map[string]any)
+	if !ok {
+		return avroTypeName(t.schema)
+	}
+	if _, named := m["name"]; named {
+		return avroFullName(m, t.namespace)
+	}
+	if lt, ok := m["logicalType"].(string); ok {
+		return avroTypeName(m) + "." + lt
+	}
+	return avroTypeName(m)
+}
+
+// childNamespace returns the namespace enclosing the types contained in t.
+func childNamespace(t avroType) string {
+	if m, ok := t.schema.(map[string]any); ok {
+		if _, named := m["name"]; named {
+			return avroNamespace(avroFullName(m, t.namespace))
+		}
+	}
+	return t.namespace
+}
+
+// Resolve converts v, decoded with the writer schema, to the reader schema.
+func (r *avroResolver) Resolve(v any) (any, error) {
+	return r.resolve(r.reader, r.writer, v, "")
+}
+
+func (r *avroResolver) resolve(rt, wt avroType, v any, path string) (any, error) {
+	rt = deref(rt, r.readerNames)
+	wt = deref(wt, r.writerNames)
+
+	if branches, ok := wt.schema.([]any); ok {
+		name, value := "null", v
+		if m, ok := v.(map[string]any); ok && len(m) == 1 {
+			for name, value = range m {
+			}
+		}
+		for _, b := range branches {
+			bt := deref(avroType{schema: b, namespace: wt.namespace}, r.writerNames)
+			if unionName(bt) == name {
+				return r.resolve(rt, bt, value, path)
+			}
+		}
+		return nil, fmt.Errorf("%s: value of unknown writer union branch %s", avroPath(path), name)
+	}
+
+	if branches, ok := rt.schema.([]any); ok {
+		bt, ok := r.readerBranch(branches, rt.namespace, wt)
+		if !ok {
+			return nil, fmt.Errorf("%s: writer type %s not found in reader union", avroPath(path), avroTypeName(wt.schema))
+		}
+		value, err := r.resolve(bt, wt, v, path)
+		if err != nil || value == nil {
+			return value, err
+		}
+		return map[string]any{unionName(bt): value}, nil
+	}
+
+	rm, _ := rt.schema.(map[string]any)
+	wm, _ := wt.schema.(map[string]any)
+	switch avroTypeName(rt.schema) {
+	case "record":
+		in, ok := v.(map[string]any)
+		if !ok {
+			return v, nil
+		}
+		writerFields := map[string]any{}
+		wFields, _ := wm["fields"].([]any)
+		for _, f := range wFields {
+			if fm, ok := f.(map[string]any); ok {
+				name, _ := fm["name"].(string)
+				writerFields[name] = fm["type"]
+			}
+		}
+		out := make(map[string]any, len(in))
+		rFields, _ := rm["fields"].([]any)
+		for _, f := range rFields {
+			fm, ok := f.(map[string]any)
+			if !ok {
+				continue
+			}
+			name, _ := fm["name"].(string)
+			ws, exists := writerFields[name]
+			if !exists {
+				continue
+			}
+			value, err := r.resolve(
+				avroType{schema: fm["type"], namespace: childNamespace(rt)},
+				avroType{schema: ws, namespace: childNamespace(wt)},
+				in[name], path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = value
+		}
+		return out, nil
+	case "enum":
+		symbols, _ := rm["symbols"].([]any)
+		for _, s := range symbols {
+			if s == v {
+				return v, nil
+			}
+		}
+		if def, ok := rm["default"]; ok {
+			return def, nil
+		}
+		return nil, fmt.Errorf("%s: enum symbol %v missing from reader", avroPath(path), v)
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return v, nil
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			value, err := r.resolve(
+				avroType{schema: rm["items"], namespace: rt.namespace},
+				avroType{schema: wm["items"], namespace: wt.namespace},
+				item, path+"[]")
+			if err != nil {
+				return nil, err
+			}
+			out[i] = value
+		}
+		return out, nil
+	case "map":
+		values, ok := v.(map[string]any)
+		if !ok {
+			return v, nil
+		}
+		out := make(map[string]any, len(values))
+		for k, item := range values {
+			value, err := r.resolve(
+				avroType{schema: rm["values"], namespace: rt.namespace},
+				avroType{schema: wm["values"], namespace: wt.namespace},
+				item, path+"{}")
+			if err != nil {
+				return nil, err
+			}
+			out[k] = value
+		}
+		return out, nil
+	}
+	return promoteAvro(avroTypeName(rt.schema), v), nil
+}
+
+// readerBranch picks the reader union branch a writer type resolves to: the
+// first one of the same type, or else the first one it can be promoted to.
+func (r *avroResolver) readerBranch(branches []any, namespace string, wt avroType) (avroType, bool) {
+	var promoted *avroType
+	for _, b := range branches {
+		bt := deref(avroType{schema: b, namespace: namespace}, r.readerNames)
+		// The resolver is shared by concurrent decoders, so every check gets
+		// its own seen set.
+		c := avroCompat{readerNames: r.readerNames, writerNames: r.writerNames, seen: map[string]bool{}}
+		if c.check(bt, wt, "") != nil {
+			continue
+		}
+		if avroTypeName(bt.schema) == avroTypeName(wt.schema) {
+			return bt, true
+		}
+		if promoted == nil {
+			promoted = &bt
+		}
+	}
+	if promoted != nil {
+		return *promoted, true
+	}
+	return avroType{}, false
+}
+
+// promoteAvro converts a primitive value to the native type of the reader
+// type it is promoted to.
+func promoteAvro(readerType string, v any) any {
+	switch readerType {
+	case "long":
+		if n, ok := v.(int32); ok {
+			return int64(n)
+		}
+	case "float":
+		switch n := v.(type) {
+		case int32:
+			return float32(n)
+		case int64:
+			return float32(n)
+		}
+	case "double":
+		switch n := v.(type) {
+		case int32:
+			return float64(n)
+		case int64:
+			return float64(n)
+		case float32:
+			return float64(n)
+		}
+	case "string":
+		if b, ok := v.([]byte); ok {
+			return string(b)
+		}
+	case "bytes":
+		if s, ok := v.(string); ok {
+			return []byte(s)
+		}
+	}
+	return v
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownSchema      = errors.New("unknown schema")
+	ErrIncompatibleSchema = errors.New("incompatible schema")
+)
+
+// SchemaType is the kind of definition held by a Schema.
+type SchemaType string
+
+const (
+	SchemaAvro     SchemaType = "AVRO"
+	SchemaProtobuf SchemaType = "PROTOBUF"
+	SchemaJSON     SchemaType = "JSON"
+)
+
+// Schema is a versioned message definition. For Avro the definition is the
+// schema JSON, for protobuf it is the full name of the message type.
+type Schema struct {
+	ID         uint32
+	Subject    string
+	Type       SchemaType
+	Definition string
+}
+
+// SchemaRegistry resolves schema IDs found in message payloads.
+type SchemaRegistry interface {
+	Lookup(id uint32) (Schema, error)
+}
+
+// schemaHeaderLen is the length of the schema ID prefix: a zero magic byte
+// followed by a big endian uint32 ID, as used by the Confluent wire format.
+const schemaHeaderLen = 5
+
+func schemaTypeOf(c Codec) SchemaType {
+	return SchemaType(strings.ToUpper(c.Name()))
+}
+
+type schemaCodec struct {
+	codec    Codec
+	reg      SchemaRegistry
+	schema   Schema
+	decoders sync.Map
+}
+
+// WithSchema wraps c so that encoded payloads are prefixed with the schema ID
+// id, and decoded payloads are checked against it. Payloads written with a
+// different schema are decoded only if that schema is compatible with id,
+// otherwise ErrIncompatibleSchema is returned.
+func WithSchema(c Codec, reg SchemaRegistry, id uint32) (Codec, error) {
+	s, err := reg.Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	if t := schemaTypeOf(c); t != s.Type {
+		return nil, fmt.Errorf("%w: schema %d is of type %s, codec is %s", ErrIncompatibleSchema, id, s.Type, t)
+	}
+	return &schemaCodec{codec: c, reg: reg, schema: s}, nil
+}
+
+func (s *schemaCodec) Name() string {
+	return s.codec.Name()
+}
+
+func (s *schemaCodec) Marshal(v any) ([]byte, error) {
+	if s.schema.Type == SchemaProtobuf {
+		if name := protobufMessageName(v); name != s.schema.Definition {
+			return nil, fmt.Errorf("%w: message %s does not match schema %d (%s)", ErrIncompatibleSchema, name, s.schema.ID, s.schema.Definition)
+		}
+	}
+
+	b, err := s.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, schemaHeaderLen, schemaHeaderLen+len(b))
+	binary.BigEndian.PutUint32(out[1:], s.schema.ID)
+	return append(out, b...), nil
+}
+
+func (s *schemaCodec) Unmarshal(data []byte, v any) error {
+	if len(data) < schemaHeaderLen || data[0] != 0 {
+		return errors.New("payload is missing a schema id prefix")
+	}
+	id := binary.BigEndian.Uint32(data[1:schemaHeaderLen])
+	data = data[schemaHeaderLen:]
+	if id == s.schema.ID {
+		return s.codec.Unmarshal(data, v)
+	}
+
+	dec, err := s.decoder(id)
+	if err != nil {
+		return err
+	}
+	return dec.Unmarshal(data, v)
+}
+
+func (s *schemaCodec) decoder(id uint32) (Codec, error) {
+	if dec, ok := s.decoders.Load(id); ok {
+		return dec.(Codec), nil
+	}
+
+	writer, err := s.reg.Lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := Compatible(s.schema, writer); err != nil {
+		return nil, err
+	}
+
+	dec := s.codec
+	if r, ok := s.codec.(interface{ withSchema(Schema) (Codec, error) }); ok {
+		if dec, err = r.withSchema(writer); err != nil {
+			return nil, err
+		}
+	}
+	s.decoders.Store(id, dec)
+	return dec, nil
+}
+
+// Compatible returns an error wrapping ErrIncompatibleSchema if data written
+// with the writer schema cannot be read with the reader schema.
+func Compatible(reader, writer Schema) error {
+	if reader.Type != writer.Type {
+		return fmt.Errorf("%w: reader schema %d is %s, writer schema %d is %s", ErrIncompatibleSchema, reader.ID, reader.Type, writer.ID, writer.Type)
+	}
+	if reader.ID == writer.ID {
+		return nil
+	}
+
+	switch reader.Type {
+	case SchemaAvro:
+		if err := avroCompatible(reader.Definition, writer.Definition); err != nil {
+			return fmt.Errorf("%w: reader schema %d, writer schema %d: %v", ErrIncompatibleSchema, reader.ID, writer.ID, err)
+		}
+	case SchemaProtobuf:
+		if reader.Definition != writer.Definition {
+			return fmt.Errorf("%w: reader schema %d is %s, writer schema %d is %s", ErrIncompatibleSchema, reader.ID, reader.Definition, writer.ID, writer.Definition)
+		}
+	}
+	return nil
+}
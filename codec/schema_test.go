@@ -0,0 +1,184 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package codec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	orderSchemaV1 = `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"total","type":"int"}]}`
+	orderSchemaV2 = `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"total","type":"long"},{"name":"note","type":"string","default":""}]}`
+	orderSchemaV3 = `{"type":"record","name":"Order","fields":[{"name":"id","type":"int"}]}`
+)
+
+func newTestRegistry(t *testing.T) *FileRegistry {
+	t.Helper()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "order_v2.avsc"), []byte(orderSchemaV2), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "schemas.json"), []byte(`{"schemas": [
+		{"id": 1, "subject": "orders", "type": "AVRO", "schema": `+quoteJSON(orderSchemaV1)+`},
+		{"id": 2, "subject": "orders", "type": "AVRO", "schema_file": "order_v2.avsc"},
+		{"id": 3, "subject": "orders", "type": "AVRO", "schema": `+quoteJSON(orderSchemaV3)+`},
+		{"id": 10, "subject": "names", "type": "PROTOBUF", "schema": "google.protobuf.StringValue"}
+	]}`), 0o644))
+
+	reg, err := NewFileRegistry(ifs.OS(), filepath.Join(dir, "schemas.json"))
+	require.NoError(t, err)
+	return reg
+}
+
+func quoteJSON(s string) string {
+	b, _ := JSON().Marshal(s)
+	return string(b)
+}
+
+func TestAvroSchemaEvolution(t *testing.T) {
+	reg := newTestRegistry(t)
+
+	v1, err := Avro(orderSchemaV1)
+	require.NoError(t, err)
+	w, err := WithSchema(v1, reg, 1)
+	require.NoError(t, err)
+
+	b, err := w.Marshal(order{ID: "a", Total: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 1}, b[:5])
+
+	v2, err := Avro(orderSchemaV2)
+	require.NoError(t, err)
+	r, err := WithSchema(v2, reg, 2)
+	require.NoError(t, err)
+
+	var o order
+	require.NoError(t, r.Unmarshal(b, &o))
+	assert.Equal(t, order{ID: "a", Total: 3}, o)
+
+	v3, err := Avro(orderSchemaV3)
+	require.NoError(t, err)
+	r, err = WithSchema(v3, reg, 3)
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.Unmarshal(b, &o), ErrIncompatibleSchema)
+
+	_, err = WithSchema(JSON(), reg, 1)
+	assert.ErrorIs(t, err, ErrIncompatibleSchema)
+}
+
+func TestAvroCompatibleNamespaces(t *testing.T) {
+	schema := func(bType string) string {
+		return `{"type": "record", "name": "Order", "fields": [
+			{"name": "a", "type": {"type": "record", "name": "Id", "namespace": "a", "fields": [{"name": "v", "type": "string"}]}},
+			{"name": "b", "type": {"type": "record", "name": "Id", "namespace": "b", "fields": [{"name": "v", "type": "` + bType + `"}]}},
+			{"name": "c", "type": "a.Id"}
+		]}`
+	}
+	require.NoError(t, avroCompatible(schema("long"), schema("int")))
+	// Records sharing a short name are still checked on their own.
+	assert.ErrorContains(t, avroCompatible(schema("int"), schema("string")), ".b.v: reader type int cannot read writer type string")
+}
+
+type mapRegistry map[uint32]Schema
+
+func (m mapRegistry) Lookup(id uint32) (Schema, error) {
+	s, ok := m[id]
+	if !ok {
+		return Schema{}, ErrUnknownSchema
+	}
+	return s, nil
+}
+
+func TestAvroSchemaResolution(t *testing.T) {
+	const (
+		writerSchema = `{"type":"record","name":"Order","namespace":"shop","fields":[
+			{"name":"id","type":"string"},
+			{"name":"total","type":"int"},
+			{"name":"status","type":{"type":"enum","name":"Status","symbols":["NEW","LEGACY"]}},
+			{"name":"tags","type":{"type":"array","items":"int"}},
+			{"name":"maybe","type":["null","int"]},
+			{"name":"dropped","type":"string"}]}`
+		readerSchema = `{"type":"record","name":"Order","namespace":"shop","fields":[
+			{"name":"id","type":"string"},
+			{"name":"total","type":"long"},
+			{"name":"status","type":{"type":"enum","name":"Status","symbols":["NEW","DONE"],"default":"NEW"}},
+			{"name":"tags","type":{"type":"array","items":"double"}},
+			{"name":"maybe","type":["null","long"]},
+			{"name":"note","type":"string","default":"n/a"}]}`
+	)
+	reg := mapRegistry{
+		1: {ID: 1, Type: SchemaAvro, Definition: writerSchema},
+		2: {ID: 2, Type: SchemaAvro, Definition: readerSchema},
+	}
+
+	wc, err := Avro(writerSchema)
+	require.NoError(t, err)
+	w, err := WithSchema(wc, reg, 1)
+	require.NoError(t, err)
+	b, err := w.Marshal(map[string]any{
+		"id": "a", "total": 3, "status": "LEGACY", "tags": []int{1, 2},
+		"maybe": map[string]any{"int": 7}, "dropped": "x",
+	})
+	require.NoError(t, err)
+
+	rc, err := Avro(readerSchema)
+	require.NoError(t, err)
+	r, err := WithSchema(rc, reg, 2)
+	require.NoError(t, err)
+
+	var o struct {
+		ID     string           `json:"id"`
+		Total  int64            `json:"total"`
+		Status string           `json:"status"`
+		Tags   []float64        `json:"tags"`
+		Maybe  map[string]int64 `json:"maybe"`
+		Note   string           `json:"note"`
+	}
+	require.NoError(t, r.Unmarshal(b, &o))
+	assert.Equal(t, "a", o.ID)
+	assert.Equal(t, int64(3), o.Total)
+	assert.Equal(t, "NEW", o.Status, "unknown symbols resolve to the reader default")
+	assert.Equal(t, []float64{1, 2}, o.Tags)
+	assert.Equal(t, map[string]int64{"long": 7}, o.Maybe)
+	assert.Equal(t, "n/a", o.Note, "missing fields resolve to the reader default")
+}
+
+func TestProtobufSchema(t *testing.T) {
+	reg := newTestRegistry(t)
+
+	c, err := WithSchema(Protobuf(), reg, 10)
+	require.NoError(t, err)
+
+	b, err := c.Marshal(wrapperspb.String("hello"))
+	require.NoError(t, err)
+
+	var v *wrapperspb.StringValue
+	require.NoError(t, c.Unmarshal(b, &v))
+	assert.Equal(t, "hello", v.GetValue())
+
+	_, err = c.Marshal(wrapperspb.Int32(1))
+	assert.ErrorIs(t, err, ErrIncompatibleSchema)
+
+	_, err = reg.Lookup(99)
+	assert.ErrorIs(t, err, ErrUnknownSchema)
+}
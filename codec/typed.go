@@ -0,0 +1,174 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package codec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/deepauto-io/nsqcc"
+	"github.com/nsqio/go-nsq"
+)
+
+// PoisonPolicy determines what happens to messages that cannot be decoded.
+type PoisonPolicy string
+
+const (
+	// PoisonRequeue requeues undecodable messages, they are eventually
+	// dropped by nsqd once they exceed the max attempts of the reader.
+	PoisonRequeue PoisonPolicy = "requeue"
+	// PoisonDrop finishes undecodable messages without processing them.
+	PoisonDrop PoisonPolicy = "drop"
+	// PoisonDLQ writes undecodable messages to a dead letter topic and
+	// finishes them once the write succeeds.
+	PoisonDLQ PoisonPolicy = "dlq"
+)
+
+// TypedReaderConfig contains configuration params for a TypedReader.
+type TypedReaderConfig struct {
+	// Codec decodes message bodies, defaults to JSON.
+	Codec Codec
+	// Poison is the policy applied to undecodable messages, defaults to
+	// PoisonRequeue.
+	Poison PoisonPolicy
+	// DLQ and DLQTopic are where undecodable messages are written with the
+	// PoisonDLQ policy.
+	DLQ      nsqcc.AsyncSink
+	DLQTopic string
+	// OnPoison, if set, is called with every undecodable message.
+	OnPoison func(msg *nsq.Message, err error)
+}
+
+// TypedReader wraps an nsqcc.Async and decodes message bodies into values of
+// type T.
+type TypedReader[T any] struct {
+	nsqcc.Async
+	conf TypedReaderConfig
+}
+
+// NewTypedReader creates a new TypedReader reading from r.
+func NewTypedReader[T any](r nsqcc.Async, conf TypedReaderConfig) (*TypedReader[T], error) {
+	if conf.Codec == nil {
+		conf.Codec = JSON()
+	}
+	switch conf.Poison {
+	case "":
+		conf.Poison = PoisonRequeue
+	case PoisonRequeue, PoisonDrop:
+	case PoisonDLQ:
+		if conf.DLQ == nil || conf.DLQTopic == "" {
+			return nil, errors.New("dlq poison policy requires a dlq sink and topic")
+		}
+	default:
+		return nil, fmt.Errorf("unknown poison policy: %s", conf.Poison)
+	}
+	return &TypedReader[T]{Async: r, conf: conf}, nil
+}
+
+// Read returns the next message that decodes successfully, along with the
+// raw message and a function to acknowledge it. Undecodable messages are
+// handled according to the poison policy and skipped. Messages the
+// underlying reader failed to decode have already been finished or requeued
+// by it, so only OnPoison and the dead letter queue apply to them.
+func (t *TypedReader[T]) Read(ctx context.Context) (T, *nsq.Message, nsqcc.AsyncAckFn, error) {
+	var zero T
+	for {
+		msg, ack, err := t.ReadBatch(ctx)
+		var decodeErr *nsqcc.DecodeError
+		if errors.As(err, &decodeErr) {
+			if err = t.poison(ctx, decodeErr.Msg, settled, err); err != nil {
+				return zero, nil, nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return zero, nil, nil, err
+		}
+
+		v, err := t.decode(msg.Body)
+		if err == nil {
+			return v, msg, ack, nil
+		}
+		if err = t.poison(ctx, msg, ack, err); err != nil {
+			return zero, nil, nil, err
+		}
+	}
+}
+
+func (t *TypedReader[T]) decode(body []byte) (T, error) {
+	var v T
+	env, err := nsqcc.DecodeEnvelope(body)
+	if err != nil {
+		return v, err
+	}
+	if err := t.conf.Codec.Unmarshal(env.Body, &v); err != nil {
+		return v, fmt.Errorf("failed to decode %s message: %w", t.conf.Codec.Name(), err)
+	}
+	return v, nil
+}
+
+// settled acknowledges messages that the underlying reader has already
+// finished or requeued. Only a failed dead letter write is returned.
+func settled(ctx context.Context, res error) error {
+	if errors.Is(res, nsqcc.ErrDecode) {
+		return nil
+	}
+	return res
+}
+
+func (t *TypedReader[T]) poison(ctx context.Context, msg *nsq.Message, ack nsqcc.AsyncAckFn, decodeErr error) error {
+	if t.conf.OnPoison != nil {
+		t.conf.OnPoison(msg, decodeErr)
+	}
+
+	switch t.conf.Poison {
+	case PoisonDrop:
+		return ack(ctx, nil)
+	case PoisonDLQ:
+		if err := t.conf.DLQ.WriteWithContext(ctx, t.conf.DLQTopic, msg.Body); err != nil {
+			return ack(ctx, err)
+		}
+		return ack(ctx, nil)
+	}
+	return ack(ctx, decodeErr)
+}
+
+// TypedWriter wraps an nsqcc.AsyncSink and encodes values of type T into
+// message bodies.
+type TypedWriter[T any] struct {
+	nsqcc.AsyncSink
+	codec Codec
+}
+
+// NewTypedWriter creates a new TypedWriter writing to w. A nil codec defaults
+// to JSON.
+func NewTypedWriter[T any](w nsqcc.AsyncSink, c Codec) *TypedWriter[T] {
+	if c == nil {
+		c = JSON()
+	}
+	return &TypedWriter[T]{AsyncSink: w, codec: c}
+}
+
+// Write encodes v and writes it to topic.
+func (t *TypedWriter[T]) Write(ctx context.Context, topic string, v T) error {
+	b, err := t.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s message: %w", t.codec.Name(), err)
+	}
+	return t.WriteWithContext(ctx, topic, b)
+}
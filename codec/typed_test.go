@@ -0,0 +1,150 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package codec
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/deepauto-io/nsqcc"
+	"github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type order struct {
+	ID    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+type memQueue struct {
+	msgs  [][]byte
+	acked map[string]error
+}
+
+func (m *memQueue) Connect(ctx context.Context) error { return nil }
+func (m *memQueue) Close(ctx context.Context) error   { return nil }
+
+func (m *memQueue) WriteWithContext(ctx context.Context, topic string, msg []byte) error {
+	m.msgs = append(m.msgs, msg)
+	return nil
+}
+
+func (m *memQueue) ReadBatch(ctx context.Context) (*nsq.Message, nsqcc.AsyncAckFn, error) {
+	if len(m.msgs) == 0 {
+		return nil, nil, nsqcc.ErrTypeClosed
+	}
+	body := m.msgs[0]
+	m.msgs = m.msgs[1:]
+	return nsq.NewMessage(nsq.MessageID{}, body), func(ctx context.Context, res error) error {
+		m.acked[string(body)] = res
+		return nil
+	}, nil
+}
+
+func TestTypedReaderWriter(t *testing.T) {
+	q := &memQueue{acked: map[string]error{}}
+	dlq := &memQueue{}
+
+	w := NewTypedWriter[order](q, nil)
+	require.NoError(t, w.Write(context.Background(), "orders", order{ID: "a", Total: 3}))
+	require.NoError(t, q.WriteWithContext(context.Background(), "orders", []byte("not json")))
+	require.NoError(t, w.Write(context.Background(), "orders", order{ID: "b", Total: 5}))
+
+	var poisoned int
+	r, err := NewTypedReader[order](q, TypedReaderConfig{
+		Poison:   PoisonDLQ,
+		DLQ:      dlq,
+		DLQTopic: "orders_dlq",
+		OnPoison: func(msg *nsq.Message, err error) {
+			poisoned++
+		},
+	})
+	require.NoError(t, err)
+
+	v, _, ack, err := r.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, order{ID: "a", Total: 3}, v)
+	require.NoError(t, ack(context.Background(), nil))
+
+	v, _, _, err = r.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, order{ID: "b", Total: 5}, v)
+
+	assert.Equal(t, 1, poisoned)
+	assert.Equal(t, [][]byte{[]byte("not json")}, dlq.msgs)
+	assert.NoError(t, q.acked["not json"])
+
+	_, _, _, err = r.Read(context.Background())
+	assert.ErrorIs(t, err, nsqcc.ErrTypeClosed)
+}
+
+func TestTypedReaderPoisonPolicies(t *testing.T) {
+	_, err := NewTypedReader[order](&memQueue{}, TypedReaderConfig{Poison: PoisonDLQ})
+	assert.Error(t, err)
+
+	_, err = NewTypedReader[order](&memQueue{}, TypedReaderConfig{Poison: "explode"})
+	assert.Error(t, err)
+
+	q := &memQueue{msgs: [][]byte{[]byte("{")}, acked: map[string]error{}}
+	r, err := NewTypedReader[order](q, TypedReaderConfig{})
+	require.NoError(t, err)
+
+	_, _, _, err = r.Read(context.Background())
+	assert.ErrorIs(t, err, nsqcc.ErrTypeClosed)
+	assert.Error(t, q.acked["{"])
+}
+
+// undecodableQueue fails to decode its first message, which it settles
+// itself like the nsq reader does.
+type undecodableQueue struct {
+	*memQueue
+	failed bool
+}
+
+func (u *undecodableQueue) ReadBatch(ctx context.Context) (*nsq.Message, nsqcc.AsyncAckFn, error) {
+	if !u.failed {
+		u.failed = true
+		msg := nsq.NewMessage(nsq.MessageID{}, []byte("sealed"))
+		return nil, nil, &nsqcc.DecodeError{Msg: msg, Err: errors.New("bad key")}
+	}
+	return u.memQueue.ReadBatch(ctx)
+}
+
+func TestTypedReaderReaderDecodeError(t *testing.T) {
+	q := &memQueue{msgs: [][]byte{[]byte(`{"id":"a"}`)}, acked: map[string]error{}}
+	dlq := &memQueue{}
+
+	var poisoned []error
+	r, err := NewTypedReader[order](&undecodableQueue{memQueue: q}, TypedReaderConfig{
+		Poison:   PoisonDLQ,
+		DLQ:      dlq,
+		DLQTopic: "orders_dlq",
+		OnPoison: func(msg *nsq.Message, err error) {
+			poisoned = append(poisoned, err)
+		},
+	})
+	require.NoError(t, err)
+
+	v, _, _, err := r.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, order{ID: "a"}, v)
+	require.Len(t, poisoned, 1)
+	assert.ErrorIs(t, poisoned[0], nsqcc.ErrDecode)
+	assert.Equal(t, [][]byte{[]byte("sealed")}, dlq.msgs)
+}
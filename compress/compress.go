@@ -0,0 +1,173 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Header is the nsqcc.Envelope header that names the algorithm a message
+// body was compressed with.
+const Header = "nsqcc-compression"
+
+// Names of the supported compression algorithms.
+const (
+	Gzip   = "gzip"
+	Zstd   = "zstd"
+	Snappy = "snappy"
+	LZ4    = "lz4"
+)
+
+// maxDecompressedSize bounds the size a body may decompress to, so that a
+// small hostile message cannot cause a huge allocation. It is far above the
+// message sizes nsqd accepts by default.
+const maxDecompressedSize = 64 << 20
+
+var errTooLarge = fmt.Errorf("decompressed payload exceeds %d bytes", maxDecompressedSize)
+
+// Compressor compresses and decompresses message bodies.
+type Compressor interface {
+	Name() string
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// Names returns the names of the supported compression algorithms.
+func Names() []string {
+	return []string{Gzip, Zstd, Snappy, LZ4}
+}
+
+// Get returns the Compressor for the algorithm name.
+func Get(name string) (Compressor, error) {
+	switch name {
+	case Gzip:
+		return gzipCompressor{}, nil
+	case Zstd:
+		return zstdCompressor{}, nil
+	case Snappy:
+		return snappyCompressor{}, nil
+	case LZ4:
+		return lz4{}, nil
+	}
+	return nil, fmt.Errorf("unknown compression algorithm: %s", name)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return Gzip
+}
+
+func (gzipCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	dst, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(dst) > maxDecompressedSize {
+		return nil, errTooLarge
+	}
+	return dst, nil
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string {
+	return Snappy
+}
+
+func (snappyCompressor) Compress(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCompressor) Decompress(src []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxDecompressedSize {
+		return nil, errTooLarge
+	}
+	return snappy.Decode(nil, src)
+}
+
+// The zstd encoder and decoder are safe for concurrent use of EncodeAll and
+// DecodeAll, and expensive to create, so they are shared.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+	return zstdErr
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string {
+	return Zstd
+}
+
+func (zstdCompressor) Compress(src []byte) ([]byte, error) {
+	if err := initZstd(); err != nil {
+		return nil, err
+	}
+	return zstdEncoder.EncodeAll(src, nil), nil
+}
+
+func (zstdCompressor) Decompress(src []byte) ([]byte, error) {
+	if err := initZstd(); err != nil {
+		return nil, err
+	}
+	dst, err := zstdDecoder.DecodeAll(src, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, errTooLarge
+	}
+	return dst, err
+}
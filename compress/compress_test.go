@@ -0,0 +1,122 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compress
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	inputs := map[string][]byte{
+		"empty":      {},
+		"short":      []byte("hello"),
+		"repetitive": bytes.Repeat([]byte(`{"id":"abc","total":3},`), 500),
+		"random":     random,
+		"long run":   bytes.Repeat([]byte{'a'}, 70000),
+	}
+
+	for _, name := range Names() {
+		c, err := Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, c.Name())
+
+		for desc, input := range inputs {
+			t.Run(name+"/"+desc, func(t *testing.T) {
+				compressed, err := c.Compress(input)
+				require.NoError(t, err)
+
+				output, err := c.Decompress(compressed)
+				require.NoError(t, err)
+				assert.Equal(t, len(input), len(output))
+				assert.True(t, bytes.Equal(input, output))
+			})
+		}
+	}
+
+	_, err := Get("brotli")
+	assert.Error(t, err)
+}
+
+func TestLZ4Corrupt(t *testing.T) {
+	c, err := Get(LZ4)
+	require.NoError(t, err)
+
+	compressed, err := c.Compress(bytes.Repeat([]byte("nsqcc"), 100))
+	require.NoError(t, err)
+
+	for _, corrupt := range [][]byte{
+		nil,
+		compressed[:3],
+		compressed[:len(compressed)-2],
+		append([]byte{0xff, 0xff, 0xff, 0x7f}, compressed[4:]...),
+	} {
+		_, err := c.Decompress(corrupt)
+		assert.Error(t, err)
+	}
+}
+
+func TestDecompressTooLarge(t *testing.T) {
+	large := make([]byte, maxDecompressedSize+1)
+	for _, name := range []string{Gzip, Zstd, Snappy, LZ4} {
+		t.Run(name, func(t *testing.T) {
+			c, err := Get(name)
+			require.NoError(t, err)
+			compressed, err := c.Compress(large)
+			require.NoError(t, err)
+			require.Less(t, len(compressed), len(large)/10)
+
+			_, err = c.Decompress(compressed)
+			assert.ErrorIs(t, err, errTooLarge)
+
+			compressed, err = c.Compress(large[:maxDecompressedSize])
+			require.NoError(t, err)
+			output, err := c.Decompress(compressed)
+			require.NoError(t, err)
+			assert.Len(t, output, maxDecompressedSize)
+		})
+	}
+}
+
+func FuzzLZ4(f *testing.F) {
+	c, err := Get(LZ4)
+	require.NoError(f, err)
+	for _, seed := range [][]byte{nil, []byte("hello"), bytes.Repeat([]byte("nsqcc"), 100)} {
+		f.Add(seed)
+		compressed, err := c.Compress(seed)
+		require.NoError(f, err)
+		f.Add(compressed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		compressed, err := c.Compress(data)
+		require.NoError(t, err)
+		output, err := c.Decompress(compressed)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(data, output))
+
+		// Arbitrary input must fail cleanly rather than panic.
+		_, _ = c.Decompress(data)
+	})
+}
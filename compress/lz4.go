@@ -0,0 +1,79 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+
+	pierrec "github.com/pierrec/lz4/v4"
+)
+
+// lz4 compresses with the LZ4 block format, prefixed with the uncompressed
+// size as a little endian uint32. This matches the "size prepended" block
+// mode of common LZ4 bindings, so payloads can be read by other languages.
+type lz4 struct{}
+
+// lz4MaxRatio bounds the uncompressed size a block may claim, as LZ4 cannot
+// compress by more than about 255 to 1, so that corrupt or hostile sizes do
+// not cause huge allocations.
+const lz4MaxRatio = 255
+
+var errCorruptLZ4 = errors.New("corrupt lz4 block")
+
+func (lz4) Name() string {
+	return LZ4
+}
+
+func (lz4) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, 4+pierrec.CompressBlockBound(len(src)))
+	binary.LittleEndian.PutUint32(dst, uint32(len(src)))
+
+	var c pierrec.Compressor
+	n, err := c.CompressBlock(src, dst[4:])
+	if err != nil {
+		return nil, err
+	}
+	return dst[:4+n], nil
+}
+
+func (lz4) Decompress(src []byte) ([]byte, error) {
+	if len(src) < 4 {
+		return nil, errCorruptLZ4
+	}
+	size := binary.LittleEndian.Uint32(src)
+	block := src[4:]
+	if size == 0 {
+		if len(block) > 1 {
+			return nil, errCorruptLZ4
+		}
+		return []byte{}, nil
+	}
+	if uint64(size) > uint64(len(block))*lz4MaxRatio {
+		return nil, errCorruptLZ4
+	}
+	if size > maxDecompressedSize {
+		return nil, errTooLarge
+	}
+
+	dst := make([]byte, size)
+	n, err := pierrec.UncompressBlock(block, dst)
+	if err != nil || n != int(size) {
+		return nil, errCorruptLZ4
+	}
+	return dst, nil
+}
@@ -0,0 +1,472 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config composes nsqcc configuration from several YAML or JSON
+// files, so that for example a shared TLS block can be kept apart from the
+// per-service reader and writer settings.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/filepath"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"gopkg.in/yaml.v3"
+)
+
+// IncludeKey is the top level key of a file listing further files to load
+// before it. Its value is a path or a list of paths, which are resolved
+// relative to the including file.
+const IncludeKey = "include"
+
+// Extensions are the file extensions captured by super paths.
+var Extensions = []string{".yaml", ".yml", ".json"}
+
+// DefaultGlob are the limits of a Loader whose Glob is the zero value. They
+// are well above the size of configuration directories, and stop patterns
+// and super paths mistakenly pointed at a large tree, such as /... .
+var DefaultGlob = filepath.GlobOptions{MaxDepth: 16, MaxResults: 1000}
+
+// Loader loads and merges configuration files. The zero value is ready to
+// use.
+type Loader struct {
+	// Glob limits the expansion of glob patterns and super paths. Defaults
+	// to DefaultGlob, a negative field lifts its limit.
+	Glob filepath.GlobOptions
+	// LookupEnv looks up the variables referenced by values, see Expand.
+	// Defaults to os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+}
+
+// Load is a convenience for Loader{}.Load.
+func Load(f ifs.FS, paths ...string) (*Source, error) {
+	return Loader{}.Load(f, paths...)
+}
+
+// Load reads the files matching paths, which may be explicit paths, glob
+// patterns, super paths (the ... thing) or exclusions prefixed with !, and
+// deep-merges them into a single Source.
+//
+// Files are merged in the order of paths, the files an entry expands to in
+// sorted order, and a file matched by several entries or included by several
+// files is only loaded the first time. The files a file includes are merged
+// before the file itself, so that its own values take precedence. Maps are
+// merged key by key, any other value, lists included, replaces the previous
+// one, and a null value removes the key so the default applies again.
+//
+// Variable references in values, such as ${NSQ_HOST:-127.0.0.1}, are
+// expanded before merging, see Expand.
+func (l Loader) Load(f ifs.FS, paths ...string) (*Source, error) {
+	s := &Source{values: map[string]any{}, origins: map[string]string{}}
+
+	files, err := l.expand(f, ".", paths)
+	if err != nil {
+		return nil, err
+	}
+	loaded := map[string]struct{}{}
+	for _, file := range files {
+		if err := l.load(f, s, file, nil, loaded); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// expand resolves paths relative to dir and expands each entry in turn.
+func (l Loader) expand(f ifs.FS, dir string, paths []string) ([]string, error) {
+	var entries, exclusions []string
+	for _, p := range paths {
+		if strings.HasPrefix(p, "!") {
+			exclusions = append(exclusions, "!"+resolve(dir, p[1:]))
+		} else if p != "" {
+			entries = append(entries, resolve(dir, p))
+		}
+	}
+
+	glob := l.Glob
+	if glob == (filepath.GlobOptions{}) {
+		glob = DefaultGlob
+	}
+	var files []string
+	seen := map[string]struct{}{}
+	for _, e := range entries {
+		matches, err := glob.GlobsAndSuperPaths(f, append([]string{e}, exclusions...), Extensions...)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if _, ok := seen[m]; ok {
+				continue
+			}
+			seen[m] = struct{}{}
+			files = append(files, m)
+		}
+	}
+	return files, nil
+}
+
+func resolve(dir, p string) string {
+	if path.IsAbs(p) || dir == "." {
+		return p
+	}
+	return path.Join(dir, p)
+}
+
+// load merges file into s after the files it includes. stack holds the files
+// including it, and loaded the files already merged.
+func (l Loader) load(f ifs.FS, s *Source, file string, stack []string, loaded map[string]struct{}) error {
+	for _, p := range stack {
+		if p == file {
+			return fmt.Errorf("config include cycle: %s", strings.Join(append(stack, file), " -> "))
+		}
+	}
+	if _, ok := loaded[file]; ok {
+		return nil
+	}
+
+	b, err := ifs.ReadFile(f, file)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", file, err)
+	}
+	lookup := l.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	if err := interpolate(&node, lookup); err != nil {
+		return fmt.Errorf("config file %s: %w", file, err)
+	}
+	var doc map[string]any
+	if node.Kind != 0 {
+		if err := node.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", file, err)
+		}
+	}
+	for k, v := range doc {
+		doc[k] = stringKeys(v)
+	}
+
+	includes, err := includePaths(doc[IncludeKey])
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", file, err)
+	}
+	delete(doc, IncludeKey)
+
+	if len(includes) > 0 {
+		files, err := l.expand(f, path.Dir(file), includes)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", file, err)
+		}
+		for _, inc := range files {
+			if err := l.load(f, s, inc, append(stack, file), loaded); err != nil {
+				return err
+			}
+		}
+	}
+
+	loaded[file] = struct{}{}
+	s.files = append(s.files, file)
+	s.merge("", s.values, doc, file)
+	return nil
+}
+
+func includePaths(v any) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []any:
+		paths := make([]string, 0, len(v))
+		for _, p := range v {
+			s, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s entries must be strings, got %T", IncludeKey, p)
+			}
+			paths = append(paths, s)
+		}
+		return paths, nil
+	default:
+		return nil, fmt.Errorf("%s must be a string or a list of strings, got %T", IncludeKey, v)
+	}
+}
+
+// Source is the result of merging configuration files. It remembers which
+// file every value came from.
+type Source struct {
+	values  map[string]any
+	origins map[string]string
+	files   []string
+}
+
+// Files returns the loaded files in the order they were merged.
+func (s *Source) Files() []string {
+	return append([]string(nil), s.files...)
+}
+
+// Origin returns the file that set the value at key, a dot separated path
+// such as "tls.root_cas_file". Maps are not values of their own, their keys
+// are. It returns the empty string if no file set key.
+func (s *Source) Origin(key string) string {
+	return s.origins[key]
+}
+
+// Origins returns the file every value came from, keyed like Origin.
+func (s *Source) Origins() map[string]string {
+	origins := make(map[string]string, len(s.origins))
+	for k, v := range s.origins {
+		origins[k] = v
+	}
+	return origins
+}
+
+// Keys returns the keys of every value in sorted order.
+func (s *Source) Keys() []string {
+	keys := make([]string, 0, len(s.origins))
+	for k := range s.origins {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Decode stores the merged values in v, typically a configuration struct
+// pre-filled with its defaults, using its yaml tags, which are expected to
+// match its json tags. Durations are strings such as 1m30s, or integer
+// nanoseconds as encoding/json writes them. Values that no file set
+// are left untouched. Decoding is strict: keys that v has no field for and
+// values of the wrong type are reported together with the file they came
+// from, all errors at once.
+func (s *Source) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: Decode needs a non-nil pointer to a struct, got %T", v)
+	}
+	return errors.Join(s.decode(rv.Elem(), "", s.values)...)
+}
+
+func (s *Source) decode(v reflect.Value, prefix string, values map[string]any) []error {
+	fields := yamlFields(v.Type())
+	var errs []error
+	for _, k := range sortedKeys(values) {
+		key := joinKey(prefix, k)
+		index, ok := fields[k]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown key %s", s.originOf(key), key))
+			continue
+		}
+		fv := v.FieldByIndex(index)
+		value := values[k]
+
+		if m, ok := value.(map[string]any); ok && fv.Kind() == reflect.Struct {
+			errs = append(errs, s.decode(fv, key, m)...)
+			continue
+		}
+		if items, ok := value.([]any); ok && fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Struct {
+			var unknown []error
+			for i, item := range items {
+				if m, ok := item.(map[string]any); ok {
+					unknown = append(unknown, s.unknownKeys(fv.Type().Elem(), fmt.Sprintf("%s[%d]", key, i), key, m)...)
+				}
+			}
+			if len(unknown) > 0 {
+				errs = append(errs, unknown...)
+				continue
+			}
+		}
+
+		if err := decodeValue(value, fv.Addr().Interface()); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value for %s: %w", s.originOf(key), key, err))
+		}
+	}
+	return errs
+}
+
+// unknownKeys reports the keys of values that t has no field for. Their
+// origin is the origin of the list they are part of.
+func (s *Source) unknownKeys(t reflect.Type, prefix, list string, values map[string]any) []error {
+	fields := yamlFields(t)
+	var errs []error
+	for _, k := range sortedKeys(values) {
+		key := joinKey(prefix, k)
+		index, ok := fields[k]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown key %s", s.originOf(list), key))
+			continue
+		}
+		if m, ok := values[k].(map[string]any); ok && t.FieldByIndex(index).Type.Kind() == reflect.Struct {
+			errs = append(errs, s.unknownKeys(t.FieldByIndex(index).Type, key, list, m)...)
+		}
+	}
+	return errs
+}
+
+// originOf returns the file that set key, or one of the values below it.
+func (s *Source) originOf(key string) string {
+	if origin, ok := s.origins[key]; ok {
+		return origin
+	}
+	for _, k := range s.Keys() {
+		if strings.HasPrefix(k, key+".") {
+			return s.origins[k]
+		}
+	}
+	return "config"
+}
+
+// yamlFields maps the yaml keys of the fields of t, including those of
+// inlined structs, to their index.
+func yamlFields(t reflect.Type) map[string][]int {
+	fields := map[string][]int{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") && f.Type.Kind() == reflect.Struct {
+			for k, index := range yamlFields(f.Type) {
+				fields[k] = append([]int{i}, index...)
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = []int{i}
+	}
+	return fields
+}
+
+func decodeValue(value any, ptr any) error {
+	// encoding/json writes durations as integer nanoseconds, which yaml
+	// only accepts as strings.
+	if d, ok := ptr.(*time.Duration); ok {
+		switch n := value.(type) {
+		case int:
+			*d = time.Duration(n)
+			return nil
+		case int64:
+			*d = time.Duration(n)
+			return nil
+		}
+	}
+
+	b, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	err = yaml.Unmarshal(b, ptr)
+	var te *yaml.TypeError
+	if errors.As(err, &te) {
+		msgs := make([]string, len(te.Errors))
+		for i, msg := range te.Errors {
+			// Line numbers refer to the re-encoded value, not to the file.
+			if _, rest, ok := strings.Cut(msg, ": "); ok && strings.HasPrefix(msg, "line ") {
+				msg = rest
+			}
+			msgs[i] = msg
+		}
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	return err
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Source) merge(prefix string, dst, src map[string]any, file string) {
+	for k, v := range src {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch v := v.(type) {
+		case nil:
+			s.forget(key)
+			delete(dst, k)
+		case map[string]any:
+			dm, ok := dst[k].(map[string]any)
+			if !ok {
+				s.forget(key)
+				dm = map[string]any{}
+				dst[k] = dm
+			}
+			s.merge(key, dm, v, file)
+		default:
+			s.forget(key)
+			dst[k] = v
+			s.origins[key] = file
+		}
+	}
+}
+
+// stringKeys converts the maps with non-string keys within v, such as 1: x,
+// which yaml decodes as map[any]any, so that they are merged and their keys
+// checked like any other.
+func stringKeys(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case map[string]any:
+		for k, item := range v {
+			v[k] = stringKeys(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = stringKeys(item)
+		}
+	}
+	return v
+}
+
+// forget drops the origins of key and everything below it.
+func (s *Source) forget(key string) {
+	delete(s.origins, key)
+	for k := range s.origins {
+		if strings.HasPrefix(k, key+".") {
+			delete(s.origins, k)
+		}
+	}
+}
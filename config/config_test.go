@@ -0,0 +1,220 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/filepath"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func memFS(t *testing.T, files map[string]string) *ifs.MemFS {
+	t.Helper()
+
+	m := ifs.NewMemFS()
+	for name, content := range files {
+		require.NoError(t, m.MkdirAll(path.Dir(name), 0o755))
+		require.NoError(t, ifs.WriteFile(m, name, []byte(content), 0o644))
+	}
+	return m
+}
+
+type testConfig struct {
+	Topic    string        `yaml:"topic"`
+	Channel  string        `yaml:"channel"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Hosts    []string      `yaml:"hosts"`
+	TLS      testTLS       `yaml:"tls"`
+	Defaults string        `yaml:"defaults"`
+}
+
+type testTLS struct {
+	Enabled     bool   `yaml:"enabled"`
+	RootCAsFile string `yaml:"root_cas_file"`
+	ServerName  string `yaml:"server_name"`
+}
+
+func TestLoadMergesInOrder(t *testing.T) {
+	m := memFS(t, map[string]string{
+		"conf/shared/tls.yaml":   "tls:\n  enabled: true\n  root_cas_file: /etc/ca.pem\n",
+		"conf/shared/hosts.json": `{"hosts": ["a:4150", "b:4150"], "timeout": "2s"}`,
+		"conf/reader.yaml":       "topic: orders\nhosts: [c:4150]\ntls:\n  server_name: nsqd\n",
+		"conf/reader.txt":        "topic: ignored\n",
+	})
+
+	src, err := Load(m, "conf/shared/...", "conf/reader.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"conf/shared/hosts.json", "conf/shared/tls.yaml", "conf/reader.yaml"}, src.Files())
+
+	conf := testConfig{Channel: "default", Defaults: "kept"}
+	require.NoError(t, src.Decode(&conf))
+	assert.Equal(t, testConfig{
+		Topic:    "orders",
+		Channel:  "default",
+		Timeout:  2 * time.Second,
+		Hosts:    []string{"c:4150"},
+		TLS:      testTLS{Enabled: true, RootCAsFile: "/etc/ca.pem", ServerName: "nsqd"},
+		Defaults: "kept",
+	}, conf)
+
+	assert.Equal(t, "conf/reader.yaml", src.Origin("hosts"))
+	assert.Equal(t, "conf/shared/hosts.json", src.Origin("timeout"))
+	assert.Equal(t, "conf/shared/tls.yaml", src.Origin("tls.enabled"))
+	assert.Equal(t, "conf/reader.yaml", src.Origin("tls.server_name"))
+	assert.Equal(t, "", src.Origin("tls"))
+	assert.Equal(t, []string{"hosts", "timeout", "tls.enabled", "tls.root_cas_file", "tls.server_name", "topic"}, src.Keys())
+}
+
+func TestLoadIncludes(t *testing.T) {
+	m := memFS(t, map[string]string{
+		"shared/tls.yaml":      "tls:\n  enabled: true\n  server_name: shared\n",
+		"shared/base.yaml":     "include: tls.yaml\ntopic: base\nchannel: base\n",
+		"svc/reader.yaml":      "include:\n  - ../shared/base.yaml\n  - extra/*.yaml\ntopic: orders\n",
+		"svc/extra/a.yaml":     "channel: a\n",
+		"svc/extra/b.yaml":     "channel: b\ntls: null\n",
+		"svc/extra/skip.yml":   "channel: skipped\n",
+		"svc/override.yaml":    "tls:\n  server_name: override\n",
+		"cycle/a.yaml":         "include: b.yaml\n",
+		"cycle/b.yaml":         "include: [a.yaml]\n",
+		"invalid/include.yaml": "include: 1\n",
+		"diamond/svc.yaml":     "include: [b.yaml, c.yaml]\n",
+		"diamond/b.yaml":       "include: shared.yaml\nchannel: b\n",
+		"diamond/c.yaml":       "include: shared.yaml\n",
+		"diamond/shared.yaml":  "channel: shared\n",
+	})
+
+	src, err := Load(m, "svc/reader.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"shared/tls.yaml", "shared/base.yaml", "svc/extra/a.yaml", "svc/extra/b.yaml", "svc/reader.yaml",
+	}, src.Files())
+
+	var conf testConfig
+	require.NoError(t, src.Decode(&conf))
+	assert.Equal(t, "orders", conf.Topic)
+	assert.Equal(t, "b", conf.Channel)
+	assert.Equal(t, testTLS{}, conf.TLS)
+	assert.Equal(t, "svc/extra/b.yaml", src.Origin("channel"))
+	assert.Equal(t, "", src.Origin("tls.enabled"))
+
+	src, err = Load(m, "svc/reader.yaml", "svc/override.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "svc/override.yaml", src.Origin("tls.server_name"))
+
+	// A file included twice is merged once, and does not override the
+	// files merged after it the first time.
+	src, err = Load(m, "diamond/svc.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"diamond/shared.yaml", "diamond/b.yaml", "diamond/c.yaml", "diamond/svc.yaml"}, src.Files())
+	assert.Equal(t, "diamond/b.yaml", src.Origin("channel"))
+
+	_, err = Load(m, "cycle/a.yaml")
+	assert.ErrorContains(t, err, "cycle/a.yaml -> cycle/b.yaml -> cycle/a.yaml")
+
+	_, err = Load(m, "invalid/include.yaml")
+	assert.Error(t, err)
+
+	_, err = Load(m, "missing.yaml")
+	assert.Error(t, err)
+}
+
+func TestLoadExclusions(t *testing.T) {
+	m := memFS(t, map[string]string{
+		"conf/a.yaml":       "topic: a\n",
+		"conf/b.yaml":       "topic: b\n",
+		"conf/local/c.yaml": "topic: c\n",
+	})
+
+	src, err := Load(m, "conf/...", "!conf/local/*", "!conf/b.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"conf/a.yaml"}, src.Files())
+
+	src, err = Load(m, "conf/b.yaml", "conf/*.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"conf/b.yaml", "conf/a.yaml"}, src.Files())
+}
+
+func TestLoadGlobLimits(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i <= DefaultGlob.MaxResults; i++ {
+		files[fmt.Sprintf("conf/%04d.yaml", i)] = "topic: t\n"
+	}
+	m := memFS(t, files)
+
+	_, err := Load(m, "conf/...")
+	assert.ErrorIs(t, err, filepath.ErrTooManyResults)
+
+	src, err := Loader{Glob: filepath.GlobOptions{MaxResults: -1}}.Load(m, "conf/...")
+	require.NoError(t, err)
+	assert.Len(t, src.Files(), DefaultGlob.MaxResults+1)
+}
+
+func TestDecodeStrict(t *testing.T) {
+	m := memFS(t, map[string]string{
+		"base.yaml":  "topic: orders\ntimeout: soon\ntls:\n  enabld: true\n  extra:\n    nested: 1\n",
+		"certs.yaml": "certs:\n  - file: a.pem\n    passwd: x\n",
+		"hosts.yaml": "hosts: notalist\ntopc: typo\n",
+		"keys.yaml":  "tls:\n  enabled: true\n  2: z\ncerts:\n  - file: a.pem\n    3: x\n",
+	})
+
+	src, err := Load(m, "base.yaml", "hosts.yaml")
+	require.NoError(t, err)
+	var conf testConfig
+	err = src.Decode(&conf)
+	require.Error(t, err)
+	assert.Equal(t, []string{
+		"hosts.yaml: invalid value for hosts: cannot unmarshal !!str `notalist` into []string",
+		"base.yaml: invalid value for timeout: cannot unmarshal !!str `soon` into time.Duration",
+		"base.yaml: unknown key tls.enabld",
+		"base.yaml: unknown key tls.extra",
+		"hosts.yaml: unknown key topc",
+	}, strings.Split(err.Error(), "\n"))
+	// Valid values are decoded even when others are not.
+	assert.Equal(t, "orders", conf.Topic)
+
+	var certs struct {
+		Certs []struct {
+			File string `yaml:"file"`
+		} `yaml:"certs"`
+	}
+	src, err = Load(m, "certs.yaml")
+	require.NoError(t, err)
+	assert.EqualError(t, src.Decode(&certs), "certs.yaml: unknown key certs[0].passwd")
+
+	assert.Error(t, src.Decode(certs))
+
+	// Keys that are not strings are reported too, however deeply nested.
+	var keys struct {
+		TLS   testTLS `yaml:"tls"`
+		Certs []struct {
+			File string `yaml:"file"`
+		} `yaml:"certs"`
+	}
+	src, err = Load(m, "keys.yaml")
+	require.NoError(t, err)
+	err = src.Decode(&keys)
+	assert.ErrorContains(t, err, "keys.yaml: unknown key tls.2")
+	assert.ErrorContains(t, err, "keys.yaml: unknown key certs[0].3")
+	assert.True(t, keys.TLS.Enabled)
+}
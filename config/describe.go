@@ -0,0 +1,175 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SetDefaults sets every field of the struct pointed to by v, including
+// those of nested structs, to the value of its default tag. The default tags
+// are the single source of the defaults of a configuration, whether it is
+// then read from files or from the environment.
+func SetDefaults(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: SetDefaults needs a non-nil pointer to a struct, got %T", v)
+	}
+	return setDefaults(rv.Elem())
+}
+
+func setDefaults(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if err := setDefaults(fv); err != nil {
+				return err
+			}
+			continue
+		}
+		def, ok := field.Tag.Lookup("default")
+		if !ok {
+			continue
+		}
+		if err := setValue(fv, def); err != nil {
+			return fmt.Errorf("invalid default of %s.%s: %w", t, field.Name, err)
+		}
+	}
+	return nil
+}
+
+// Field describes a configuration field.
+type Field struct {
+	// Name is the dot separated path of the field in the struct, such as
+	// TLS.Enabled.
+	Name string `json:"name"`
+	// Type is the Go type of the field.
+	Type string `json:"type"`
+	// Default is the value of the default tag of the field, if any.
+	Default string `json:"default,omitempty"`
+	// Env is the environment variable the field is read from, if any, and
+	// LegacyEnv the unprefixed name also accepted for it, see EnvVar.
+	Env       string `json:"env,omitempty"`
+	LegacyEnv string `json:"legacy_env,omitempty"`
+	// JSON and YAML are the dot separated keys of the field in JSON and YAML
+	// files. Elements of lists are marked with []. Both are taken from the
+	// yaml tags, which Source.Decode uses for every file.
+	JSON string `json:"json"`
+	YAML string `json:"yaml"`
+	// Secret reports whether the field holds a secret, see Redact.
+	Secret bool `json:"secret,omitempty"`
+}
+
+// Describe returns every field of the struct pointed to by v, in field
+// order, with the environment variables it is read from with envPrefix, as
+// returned by Env.Vars. The fields of structs nested in lists are described
+// too, although they cannot be set from the environment.
+func Describe(v any, envPrefix string) ([]Field, error) {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: Describe needs a pointer to a struct, got %T", v)
+	}
+	vars, err := Env{Prefix: envPrefix}.Vars(reflect.New(t.Elem()).Interface())
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]EnvVar, len(vars))
+	for _, ev := range vars {
+		env[ev.Field] = ev
+	}
+	return describe(t.Elem(), field{}, env, nil), nil
+}
+
+// field is the position of a struct within the configuration.
+type field struct {
+	name, key string
+}
+
+func (p field) child(f reflect.StructField) field {
+	return field{
+		name: joinKey(p.name, f.Name),
+		key:  joinKey(p.key, tagName(f.Tag.Get("yaml"), strings.ToLower(f.Name))),
+	}
+}
+
+// describe appends the fields of t to fields, env holds the variables of the
+// fields by their name.
+func describe(t reflect.Type, parent field, env map[string]EnvVar, fields []Field) []Field {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		pos := parent.child(f)
+
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			fields = describe(f.Type, pos, env, fields)
+			continue
+		case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct:
+			fields = append(fields, Field{Name: pos.name, Type: f.Type.String(), JSON: pos.key, YAML: pos.key})
+			pos.name, pos.key = pos.name+"[]", pos.key+"[]"
+			fields = describe(f.Type.Elem(), pos, env, fields)
+			continue
+		}
+
+		fields = append(fields, Field{
+			Name:      pos.name,
+			Type:      f.Type.String(),
+			Default:   f.Tag.Get("default"),
+			Env:       env[pos.name].Name,
+			LegacyEnv: env[pos.name].Legacy,
+			JSON:      pos.key,
+			YAML:      pos.key,
+			Secret:    f.Tag.Get("secret") == "true",
+		})
+	}
+	return fields
+}
+
+// tagName returns the name of a yaml tag, or fallback if it has none.
+func tagName(tag, fallback string) string {
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// Defaults returns a T, a configuration struct, with the defaults of its
+// default tags set, see SetDefaults. It panics if a default tag is invalid.
+func Defaults[T any]() T {
+	var v T
+	if err := SetDefaults(&v); err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type describedCert struct {
+	File string `json:"file" yaml:"file"`
+	Key  string `json:"key" yaml:"key" secret:"true"`
+}
+
+type describedTLS struct {
+	Enabled bool            `json:"enabled" envconfig:"TLS_ENABLE" yaml:"enabled"`
+	Certs   []describedCert `json:"certs" yaml:"certs"`
+}
+
+type describedConfig struct {
+	Addresses []string      `json:"addresses" envconfig:"NSQ_ADDRESSES" default:"a:4150,b:4150" yaml:"addresses"`
+	Timeout   time.Duration `json:"timeout" envconfig:"NSQ_TIMEOUT" default:"5s" yaml:"timeout,omitempty"`
+	Attempts  uint16        `envconfig:"NSQ_ATTEMPTS" default:"5"`
+	TLS       describedTLS  `json:"tls" yaml:"tls" envprefix:"NSQ"`
+	Internal  describedTLS  `json:"internal" yaml:"internal" ignored:"true"`
+}
+
+func TestDefaults(t *testing.T) {
+	assert.Equal(t, describedConfig{
+		Addresses: []string{"a:4150", "b:4150"},
+		Timeout:   5 * time.Second,
+		Attempts:  5,
+	}, Defaults[describedConfig]())
+
+	var invalid struct {
+		Timeout time.Duration `default:"soon"`
+	}
+	assert.ErrorContains(t, SetDefaults(&invalid), "Timeout")
+	assert.Error(t, SetDefaults(invalid))
+}
+
+func TestDescribe(t *testing.T) {
+	fields, err := Describe(&describedConfig{}, "orders")
+	require.NoError(t, err)
+	assert.Equal(t, []Field{
+		{Name: "Addresses", Type: "[]string", Default: "a:4150,b:4150", Env: "ORDERS_NSQ_ADDRESSES", JSON: "addresses", YAML: "addresses"},
+		{Name: "Timeout", Type: "time.Duration", Default: "5s", Env: "ORDERS_NSQ_TIMEOUT", JSON: "timeout", YAML: "timeout"},
+		{Name: "Attempts", Type: "uint16", Default: "5", Env: "ORDERS_NSQ_ATTEMPTS", JSON: "attempts", YAML: "attempts"},
+		{Name: "TLS.Enabled", Type: "bool", Env: "ORDERS_NSQ_TLS_ENABLE", JSON: "tls.enabled", YAML: "tls.enabled"},
+		{Name: "TLS.Certs", Type: "[]config.describedCert", JSON: "tls.certs", YAML: "tls.certs"},
+		{Name: "TLS.Certs[].File", Type: "string", JSON: "tls.certs[].file", YAML: "tls.certs[].file"},
+		{Name: "TLS.Certs[].Key", Type: "string", JSON: "tls.certs[].key", YAML: "tls.certs[].key", Secret: true},
+		{Name: "Internal.Enabled", Type: "bool", JSON: "internal.enabled", YAML: "internal.enabled"},
+		{Name: "Internal.Certs", Type: "[]config.describedCert", JSON: "internal.certs", YAML: "internal.certs"},
+		{Name: "Internal.Certs[].File", Type: "string", JSON: "internal.certs[].file", YAML: "internal.certs[].file"},
+		{Name: "Internal.Certs[].Key", Type: "string", JSON: "internal.certs[].key", YAML: "internal.certs[].key", Secret: true},
+	}, fields)
+
+	// Without a prefix, nested fields also accept their legacy names, like
+	// Env.Process does.
+	fields, err = Describe(&describedConfig{}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "NSQ_TIMEOUT", fields[1].Env)
+	assert.Empty(t, fields[1].LegacyEnv)
+	assert.Equal(t, "NSQ_TLS_ENABLE", fields[3].Env)
+	assert.Equal(t, "TLS_ENABLE", fields[3].LegacyEnv)
+
+	_, err = Describe(describedConfig{}, "")
+	assert.Error(t, err)
+}
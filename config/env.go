@@ -0,0 +1,206 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Env sets the fields of configuration structs from environment variables.
+//
+// A field tagged `envconfig:"NSQ_TOPIC"` is read from PREFIX_NSQ_TOPIC, or
+// from NSQ_TOPIC when Prefix is empty. A nested struct field tagged
+// `envprefix:"NSQ_WRITER"` adds NSQ_WRITER to the prefix of the fields of
+// the nested struct, so that the TLS blocks of a reader and a writer read
+// different variables. Unlike envconfig.Process, a prefixed variable never
+// falls back to its unprefixed name, so that several readers and writers
+// can be configured in one process without leaking settings into each
+// other.
+//
+// The only exception is the empty Prefix, for which the variables of nested
+// structs also fall back to their name without the envprefix, as in
+// TLS_ENABLE, which is how they were named before nested prefixes existed.
+type Env struct {
+	// Prefix is prepended to every variable name, separated by _.
+	Prefix string
+	// LookupEnv looks up variables. Defaults to os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+}
+
+// ProcessEnv is a convenience for Env{Prefix: prefix}.Process(v).
+func ProcessEnv(prefix string, v any) error {
+	return Env{Prefix: prefix}.Process(v)
+}
+
+// EnvVar describes the variable a struct field is read from.
+type EnvVar struct {
+	// Name is the variable name.
+	Name string
+	// Legacy is the unprefixed name also accepted for Name, if any.
+	Legacy string
+	// Field is the dot separated path of the field in the struct.
+	Field string
+
+	value reflect.Value
+}
+
+// Vars returns the variables the fields of the struct pointed to by v are
+// read from, in field order.
+func (e Env) Vars(v any) ([]EnvVar, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: env needs a non-nil pointer to a struct, got %T", v)
+	}
+	return envVars(rv.Elem(), strings.ToUpper(e.Prefix), "", e.Prefix == "", nil), nil
+}
+
+func envVars(v reflect.Value, prefix, path string, legacy bool, vars []EnvVar) []EnvVar {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Tag.Get("ignored") == "true" {
+			continue
+		}
+		fv := v.Field(i)
+		fieldPath := field.Name
+		if path != "" {
+			fieldPath = path + "." + field.Name
+		}
+
+		if fv.Kind() == reflect.Struct {
+			nested := prefix
+			if p := field.Tag.Get("envprefix"); p != "" {
+				nested = joinEnv(prefix, strings.ToUpper(p))
+			}
+			vars = envVars(fv, nested, fieldPath, legacy, vars)
+			continue
+		}
+
+		name := strings.ToUpper(field.Tag.Get("envconfig"))
+		if name == "" {
+			continue
+		}
+		ev := EnvVar{
+			Name:  joinEnv(prefix, name),
+			Field: fieldPath,
+			value: fv,
+		}
+		if legacy && ev.Name != name {
+			ev.Legacy = name
+		}
+		vars = append(vars, ev)
+	}
+	return vars
+}
+
+// Process sets the fields of the struct pointed to by v from the variables
+// that are set, leaving the other fields untouched, so that v usually starts
+// out with its defaults, see SetDefaults. Lists are comma separated.
+func (e Env) Process(v any) error {
+	vars, err := e.Vars(v)
+	if err != nil {
+		return err
+	}
+	lookup := e.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+
+	for _, ev := range vars {
+		name := ev.Name
+		value, ok := lookup(name)
+		if !ok && ev.Legacy != "" {
+			name = ev.Legacy
+			value, ok = lookup(name)
+		}
+		if !ok {
+			continue
+		}
+		if err := setValue(ev.value, value); err != nil {
+			return fmt.Errorf("failed to parse env var %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		items := []string{}
+		if s != "" {
+			items = strings.Split(s, ",")
+		}
+		sl := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(sl.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		v.Set(sl)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func joinEnv(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type envTLS struct {
+	Enabled bool     `envconfig:"TLS_ENABLE"`
+	Pins    []string `envconfig:"TLS_PINNED_SPKI"`
+}
+
+type envConfig struct {
+	Topic       string        `envconfig:"NSQ_TOPIC"`
+	Channel     string        `envconfig:"NSQ_CHANNEL" default:"default"`
+	MaxAttempts uint16        `envconfig:"NSQ_MAX_ATTEMPTS"`
+	Timeout     time.Duration `envconfig:"NSQ_TIMEOUT"`
+	Untagged    string
+	TLS         envTLS `envprefix:"NSQ"`
+}
+
+func lookupMap(env map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+}
+
+func TestEnvVars(t *testing.T) {
+	vars, err := Env{Prefix: "orders"}.Vars(&envConfig{})
+	require.NoError(t, err)
+
+	var names []string
+	for _, v := range vars {
+		names = append(names, v.Name)
+		assert.Empty(t, v.Legacy)
+	}
+	assert.Equal(t, []string{
+		"ORDERS_NSQ_TOPIC", "ORDERS_NSQ_CHANNEL", "ORDERS_NSQ_MAX_ATTEMPTS", "ORDERS_NSQ_TIMEOUT",
+		"ORDERS_NSQ_TLS_ENABLE", "ORDERS_NSQ_TLS_PINNED_SPKI",
+	}, names)
+	assert.Equal(t, "TLS.Enabled", vars[4].Field)
+
+	vars, err = Env{}.Vars(&envConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, "NSQ_TOPIC", vars[0].Name)
+	assert.Empty(t, vars[0].Legacy)
+	assert.Equal(t, "NSQ_TLS_ENABLE", vars[4].Name)
+	assert.Equal(t, "TLS_ENABLE", vars[4].Legacy)
+
+	_, err = Env{}.Vars(envConfig{})
+	assert.Error(t, err)
+}
+
+func TestEnvProcess(t *testing.T) {
+	env := map[string]string{
+		"NSQ_TOPIC":                  "global",
+		"TLS_ENABLE":                 "true",
+		"ORDERS_NSQ_TOPIC":           "orders",
+		"ORDERS_NSQ_MAX_ATTEMPTS":    "0x10",
+		"ORDERS_NSQ_TIMEOUT":         "3s",
+		"ORDERS_NSQ_TLS_PINNED_SPKI": "sha256/a, sha256/b",
+		"AUDIT_NSQ_CHANNEL":          "audit",
+	}
+
+	orders := Defaults[envConfig]()
+	require.NoError(t, Env{Prefix: "ORDERS", LookupEnv: lookupMap(env)}.Process(&orders))
+	assert.Equal(t, envConfig{
+		Topic:       "orders",
+		Channel:     "default",
+		MaxAttempts: 16,
+		Timeout:     3 * time.Second,
+		TLS:         envTLS{Pins: []string{"sha256/a", "sha256/b"}},
+	}, orders)
+
+	// Prefixed configs never read the unprefixed variables.
+	var audit envConfig
+	require.NoError(t, Env{Prefix: "audit", LookupEnv: lookupMap(env)}.Process(&audit))
+	assert.Equal(t, envConfig{Channel: "audit"}, audit)
+
+	// The unprefixed config still accepts the legacy TLS_ variables.
+	var global envConfig
+	require.NoError(t, Env{LookupEnv: lookupMap(env)}.Process(&global))
+	assert.Equal(t, "global", global.Topic)
+	assert.True(t, global.TLS.Enabled)
+
+	env["NSQ_TLS_ENABLE"] = "false"
+	global = envConfig{}
+	require.NoError(t, Env{LookupEnv: lookupMap(env)}.Process(&global))
+	assert.False(t, global.TLS.Enabled)
+
+	env["ORDERS_NSQ_MAX_ATTEMPTS"] = "70000"
+	err := Env{Prefix: "ORDERS", LookupEnv: lookupMap(env)}.Process(&orders)
+	assert.ErrorContains(t, err, "ORDERS_NSQ_MAX_ATTEMPTS")
+
+	// Errors name the variable the bad value was read from.
+	delete(env, "NSQ_TLS_ENABLE")
+	env["TLS_ENABLE"] = "maybe"
+	err = Env{LookupEnv: lookupMap(env)}.Process(&global)
+	assert.ErrorContains(t, err, "env var TLS_ENABLE:")
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Expand replaces references to variables in s with their values looked up
+// with lookup:
+//
+//   - ${NAME} is the value of NAME, which must be set
+//   - ${NAME:-default} is default when NAME is unset or empty
+//   - ${NAME-default} is default when NAME is unset
+//   - $$ is a literal $
+//
+// Any other $ is kept as is.
+func Expand(s string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			v, err := expandVar(s[i+2:i+2+end], lookup)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			i += end + 2
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+func expandVar(ref string, lookup func(string) (string, bool)) (string, error) {
+	name, def, hasDef := ref, "", false
+	orEmpty := false
+	if i := strings.IndexByte(ref, '-'); i >= 0 {
+		name, def, hasDef = ref[:i], ref[i+1:], true
+		if strings.HasSuffix(name, ":") {
+			name, orEmpty = strings.TrimSuffix(name, ":"), true
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("empty variable name in ${%s}", ref)
+	}
+
+	v, ok := lookup(name)
+	switch {
+	case ok && (v != "" || !orEmpty):
+		return v, nil
+	case hasDef:
+		return def, nil
+	default:
+		return "", fmt.Errorf("variable %s is not set", name)
+	}
+}
+
+// interpolate expands the variable references of the scalar values below n.
+// Plain scalars are re-resolved afterwards, so that ${PORT:-4150} is decoded
+// as a number while "${PORT:-4150}" stays a string.
+func interpolate(n *yaml.Node, lookup func(string) (string, bool)) error {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			if err := interpolate(c, lookup); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		// Only values are interpolated, keys are kept as written.
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := interpolate(n.Content[i], lookup); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		v, err := Expand(n.Value, lookup)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		if v != n.Value {
+			n.Value = v
+			if n.Style == 0 {
+				n.Tag = ""
+			}
+		}
+	}
+	return nil
+}
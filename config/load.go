@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+)
+
+// LoadFiles loads a T, a configuration struct, from files on top of the
+// defaults of its default tags. Paths may be explicit paths, glob patterns or
+// super paths, see Loader.Load for how the files are merged. Secret
+// references are resolved, see ResolveSecrets.
+func LoadFiles[T any](f ifs.FS, paths ...string) (T, error) {
+	cfg := Defaults[T]()
+	src, err := Load(f, paths...)
+	if err != nil {
+		return cfg, err
+	}
+	if err := src.Decode(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := ResolveSecrets(f, &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// LoadEnv loads a T, a configuration struct, from environment variables
+// named after prefix on top of the defaults of its default tags, see Env,
+// and resolves its secret references.
+func LoadEnv[T any](prefix string) (T, error) {
+	cfg := Defaults[T]()
+	if err := ProcessEnv(prefix, &cfg); err != nil {
+		return cfg, err
+	}
+	if err := ResolveSecrets(ifs.OS(), &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// MustLoad loads a T with LoadEnv and the empty prefix when cfgPath is
+// empty, and otherwise with LoadFiles from the comma separated list of paths
+// in cfgPath. Errors are fatal.
+func MustLoad[T any](cfgPath string) T {
+	var (
+		cfg T
+		err error
+	)
+	if cfgPath != "" {
+		cfg, err = LoadFiles[T](ifs.OS(), strings.Split(cfgPath, ",")...)
+	} else {
+		cfg, err = LoadEnv[T]("")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+// MustDescribe returns Describe of a T, a configuration struct, for the
+// empty prefix. It panics when T cannot be described, which only happens
+// when it is not a struct.
+func MustDescribe[T any]() []Field {
+	var cfg T
+	fields, err := Describe(&cfg, "")
+	if err != nil {
+		panic(err)
+	}
+	return fields
+}
+
+// Format formats v, a configuration struct, like the %+v verb with its
+// secrets redacted, so that it can be printed or logged. It is meant for the
+// String methods of configuration structs, which cannot format themselves
+// with %+v.
+func Format[T any](v T) string {
+	rv := reflect.ValueOf(Redact(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Sprintf("%+v", rv.Interface())
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if b.Len() > 1 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s:%+v", field.Name, rv.Field(i).Interface())
+	}
+	b.WriteByte('}')
+	return b.String()
+}
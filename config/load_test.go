@@ -0,0 +1,64 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type loadConfig struct {
+	Topic   string `yaml:"topic" envconfig:"NSQ_TOPIC" default:"events"`
+	Channel string `yaml:"channel" envconfig:"NSQ_CHANNEL" default:"default"`
+	Secret  string `yaml:"secret" envconfig:"NSQ_SECRET" secret:"true"`
+}
+
+func TestLoadFiles(t *testing.T) {
+	t.Setenv("TEST_LOAD_SECRET", "s3cret")
+	m := memFS(t, map[string]string{
+		"conf/reader.yaml": "topic: orders\nsecret: env://TEST_LOAD_SECRET\n",
+		"conf/typo.yaml":   "topics: orders\n",
+	})
+
+	conf, err := LoadFiles[loadConfig](m, "conf/reader.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, loadConfig{Topic: "orders", Channel: "default", Secret: "s3cret"}, conf)
+
+	_, err = LoadFiles[loadConfig](m, "conf/typo.yaml")
+	assert.ErrorContains(t, err, "topics")
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("TEST_LOAD_NSQ_CHANNEL", "audit")
+	t.Setenv("TEST_LOAD_NSQ_SECRET", "env://TEST_LOAD_SECRET")
+	t.Setenv("TEST_LOAD_SECRET", "s3cret")
+
+	conf, err := LoadEnv[loadConfig]("TEST_LOAD")
+	require.NoError(t, err)
+	assert.Equal(t, loadConfig{Topic: "events", Channel: "audit", Secret: "s3cret"}, conf)
+}
+
+func TestFormat(t *testing.T) {
+	conf := secretConfig{Topic: "orders", Secret: "s3cret", Next: &secretCert{Key: "key"}}
+	s := Format(conf)
+	assert.Contains(t, s, "Topic:orders")
+	assert.Contains(t, s, "Secret:"+Redacted)
+	assert.NotContains(t, s, "s3cret")
+	assert.Equal(t, "s3cret", conf.Secret)
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SchemaDialect is the JSON Schema version of generated schemas.
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// DurationPattern matches the durations accepted by time.ParseDuration.
+const DurationPattern = `^(0|[-+]?([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`
+
+// HostPortPattern loosely matches host:port addresses, see CheckHostPort.
+const HostPortPattern = `^\S+:[0-9]+$`
+
+// Schema is a JSON Schema describing configuration files.
+type Schema struct {
+	Dialect              string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Default              any                `json:"default,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	WriteOnly            bool               `json:"writeOnly,omitempty"`
+}
+
+// SchemaOf returns the JSON Schema of files decoded into the struct pointed
+// to by v with Source.Decode. Objects reject unknown keys like Decode does,
+// and defaults are taken from the default tags.
+func SchemaOf(v any) (*Schema, error) {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: SchemaOf needs a pointer to a struct, got %T", v)
+	}
+	s, err := schemaOf(t.Elem())
+	if err != nil {
+		return nil, err
+	}
+	s.Dialect = SchemaDialect
+	return s, nil
+}
+
+// MustSchemaOf is like SchemaOf but panics on error.
+func MustSchemaOf(v any) *Schema {
+	s, err := SchemaOf(v)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func schemaOf(t reflect.Type) (*Schema, error) {
+	switch t.Kind() {
+	case reflect.Struct:
+		closed := false
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: &closed}
+		if err := addProperties(s, t); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case reflect.Slice, reflect.Array:
+		items, err := schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if t == durationType {
+			// Durations are also accepted as integer nanoseconds, see
+			// Source.Decode.
+			return &Schema{OneOf: []*Schema{{Type: "string", Pattern: DurationPattern}, {Type: "integer"}}}, nil
+		}
+		return &Schema{Type: "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: Float(0)}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	}
+	return nil, fmt.Errorf("config: no JSON Schema for type %s", t)
+}
+
+func addProperties(s *Schema, t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		name, opts, _ := strings.Cut(tag, ",")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		if strings.Contains(opts, "inline") && f.Type.Kind() == reflect.Struct {
+			if err := addProperties(s, f.Type); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		prop, err := schemaOf(f.Type)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		prop.WriteOnly = f.Tag.Get("secret") == "true"
+		if def, ok := f.Tag.Lookup("default"); ok {
+			if prop.Default, err = schemaDefault(f.Type, def); err != nil {
+				return fmt.Errorf("invalid default of %s: %w", f.Name, err)
+			}
+		}
+		s.Properties[name] = prop
+	}
+	return nil
+}
+
+func schemaDefault(t reflect.Type, def string) (any, error) {
+	v := reflect.New(t).Elem()
+	if err := setValue(v, def); err != nil {
+		return nil, err
+	}
+	if t == durationType {
+		return def, nil
+	}
+	return v.Interface(), nil
+}
+
+// Property returns the schema of the property at path, a dot separated list
+// of keys in which [] selects the items of a list, or nil if there is none.
+func (s *Schema) Property(path string) *Schema {
+	for _, key := range strings.Split(path, ".") {
+		if s == nil {
+			return nil
+		}
+		key, items := strings.CutSuffix(key, "[]")
+		s = s.Properties[key]
+		if items && s != nil {
+			s = s.Items
+		}
+	}
+	return s
+}
+
+// Int returns a pointer to n, for the optional fields of Schema.
+func Int(n int) *int { return &n }
+
+// Float returns a pointer to n, for the optional fields of Schema.
+func Float(n float64) *float64 { return &n }
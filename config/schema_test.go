@@ -0,0 +1,74 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaOf(t *testing.T) {
+	s, err := SchemaOf(&describedConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, SchemaDialect, s.Dialect)
+	assert.Equal(t, "object", s.Type)
+	assert.False(t, *s.AdditionalProperties)
+
+	assert.Equal(t, &Schema{Type: "array", Items: &Schema{Type: "string"}, Default: []string{"a:4150", "b:4150"}}, s.Property("addresses"))
+	assert.Equal(t, &Schema{OneOf: []*Schema{{Type: "string", Pattern: DurationPattern}, {Type: "integer"}}, Default: "5s"}, s.Property("timeout"))
+	assert.Equal(t, &Schema{Type: "integer", Minimum: Float(0), Default: uint16(5)}, s.Property("attempts"))
+	assert.Equal(t, &Schema{Type: "boolean"}, s.Property("tls.enabled"))
+	assert.Equal(t, &Schema{Type: "string", WriteOnly: true}, s.Property("tls.certs[].key"))
+	assert.Nil(t, s.Property("tls.missing.key"))
+
+	b, err := json.Marshal(s)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"$schema":"`+SchemaDialect+`"`)
+	assert.Contains(t, string(b), `"additionalProperties":false`)
+
+	durations := regexp.MustCompile(DurationPattern)
+	for _, d := range []string{"0", "1s", "1h30m", "250ms", "-1.5s"} {
+		assert.True(t, durations.MatchString(d), d)
+	}
+	for _, d := range []string{"", "1", "soon", "1d"} {
+		assert.False(t, durations.MatchString(d), d)
+	}
+
+	_, err = SchemaOf(describedConfig{})
+	assert.Error(t, err)
+	_, err = SchemaOf(&struct{ M map[string]string }{})
+	assert.Error(t, err)
+}
+
+func TestCheckAddresses(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:4150", "nsqd.internal:4150", "[::1]:4150"} {
+		assert.NoError(t, CheckHostPort(addr), addr)
+		assert.NoError(t, CheckHTTPAddress(addr), addr)
+	}
+	for _, addr := range []string{"", "nsqd", ":4150", "nsqd:", "nsqd:http", "nsqd:0", "nsqd:70000", "http://nsqd:4161"} {
+		assert.Error(t, CheckHostPort(addr), addr)
+	}
+
+	assert.NoError(t, CheckHTTPAddress("http://lookupd:4161"))
+	assert.NoError(t, CheckHTTPAddress("https://lookupd:4161/"))
+	assert.Error(t, CheckHTTPAddress("ftp://lookupd:4161"))
+	assert.Error(t, CheckHTTPAddress("http://lookupd"))
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+)
+
+// Schemes of secret references. A string field tagged `secret:"true"` whose
+// value starts with one of them is replaced by the contents of the file, or
+// the value of the environment variable, it refers to.
+const (
+	FileScheme = "file://"
+	EnvScheme  = "env://"
+)
+
+// Redacted replaces the values of secret fields in redacted copies.
+const Redacted = "[REDACTED]"
+
+// ResolveSecrets replaces the secret references of the secret fields of the
+// struct pointed to by v, including those of nested structs and slices of
+// structs. Trailing newlines are trimmed from files.
+func ResolveSecrets(f ifs.FS, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("config: ResolveSecrets needs a non-nil pointer, got %T", v)
+	}
+	return resolveSecrets(f, rv.Elem())
+}
+
+func resolveSecrets(f ifs.FS, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return resolveSecrets(f, v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveSecrets(f, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fv := v.Field(i)
+			if !isSecret(field) || fv.Kind() != reflect.String {
+				if err := resolveSecrets(f, fv); err != nil {
+					return err
+				}
+				continue
+			}
+			resolved, err := resolveSecret(f, fv.String())
+			if err != nil {
+				return fmt.Errorf("%s: %w", field.Name, err)
+			}
+			fv.SetString(resolved)
+		}
+	}
+	return nil
+}
+
+func resolveSecret(f ifs.FS, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, FileScheme):
+		name := strings.TrimPrefix(ref, FileScheme)
+		b, err := ifs.ReadFile(f, name)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case strings.HasPrefix(ref, EnvScheme):
+		name := strings.TrimPrefix(ref, EnvScheme)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret env var %s is not set", name)
+		}
+		return v, nil
+	default:
+		return ref, nil
+	}
+}
+
+// Redact returns a copy of v in which every non-empty secret field is
+// replaced by Redacted. Slices are copied, so v itself is never modified.
+func Redact[T any](v T) T {
+	rv := reflect.ValueOf(&v).Elem()
+	rv.Set(redact(rv))
+	return v
+}
+
+func redact(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		p := reflect.New(v.Type().Elem())
+		p.Elem().Set(redact(v.Elem()))
+		return p
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s.Index(i).Set(redact(v.Index(i)))
+		}
+		return s
+	case reflect.Struct:
+		s := reflect.New(v.Type()).Elem()
+		s.Set(v)
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fv := s.Field(i)
+			if isSecret(field) && fv.Kind() == reflect.String {
+				if fv.String() != "" {
+					fv.SetString(Redacted)
+				}
+				continue
+			}
+			fv.Set(redact(fv))
+		}
+		return s
+	}
+	return v
+}
+
+func isSecret(field reflect.StructField) bool {
+	return field.Tag.Get("secret") == "true"
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type secretCert struct {
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key" secret:"true"`
+	Password string `yaml:"password" secret:"true"`
+}
+
+type secretConfig struct {
+	Topic  string       `yaml:"topic"`
+	Secret string       `yaml:"secret" secret:"true"`
+	Certs  []secretCert `yaml:"certs"`
+	Next   *secretCert  `yaml:"next"`
+}
+
+func TestExpand(t *testing.T) {
+	env := map[string]string{"HOST": "nsqd", "EMPTY": ""}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	tests := map[string]string{
+		"plain":                        "plain",
+		"${HOST}:4150":                 "nsqd:4150",
+		"${MISSING:-127.0.0.1}:4150":   "127.0.0.1:4150",
+		"${EMPTY:-fallback}":           "fallback",
+		"${EMPTY-fallback}":            "",
+		"${MISSING-fallback}":          "fallback",
+		"${MISSING:-}":                 "",
+		"$$HOST costs $5 and $${HOST}": "$HOST costs $5 and ${HOST}",
+		"trailing $":                   "trailing $",
+	}
+	for in, want := range tests {
+		got, err := Expand(in, lookup)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"${MISSING}", "${HOST", "${:-x}"} {
+		_, err := Expand(in, lookup)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestLoadInterpolation(t *testing.T) {
+	m := memFS(t, map[string]string{
+		"reader.yaml": "topic: ${TOPIC:-orders}\nhosts: [\"${HOST}:4150\"]\ntimeout: ${TIMEOUT:-1s}\nport: ${PORT}\nquoted: \"${PORT}\"\n",
+		"broken.yaml": "topic: ${UNSET_TOPIC}\n",
+	})
+	env := map[string]string{"HOST": "nsqd", "PORT": "4150"}
+	l := Loader{LookupEnv: func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}}
+
+	src, err := l.Load(m, "reader.yaml")
+	require.NoError(t, err)
+
+	var conf struct {
+		testConfig `yaml:",inline"`
+		Port       int    `yaml:"port"`
+		Quoted     string `yaml:"quoted"`
+	}
+	require.NoError(t, src.Decode(&conf))
+	assert.Equal(t, "orders", conf.Topic)
+	assert.Equal(t, []string{"nsqd:4150"}, conf.Hosts)
+	assert.Equal(t, 4150, conf.Port)
+	assert.Equal(t, "4150", conf.Quoted)
+
+	_, err = l.Load(m, "broken.yaml")
+	assert.ErrorContains(t, err, "UNSET_TOPIC")
+}
+
+func TestResolveSecrets(t *testing.T) {
+	m := memFS(t, map[string]string{"secrets/key.pem": "-----KEY-----\n"})
+	t.Setenv("TEST_CONFIG_PASSWORD", "hunter2")
+
+	conf := secretConfig{
+		Topic:  "env://TEST_CONFIG_PASSWORD",
+		Secret: "inline",
+		Certs: []secretCert{{
+			Cert:     "file://secrets/key.pem",
+			Key:      "file://secrets/key.pem",
+			Password: "env://TEST_CONFIG_PASSWORD",
+		}},
+		Next: &secretCert{Password: "env://TEST_CONFIG_PASSWORD"},
+	}
+	require.NoError(t, ResolveSecrets(m, &conf))
+	assert.Equal(t, "env://TEST_CONFIG_PASSWORD", conf.Topic)
+	assert.Equal(t, "inline", conf.Secret)
+	assert.Equal(t, "file://secrets/key.pem", conf.Certs[0].Cert)
+	assert.Equal(t, "-----KEY-----", conf.Certs[0].Key)
+	assert.Equal(t, "hunter2", conf.Certs[0].Password)
+	assert.Equal(t, "hunter2", conf.Next.Password)
+
+	assert.Error(t, ResolveSecrets(m, &secretConfig{Secret: "env://TEST_CONFIG_MISSING"}))
+	assert.Error(t, ResolveSecrets(m, &secretConfig{Secret: "file://secrets/missing"}))
+	assert.Error(t, ResolveSecrets(m, conf))
+}
+
+func TestRedact(t *testing.T) {
+	conf := secretConfig{
+		Topic:  "orders",
+		Secret: "s3cret",
+		Certs:  []secretCert{{Cert: "cert", Key: "key"}},
+		Next:   &secretCert{Password: "pw"},
+	}
+
+	redacted := Redact(conf)
+	assert.Equal(t, secretConfig{
+		Topic:  "orders",
+		Secret: Redacted,
+		Certs:  []secretCert{{Cert: "cert", Key: Redacted}},
+		Next:   &secretCert{Password: Redacted},
+	}, redacted)
+
+	// The original is untouched.
+	assert.Equal(t, "s3cret", conf.Secret)
+	assert.Equal(t, "key", conf.Certs[0].Key)
+	assert.Equal(t, "pw", conf.Next.Password)
+}
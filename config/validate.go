@@ -0,0 +1,60 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// CheckHostPort checks that addr is a host:port address with a non-empty
+// host and a valid port number.
+func CheckHostPort(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if host == "" {
+		return fmt.Errorf("invalid address %q: missing host", addr)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("invalid address %q: invalid port %q", addr, port)
+	}
+	return nil
+}
+
+// CheckHTTPAddress checks that addr is a host:port address, or an http or
+// https URL with such a host, as accepted for nsqlookupd.
+func CheckHTTPAddress(addr string) error {
+	if !strings.Contains(addr, "://") {
+		return CheckHostPort(addr)
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid address %q: unsupported scheme %s", addr, u.Scheme)
+	}
+	if err := CheckHostPort(u.Host); err != nil {
+		return fmt.Errorf("invalid address %q: host must be host:port", addr)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encrypt
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/deepauto-io/nsqcc/config"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+)
+
+// Config contains configuration params for payload encryption. Keys are read
+// either from a keyring file or from an inline list of id:base64 pairs.
+type Config struct {
+	Enabled     bool   `json:"enabled" envconfig:"ENCRYPTION_ENABLE" yaml:"enabled"`
+	Algorithm   string `json:"algorithm" envconfig:"ENCRYPTION_ALGORITHM" default:"aes-256-gcm" yaml:"algorithm"`
+	KeyringFile string `json:"keyring_file" envconfig:"ENCRYPTION_KEYRING_FILE" yaml:"keyring_file"`
+	Keys        string `json:"keys" envconfig:"ENCRYPTION_KEYS" yaml:"keys" secret:"true"`
+	ActiveKey   string `json:"active_key" envconfig:"ENCRYPTION_ACTIVE_KEY" yaml:"active_key"`
+}
+
+// NewConfig creates a new Config with the default values of its default tags.
+func NewConfig() Config {
+	return config.Defaults[Config]()
+}
+
+// Validate validates the configuration, reporting every problem at once. The
+// keyring file is only read by Get.
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	var errs []error
+	if _, err := newAEAD(c.Algorithm, make([]byte, KeySize)); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch {
+	case len(c.KeyringFile) > 0 && len(c.Keys) > 0:
+		errs = append(errs, errors.New("only one field between keyring_file and keys can be specified"))
+	case len(c.Keys) > 0:
+		if _, err := ParseKeyring(c.Keys, c.ActiveKey); err != nil {
+			errs = append(errs, fmt.Errorf("encryption keys: %w", err))
+		}
+	case len(c.KeyringFile) == 0:
+		errs = append(errs, errors.New("encryption requires either keyring_file or keys"))
+	}
+	return errors.Join(errs...)
+}
+
+// String returns the configuration with its secrets redacted, so that it can
+// be printed or logged.
+func (c Config) String() string {
+	return config.Format(c)
+}
+
+// Get returns a Cipher based on the configuration values of Config, or nil
+// if encryption is not enabled.
+func (c *Config) Get(f ifs.FS) (*Cipher, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	var ring *Keyring
+	var err error
+	if len(c.KeyringFile) > 0 {
+		ring, err = LoadKeyring(f, c.KeyringFile)
+	} else {
+		ring, err = ParseKeyring(c.Keys, c.ActiveKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewCipher(c.Algorithm, ring)
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/deepauto-io/nsqcc"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Envelope headers that describe how a message body was encrypted.
+const (
+	HeaderAlgorithm = "nsqcc-encryption"
+	HeaderKeyID     = "nsqcc-key-id"
+)
+
+// Names of the supported encryption algorithms.
+const (
+	AES256GCM         = "aes-256-gcm"
+	XChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+// KeySize is the size in bytes of the keys used by every algorithm.
+const KeySize = 32
+
+// Cipher encrypts message bodies with the active key of a Keyring and
+// decrypts them with whichever key they were encrypted with. A Cipher only
+// accepts messages encrypted with its own algorithm, and rejects plaintext
+// ones, so that the encryption headers cannot be stripped or downgraded. The
+// headers of a message are authenticated along with its body, so that none of
+// them, such as the compression header, can be changed, added or removed.
+type Cipher struct {
+	algorithm string
+	ring      *Keyring
+}
+
+// NewCipher creates a new Cipher using algorithm for encryption.
+func NewCipher(algorithm string, ring *Keyring) (*Cipher, error) {
+	if _, err := newAEAD(algorithm, make([]byte, KeySize)); err != nil {
+		return nil, err
+	}
+	if ring.Active() == "" {
+		return nil, errors.New("keyring has no active key")
+	}
+	return &Cipher{algorithm: algorithm, ring: ring}, nil
+}
+
+func newAEAD(algorithm string, key []byte) (cipher.AEAD, error) {
+	switch algorithm {
+	case AES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, fmt.Errorf("unknown encryption algorithm: %s", algorithm)
+}
+
+// Seal encrypts the body of env with the active key and records the algorithm
+// and key ID in its headers. The headers of env must not change afterwards.
+func (c *Cipher) Seal(env nsqcc.Envelope) (nsqcc.Envelope, error) {
+	keyID := c.ring.Active()
+	aead, err := newAEAD(c.algorithm, c.ring.keys[keyID])
+	if err != nil {
+		return env, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(env.Body)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return env, err
+	}
+
+	if env.Headers == nil {
+		env.Headers = map[string]string{}
+	}
+	env.Headers[HeaderAlgorithm] = c.algorithm
+	env.Headers[HeaderKeyID] = keyID
+	env.Body = aead.Seal(nonce, nonce, env.Body, additionalData(env.Headers))
+	return env, nil
+}
+
+// additionalData binds the headers, including the encryption headers, to the
+// ciphertext in their canonical envelope encoding, so that they cannot be
+// changed without failing authentication.
+func additionalData(headers map[string]string) []byte {
+	return nsqcc.Envelope{Headers: headers}.Encode()
+}
+
+// Open decrypts the body of env according to its headers and removes them.
+// A nil Cipher, used when encryption is not enabled, returns envelopes
+// without encryption headers unchanged. Every failure wraps
+// nsqcc.ErrDecrypt, including envelopes that are not encrypted with the
+// algorithm of c.
+func (c *Cipher) Open(env nsqcc.Envelope) (nsqcc.Envelope, error) {
+	algorithm, ok := env.Headers[HeaderAlgorithm]
+	if c == nil {
+		if ok {
+			return env, fmt.Errorf("%w: message is encrypted but no keyring is configured", nsqcc.ErrDecrypt)
+		}
+		return env, nil
+	}
+	if !ok {
+		return env, fmt.Errorf("%w: message is not encrypted", nsqcc.ErrDecrypt)
+	}
+	if algorithm != c.algorithm {
+		return env, fmt.Errorf("%w: message is encrypted with %q, expected %q", nsqcc.ErrDecrypt, algorithm, c.algorithm)
+	}
+	keyID := env.Headers[HeaderKeyID]
+
+	key, ok := c.ring.keys[keyID]
+	if !ok {
+		return env, fmt.Errorf("%w: unknown key id %q", nsqcc.ErrDecrypt, keyID)
+	}
+	aead, err := newAEAD(c.algorithm, key)
+	if err != nil {
+		return env, fmt.Errorf("%w: %v", nsqcc.ErrDecrypt, err)
+	}
+	if len(env.Body) < aead.NonceSize() {
+		return env, fmt.Errorf("%w: ciphertext too short", nsqcc.ErrDecrypt)
+	}
+
+	nonce, ciphertext := env.Body[:aead.NonceSize()], env.Body[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, additionalData(env.Headers))
+	if err != nil {
+		return env, fmt.Errorf("%w: key id %q: %v", nsqcc.ErrDecrypt, keyID, err)
+	}
+
+	delete(env.Headers, HeaderAlgorithm)
+	delete(env.Headers, HeaderKeyID)
+	env.Body = plain
+	return env, nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deepauto-io/nsqcc"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, KeySize))
+}
+
+func TestKeyRotation(t *testing.T) {
+	for _, alg := range []string{AES256GCM, XChaCha20Poly1305} {
+		t.Run(alg, func(t *testing.T) {
+			oldConf := Config{Enabled: true, Algorithm: alg, Keys: "k1:" + testKey(1), ActiveKey: "k1"}
+			oldCipher, err := oldConf.Get(ifs.OS())
+			require.NoError(t, err)
+
+			sealed, err := oldCipher.Seal(nsqcc.Envelope{Body: []byte("secret"), Headers: map[string]string{"source": "test"}})
+			require.NoError(t, err)
+			assert.Equal(t, "k1", sealed.Headers[HeaderKeyID])
+			assert.NotContains(t, string(sealed.Body), "secret")
+
+			dir := t.TempDir()
+			keyring := `{"active": "k2", "keys": {"k1": "` + testKey(1) + `", "k2": "` + testKey(2) + `"}}`
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "keyring.json"), []byte(keyring), 0o600))
+
+			newConf := Config{Enabled: true, Algorithm: alg, KeyringFile: filepath.Join(dir, "keyring.json")}
+			newCipher, err := newConf.Get(ifs.OS())
+			require.NoError(t, err)
+
+			opened, err := newCipher.Open(sealed)
+			require.NoError(t, err)
+			assert.Equal(t, "secret", string(opened.Body))
+			assert.Equal(t, map[string]string{"source": "test"}, opened.Headers)
+
+			sealed, err = newCipher.Seal(nsqcc.Envelope{Body: []byte("rotated")})
+			require.NoError(t, err)
+			assert.Equal(t, "k2", sealed.Headers[HeaderKeyID])
+
+			_, err = oldCipher.Open(sealed)
+			assert.ErrorIs(t, err, nsqcc.ErrDecrypt)
+		})
+	}
+}
+
+func TestOpenFailures(t *testing.T) {
+	conf := Config{Enabled: true, Algorithm: AES256GCM, Keys: "k1:" + testKey(1), ActiveKey: "k1"}
+	c, err := conf.Get(ifs.OS())
+	require.NoError(t, err)
+
+	sealed, err := c.Seal(nsqcc.Envelope{Body: []byte("secret")})
+	require.NoError(t, err)
+	sealed.Body[len(sealed.Body)-1] ^= 0xff
+
+	_, err = c.Open(sealed)
+	assert.ErrorIs(t, err, nsqcc.ErrDecrypt)
+
+	var none *Cipher
+	_, err = none.Open(sealed)
+	assert.ErrorIs(t, err, nsqcc.ErrDecrypt)
+
+	plain, err := none.Open(nsqcc.Envelope{Body: []byte("plain")})
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(plain.Body))
+
+	// Stripping the headers must not turn a forged body into plaintext.
+	_, err = c.Open(nsqcc.Envelope{Body: []byte("forged")})
+	assert.ErrorIs(t, err, nsqcc.ErrDecrypt)
+
+	sealed, err = c.Seal(nsqcc.Envelope{Body: []byte("secret")})
+	require.NoError(t, err)
+	downgraded := nsqcc.Envelope{Body: sealed.Body, Headers: map[string]string{
+		HeaderAlgorithm: XChaCha20Poly1305, HeaderKeyID: "k1",
+	}}
+	_, err = c.Open(downgraded)
+	assert.ErrorIs(t, err, nsqcc.ErrDecrypt)
+
+	// The headers are authenticated along with the body.
+	other, err := NewCipher(XChaCha20Poly1305, c.ring)
+	require.NoError(t, err)
+	_, err = other.Open(downgraded)
+	assert.ErrorIs(t, err, nsqcc.ErrDecrypt)
+}
+
+func TestOpenTamperedHeaders(t *testing.T) {
+	conf := Config{Enabled: true, Algorithm: AES256GCM, Keys: "k1:" + testKey(1), ActiveKey: "k1"}
+	c, err := conf.Get(ifs.OS())
+	require.NoError(t, err)
+
+	for name, tamper := range map[string]func(headers map[string]string){
+		"changed": func(headers map[string]string) { headers["nsqcc-compression"] = "zstd" },
+		"removed": func(headers map[string]string) { delete(headers, "nsqcc-compression") },
+		"added":   func(headers map[string]string) { headers["source"] = "test" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			sealed, err := c.Seal(nsqcc.Envelope{Body: []byte("secret"), Headers: map[string]string{"nsqcc-compression": "gzip"}})
+			require.NoError(t, err)
+
+			opened, err := c.Open(nsqcc.Envelope{Body: sealed.Body, Headers: maps.Clone(sealed.Headers)})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"nsqcc-compression": "gzip"}, opened.Headers)
+
+			tamper(sealed.Headers)
+			_, err = c.Open(sealed)
+			assert.ErrorIs(t, err, nsqcc.ErrDecrypt)
+		})
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for _, conf := range []Config{
+		{Enabled: true, Algorithm: AES256GCM},
+		{Enabled: true, Algorithm: "rot13", Keys: "k1:" + testKey(1), ActiveKey: "k1"},
+		{Enabled: true, Algorithm: AES256GCM, Keys: "k1:" + testKey(1), ActiveKey: "k2"},
+		{Enabled: true, Algorithm: AES256GCM, Keys: "k1:c2hvcnQ=", ActiveKey: "k1"},
+		{Enabled: true, Algorithm: AES256GCM, Keys: "k1", ActiveKey: "k1"},
+		{Enabled: true, Algorithm: AES256GCM, KeyringFile: "keyring.json", Keys: "k1:" + testKey(1), ActiveKey: "k1"},
+	} {
+		assert.Error(t, conf.Validate())
+		_, err := conf.Get(ifs.OS())
+		assert.Error(t, err)
+	}
+
+	c, err := (&Config{}).Get(ifs.OS())
+	require.NoError(t, err)
+	assert.Nil(t, c)
+	assert.NoError(t, Config{Algorithm: "rot13"}.Validate())
+
+	// Every problem is reported at once.
+	err = Config{Enabled: true, Algorithm: "rot13", Keys: "k1:c2hvcnQ=", ActiveKey: "k1"}.Validate()
+	assert.ErrorContains(t, err, "unknown encryption algorithm: rot13")
+	assert.ErrorContains(t, err, `encryption keys: key "k1" must be 32 bytes`)
+}
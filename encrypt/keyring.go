@@ -0,0 +1,109 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package encrypt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+)
+
+// Keyring holds a set of encryption keys by ID, one of which is active and
+// used to encrypt new messages. Retired keys stay in the keyring so that
+// messages encrypted with them can still be decrypted.
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+}
+
+// NewKeyring creates a Keyring from keys, with active as the key ID used for
+// encryption.
+func NewKeyring(keys map[string][]byte, active string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring has no keys")
+	}
+	for id, key := range keys {
+		if id == "" {
+			return nil, errors.New("keyring key id must not be empty")
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("key %q must be %d bytes, got %d", id, KeySize, len(key))
+		}
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active key %q not found in keyring", active)
+	}
+	return &Keyring{keys: keys, active: active}, nil
+}
+
+// Active returns the ID of the key used for encryption.
+func (k *Keyring) Active() string {
+	return k.active
+}
+
+type keyringFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// LoadKeyring reads a JSON keyring file of the form
+// {"active": "k2", "keys": {"k1": "<base64>", "k2": "<base64>"}}.
+func LoadKeyring(f ifs.FS, path string) (*Keyring, error) {
+	b, err := ifs.ReadFile(f, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var kf keyringFile
+	if err := json.Unmarshal(b, &kf); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring file %s: %w", path, err)
+	}
+
+	keys := make(map[string][]byte, len(kf.Keys))
+	for id, encoded := range kf.Keys {
+		if keys[id], err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("failed to decode key %q: %w", id, err)
+		}
+	}
+	return NewKeyring(keys, kf.Active)
+}
+
+// ParseKeyring parses keys from a comma separated list of id:base64 pairs,
+// such as "k1:<base64>,k2:<base64>", which is convenient for environment
+// variables.
+func ParseKeyring(s, active string) (*Keyring, error) {
+	keys := map[string][]byte{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, errors.New("keys must be of the form id:base64")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(keys, active)
+}
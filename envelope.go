@@ -0,0 +1,121 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nsqcc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+)
+
+// envelopeMagic prefixes message bodies that carry headers. NSQ has no
+// native message headers, so they are encoded in front of the body, followed
+// by a CRC-32 of the magic and headers so that plain bodies that happen to
+// start with the magic are not mistaken for envelopes.
+var envelopeMagic = []byte{0x00, 'N', 'Q', 'E', 0x02}
+
+var errMalformedEnvelope = errors.New("malformed message envelope")
+
+// Envelope is a message body along with a set of string headers.
+type Envelope struct {
+	Headers map[string]string
+	Body    []byte
+}
+
+// Encode returns the wire format of the envelope. An envelope without headers
+// is encoded as its plain body.
+func (e Envelope) Encode() []byte {
+	if len(e.Headers) == 0 {
+		return e.Body
+	}
+
+	keys := make([]string, 0, len(e.Headers))
+	for k := range e.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := append([]byte{}, envelopeMagic...)
+	buf = binary.AppendUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		buf = binary.AppendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = binary.AppendUvarint(buf, uint64(len(e.Headers[k])))
+		buf = append(buf, e.Headers[k]...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	return append(buf, e.Body...)
+}
+
+// DecodeEnvelope parses a message body produced by Envelope.Encode. Bodies
+// that do not carry headers are returned as an envelope with no headers, as
+// are bodies starting with the magic whose headers are malformed or fail the
+// checksum. A plain body is therefore only mistaken for an envelope when it
+// starts with the magic, well formed headers and their checksum, which
+// written by accident has a chance below 1 in 2^32.
+func DecodeEnvelope(b []byte) (Envelope, error) {
+	if !bytes.HasPrefix(b, envelopeMagic) {
+		return Envelope{Body: b}, nil
+	}
+	e, err := decodeEnvelope(b)
+	if err != nil {
+		return Envelope{Body: b}, nil
+	}
+	return e, nil
+}
+
+func decodeEnvelope(full []byte) (Envelope, error) {
+	b := full[len(envelopeMagic):]
+
+	readString := func() (string, error) {
+		l, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < l {
+			return "", errMalformedEnvelope
+		}
+		s := string(b[n : n+int(l)])
+		b = b[n+int(l):]
+		return s, nil
+	}
+
+	count, n := binary.Uvarint(b)
+	if n <= 0 || count == 0 {
+		return Envelope{}, errMalformedEnvelope
+	}
+	b = b[n:]
+
+	e := Envelope{Headers: map[string]string{}}
+	for i := uint64(0); i < count; i++ {
+		k, err := readString()
+		if err != nil {
+			return Envelope{}, err
+		}
+		v, err := readString()
+		if err != nil {
+			return Envelope{}, err
+		}
+		e.Headers[k] = v
+	}
+
+	headerLen := len(full) - len(b)
+	if len(b) < 4 || binary.BigEndian.Uint32(b) != crc32.ChecksumIEEE(full[:headerLen]) {
+		return Envelope{}, errMalformedEnvelope
+	}
+	e.Body = b[4:]
+	return e, nil
+}
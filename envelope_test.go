@@ -0,0 +1,61 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nsqcc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	env := Envelope{Headers: map[string]string{"b": "2", "a": "1"}, Body: []byte("body")}
+	decoded, err := DecodeEnvelope(env.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, env, decoded)
+
+	plain := Envelope{Body: []byte("body")}
+	assert.Equal(t, []byte("body"), plain.Encode())
+	decoded, err = DecodeEnvelope(plain.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, plain, decoded)
+}
+
+func TestDecodeEnvelopePlainBodyWithMagic(t *testing.T) {
+	for name, body := range map[string][]byte{
+		"magic only":       append([]byte{}, envelopeMagic...),
+		"valid headers":    append(append([]byte{}, envelopeMagic...), 1, 1, 'k', 1, 'v', 'b', 'o', 'd', 'y'),
+		"truncated header": append(append([]byte{}, envelopeMagic...), 2, 1, 'k'),
+		"no headers":       append(append([]byte{}, envelopeMagic...), 0, 0, 0, 0, 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			env, err := DecodeEnvelope(body)
+			require.NoError(t, err)
+			assert.Empty(t, env.Headers)
+			assert.Equal(t, body, env.Body)
+		})
+	}
+
+	// A corrupted checksum turns an envelope into a plain body.
+	b := Envelope{Headers: map[string]string{"k": "v"}, Body: []byte("body")}.Encode()
+	b[len(b)-len("body")-1] ^= 0xff
+	env, err := DecodeEnvelope(b)
+	require.NoError(t, err)
+	assert.Empty(t, env.Headers)
+	assert.Equal(t, b, env.Body)
+}
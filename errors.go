@@ -16,10 +16,68 @@ limitations under the License.
 
 package nsqcc
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nsqio/go-nsq"
+)
 
 var (
 	ErrTimeout      = errors.New("action timed out")
 	ErrTypeClosed   = errors.New("type was closed")
 	ErrNotConnected = errors.New("not connected to target source or sink")
+	ErrDecode       = errors.New("failed to decode message payload")
+	ErrDecrypt      = errors.New("failed to decrypt message payload")
 )
+
+// DecodeError is returned by readers for a message whose payload could not be
+// decoded. The reader has already finished or requeued Msg, so the error
+// concerns that message alone and is not a reason to retry the read. It
+// matches ErrDecode with errors.Is.
+type DecodeError struct {
+	Msg *nsq.Message
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: message %s: %s", ErrDecode, e.Msg.ID[:], e.Err)
+}
+
+func (e *DecodeError) Unwrap() []error {
+	return []error{ErrDecode, e.Err}
+}
+
+// AuthError is returned when nsqd requires an auth secret that was not
+// provided, or rejects the one that was.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return "nsq authentication failed: " + e.Err.Error()
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// WrapAuthError returns err as an *AuthError if it was caused by nsqd
+// refusing authentication, and err unchanged otherwise.
+func WrapAuthError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return err
+	}
+	msg := err.Error()
+	for _, s := range []string{"Auth Required", "Error authenticating", "E_AUTH_FAILED", "E_UNAUTHORIZED"} {
+		if strings.Contains(msg, s) {
+			return &AuthError{Err: err}
+		}
+	}
+	return err
+}
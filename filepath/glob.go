@@ -18,22 +18,52 @@ package filepath
 
 import (
 	"errors"
+	"fmt"
 	"io/fs"
+	"path"
 	"runtime"
+	"sort"
 	"strings"
 )
 
+// ErrTooManyResults is returned when a glob expansion matches more paths than
+// GlobOptions.MaxResults allows.
+var ErrTooManyResults = errors.New("glob matched too many paths")
+
+// GlobOptions guard glob expansions against unexpectedly large directory
+// trees. The zero value imposes no limits.
+type GlobOptions struct {
+	// MaxDepth limits how many directories below their starting point **
+	// patterns and super paths descend. Zero means unlimited.
+	MaxDepth int
+	// MaxResults fails an expansion with ErrTooManyResults when more
+	// distinct paths match, before exclusions are applied. Zero means
+	// unlimited.
+	MaxResults int
+}
+
 // GlobsAndSuperPaths attempts to expand a list of paths, which may include glob
 // patterns and super paths (the ... thing) to a list of explicit file paths.
 // Extensions must be provided, and limit the file types that are captured with
 // a super path.
 func GlobsAndSuperPaths(f fs.FS, paths []string, extensions ...string) ([]string, error) {
+	return GlobOptions{}.GlobsAndSuperPaths(f, paths, extensions...)
+}
+
+// GlobsAndSuperPaths behaves like the package level GlobsAndSuperPaths within
+// the limits of o.
+func (o GlobOptions) GlobsAndSuperPaths(f fs.FS, paths []string, extensions ...string) ([]string, error) {
 	if len(extensions) == 0 {
 		return nil, errors.New("must specify at least one extension for super paths")
 	}
 
-	var superPaths, skippedPaths []string
+	var skippedPaths, exclusions []string
+	res := o.results()
 	for _, p := range paths {
+		if strings.HasPrefix(p, "!") {
+			exclusions = append(exclusions, p)
+			continue
+		}
 		if strings.HasSuffix(p, "...") {
 			if p == "./..." || p == "..." {
 				p = "."
@@ -45,12 +75,14 @@ func GlobsAndSuperPaths(f fs.FS, paths []string, extensions ...string) ([]string
 					return werr
 				}
 				if info.IsDir() {
+					if o.tooDeep(p, path) {
+						return fs.SkipDir
+					}
 					return nil
 				}
 				for _, ext := range extensions {
 					if strings.HasSuffix(path, ext) {
-						superPaths = append(superPaths, path)
-						return nil
+						return res.add(path)
 					}
 				}
 				return nil
@@ -62,15 +94,10 @@ func GlobsAndSuperPaths(f fs.FS, paths []string, extensions ...string) ([]string
 		}
 	}
 
-	resultPaths := append([]string{}, superPaths...)
-	if len(skippedPaths) > 0 {
-		globPaths, err := Globs(f, skippedPaths)
-		if err != nil {
-			return nil, err
-		}
-		resultPaths = append(resultPaths, globPaths...)
+	if err := o.globs(f, skippedPaths, res); err != nil {
+		return nil, err
 	}
-	return resultPaths, nil
+	return finish(res.paths, exclusions)
 }
 
 // hasMeta reports whether path contains any of the magic characters
@@ -86,58 +113,161 @@ func hasMeta(path string) bool {
 }
 
 // Globs attempts to expand a list of paths, which may include glob patterns, to
-// a list of explicit file paths. The paths are de-duplicated but are not
+// a list of explicit file paths. Patterns support ** to match any number of
+// directories and {a,b} alternatives, and patterns prefixed with ! exclude
+// the paths they match from the result. The paths are de-duplicated and
 // sorted.
 func Globs(f fs.FS, paths []string) ([]string, error) {
-	var expandedPaths []string
-	seenPaths := map[string]struct{}{}
+	return GlobOptions{}.Globs(f, paths)
+}
 
-	for _, path := range paths {
-		var globbed []string
-		var err error
-		if segments := strings.Split(path, "**"); len(segments) == 1 {
-			globbed, err = fs.Glob(f, path)
+// Globs behaves like the package level Globs within the limits of o.
+func (o GlobOptions) Globs(f fs.FS, paths []string) ([]string, error) {
+	var patterns, exclusions []string
+	for _, p := range paths {
+		if strings.HasPrefix(p, "!") {
+			exclusions = append(exclusions, p)
 		} else {
-			globbed, err = superGlobs(f, segments)
+			patterns = append(patterns, p)
+		}
+	}
+
+	res := o.results()
+	if err := o.globs(f, patterns, res); err != nil {
+		return nil, err
+	}
+	return finish(res.paths, exclusions)
+}
+
+// results accumulates the distinct paths of an expansion, failing with
+// ErrTooManyResults once there are more than max of them.
+type results struct {
+	max   int
+	paths []string
+	seen  map[string]struct{}
+}
+
+func (o GlobOptions) results() *results {
+	return &results{max: o.MaxResults, seen: map[string]struct{}{}}
+}
+
+func (r *results) add(paths ...string) error {
+	for _, p := range paths {
+		if _, ok := r.seen[p]; ok {
+			continue
 		}
-		if err != nil {
-			return nil, err
+		if r.max > 0 && len(r.paths) == r.max {
+			return fmt.Errorf("%w: more than %d paths", ErrTooManyResults, r.max)
 		}
-		for _, gPath := range globbed {
-			if _, seen := seenPaths[gPath]; !seen {
-				expandedPaths = append(expandedPaths, gPath)
-				seenPaths[gPath] = struct{}{}
+		r.seen[p] = struct{}{}
+		r.paths = append(r.paths, p)
+	}
+	return nil
+}
+
+func (o GlobOptions) globs(f fs.FS, paths []string, res *results) error {
+	for _, original := range paths {
+		alternatives := expandBraces(original)
+		for _, path := range alternatives {
+			var globbed []string
+			var err error
+			if segments := strings.Split(path, "**"); len(segments) == 1 {
+				globbed, err = fs.Glob(f, path)
+			} else {
+				globbed, err = o.superGlobs(f, segments)
+			}
+			if err != nil {
+				return err
+			}
+			if err := res.add(globbed...); err != nil {
+				return err
 			}
+			// Explicit paths are kept even when they do not exist, unless
+			// they are one of several brace alternatives.
+			if len(globbed) == 0 && !hasMeta(path) && len(alternatives) == 1 {
+				if err := res.add(path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// finish de-duplicates and sorts paths, removing those matched by exclusions.
+func finish(paths, exclusions []string) ([]string, error) {
+	var excluded []string
+	for _, e := range exclusions {
+		excluded = append(excluded, expandBraces(strings.TrimPrefix(e, "!"))...)
+	}
+
+	result := make([]string, 0, len(paths))
+	seenPaths := map[string]struct{}{}
+	for _, p := range paths {
+		if _, seen := seenPaths[p]; seen {
+			continue
 		}
-		if len(globbed) == 0 && !hasMeta(path) {
-			if _, seen := seenPaths[path]; !seen {
-				expandedPaths = append(expandedPaths, path)
-				seenPaths[path] = struct{}{}
+		seenPaths[p] = struct{}{}
+
+		skip := false
+		for _, e := range excluded {
+			matched, err := matchPattern(e, p)
+			if err != nil {
+				return nil, err
 			}
+			if matched {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			result = append(result, p)
 		}
 	}
 
-	return expandedPaths, nil
+	sort.Strings(result)
+	return result, nil
+}
+
+// tooDeep reports whether p is more than MaxDepth directories below root.
+func (o GlobOptions) tooDeep(root, p string) bool {
+	if o.MaxDepth <= 0 || p == root {
+		return false
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+	if root == "." {
+		rel = p
+	}
+	return strings.Count(rel, "/")+1 > o.MaxDepth
 }
 
 // Inspired by https://github.com/yargevad/filepathx/blob/master/filepathx.go
-func superGlobs(f fs.FS, segments []string) ([]string, error) {
+func (o GlobOptions) superGlobs(f fs.FS, segments []string) ([]string, error) {
 	matches := map[string]struct{}{"": {}}
 
 	for i, segment := range segments {
 		newMatches := map[string]struct{}{}
 		lastSegment := (len(segments) - 1) == i
 
-		for match := range matches {
-			paths, err := fs.Glob(f, match+segment)
+		for _, match := range sortedKeys(matches) {
+			// Joining keeps patterns valid for fs.FS implementations that
+			// reject empty elements and trailing slashes.
+			pattern := path.Join(match, segment)
+			if pattern == "" {
+				pattern = "."
+			}
+			paths, err := fs.Glob(f, pattern)
 			if err != nil {
 				return nil, err
 			}
-			for _, path := range paths {
-				if err := fs.WalkDir(f, path, func(newPath string, info fs.DirEntry, err error) error {
+			for _, root := range paths {
+				if err := fs.WalkDir(f, root, func(newPath string, info fs.DirEntry, err error) error {
 					if err != nil {
 						return err
 					}
+					if info.IsDir() && o.tooDeep(root, newPath) {
+						return fs.SkipDir
+					}
 					if lastSegment && info.IsDir() {
 						return nil
 					}
@@ -152,9 +282,103 @@ func superGlobs(f fs.FS, segments []string) ([]string, error) {
 		matches = newMatches
 	}
 
-	matchSlice := make([]string, 0, len(matches))
-	for path := range matches {
-		matchSlice = append(matchSlice, path)
+	return sortedKeys(matches), nil
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expandBraces expands the first {a,b} group of pattern, recursively, into
+// one pattern per alternative. Groups without a comma are left untouched.
+func expandBraces(pattern string) []string {
+	depth, start := 0, -1
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if runtime.GOOS != "windows" {
+				i++
+			}
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			if depth--; depth > 0 {
+				continue
+			}
+			alternatives := splitAlternatives(pattern[start+1 : i])
+			if len(alternatives) < 2 {
+				start = -1
+				continue
+			}
+			prefix, suffix := pattern[:start], pattern[i+1:]
+			var expanded []string
+			for _, alt := range alternatives {
+				expanded = append(expanded, expandBraces(prefix+alt+suffix)...)
+			}
+			return expanded
+		}
+	}
+	return []string{pattern}
+}
+
+// splitAlternatives splits s on commas that are not nested within braces.
+func splitAlternatives(s string) []string {
+	var parts []string
+	depth, last := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if runtime.GOOS != "windows" {
+				i++
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, s[last:])
+}
+
+// matchPattern reports whether name matches pattern, where a ** segment
+// matches any number of directories and other segments follow path.Match.
+func matchPattern(pattern, name string) (bool, error) {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if ok, err := matchSegments(pattern[1:], name[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		if ok, err := path.Match(pattern[0], name[0]); !ok || err != nil {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
 	}
-	return matchSlice, nil
+	return len(name) == 0, nil
 }
@@ -203,3 +203,120 @@ func TestGlobsAndSuperPaths(t *testing.T) {
 		})
 	}
 }
+
+func TestGlobsMemFS(t *testing.T) {
+	mfs := ifs.NewMemFS()
+	for _, path := range []string{
+		`src/cats/a.js`,
+		`src/cats/b.txt`,
+		`src/cats/meows/c.js`,
+	} {
+		require.NoError(t, mfs.MkdirAll(filepath.Dir(path), 0o755))
+		f, err := mfs.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	matches, err := Globs(mfs, []string{`src/**/*.js`, `src/cats/*.txt`})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{`src/cats/a.js`, `src/cats/meows/c.js`, `src/cats/b.txt`}, matches)
+
+	matches, err = GlobsAndSuperPaths(mfs, []string{`src/...`}, "js")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{`src/cats/a.js`, `src/cats/meows/c.js`}, matches)
+}
+
+func TestGlobsOrderingAndExclusions(t *testing.T) {
+	mfs := ifs.NewMemFS()
+	for _, path := range []string{
+		`conf/b.yaml`,
+		`conf/a.yaml`,
+		`conf/c.yml`,
+		`conf/local.yaml`,
+		`conf/d/e.yaml`,
+		`conf/d/f/g.yaml`,
+		`conf/d/f/h/i.yaml`,
+	} {
+		require.NoError(t, mfs.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, ifs.WriteFile(mfs, path, nil, 0o644))
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		opts     GlobOptions
+		matches  []string
+		err      error
+	}{
+		{
+			name:     "sorted",
+			patterns: []string{`conf/*.yaml`, `conf/c.yml`},
+			matches:  []string{`conf/a.yaml`, `conf/b.yaml`, `conf/c.yml`, `conf/local.yaml`},
+		},
+		{
+			name:     "exclusion",
+			patterns: []string{`conf/*.yaml`, `!conf/local.yaml`},
+			matches:  []string{`conf/a.yaml`, `conf/b.yaml`},
+		},
+		{
+			name:     "super glob exclusion",
+			patterns: []string{`!conf/**/f/**`, `conf/**/*.yaml`},
+			matches:  []string{`conf/a.yaml`, `conf/b.yaml`, `conf/d/e.yaml`, `conf/local.yaml`},
+		},
+		{
+			name:     "braces",
+			patterns: []string{`conf/{a,c}.{yaml,yml}`, `conf/{missing,b}.yaml`},
+			matches:  []string{`conf/a.yaml`, `conf/b.yaml`, `conf/c.yml`},
+		},
+		{
+			name:     "max depth",
+			patterns: []string{`conf/**/*.yaml`},
+			opts:     GlobOptions{MaxDepth: 2},
+			matches:  []string{`conf/a.yaml`, `conf/b.yaml`, `conf/d/e.yaml`, `conf/d/f/g.yaml`, `conf/local.yaml`},
+		},
+		{
+			name:     "max results",
+			patterns: []string{`conf/**/*.yaml`},
+			opts:     GlobOptions{MaxResults: 3},
+			err:      ErrTooManyResults,
+		},
+		{
+			name:     "max results counts distinct paths",
+			patterns: []string{`conf/*.yaml`, `conf/a.yaml`, `conf/{a,b}.yaml`},
+			opts:     GlobOptions{MaxResults: 3},
+			matches:  []string{`conf/a.yaml`, `conf/b.yaml`, `conf/local.yaml`},
+		},
+		{
+			name:     "max results after exclusions",
+			patterns: []string{`conf/*.yaml`, `!conf/{a,b}.yaml`},
+			opts:     GlobOptions{MaxResults: 4},
+			matches:  []string{`conf/local.yaml`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				matches, err := test.opts.Globs(mfs, test.patterns)
+				if test.err != nil {
+					require.ErrorIs(t, err, test.err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, test.matches, matches)
+			}
+		})
+	}
+
+	matches, err := GlobOptions{MaxDepth: 1}.GlobsAndSuperPaths(mfs, []string{`conf/...`, `!conf/local.yaml`}, "yaml")
+	require.NoError(t, err)
+	assert.Equal(t, []string{`conf/a.yaml`, `conf/b.yaml`, `conf/d/e.yaml`}, matches)
+}
+
+func TestExpandBraces(t *testing.T) {
+	assert.Equal(t, []string{`a.js`, `a.ts`}, expandBraces(`a.{js,ts}`))
+	assert.Equal(t, []string{`x/a/1`, `x/a/2`, `x/b`}, expandBraces(`x/{a/{1,2},b}`))
+	assert.Equal(t, []string{`{a}`}, expandBraces(`{a}`))
+	assert.Equal(t, []string{`a\{b,c}`}, expandBraces(`a\{b,c}`))
+	assert.Equal(t, []string{`{a,b{}`}, expandBraces(`{a,b{}`), "unbalanced braces are literal")
+}
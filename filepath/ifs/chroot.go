@@ -0,0 +1,207 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ifs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrEscapesRoot is returned when a path given to a Chroot FS resolves
+// outside of its root.
+var ErrEscapesRoot = errors.New("path escapes root")
+
+// ChrootOption configures a Chroot FS.
+type ChrootOption func(c *chrootFS)
+
+// ChrootReadOnly rejects every call that would modify the FS with an error
+// wrapping ErrReadOnly.
+func ChrootReadOnly() ChrootOption {
+	return func(c *chrootFS) {
+		c.readOnly = true
+	}
+}
+
+// Chroot returns an FS that resolves every path under root on base. Both
+// relative and absolute paths are relative to root, and paths that lexically
+// climb above it, such as ../../etc/shadow, are rejected with an error
+// wrapping ErrEscapesRoot.
+//
+// When base is OS(), or a Chroot of OS(), symbolic links are resolved as
+// well, and paths that lead outside of root through a link are rejected. The
+// check happens before the file is opened, so it does not protect against
+// links swapped concurrently by a process with write access to root.
+//
+// Other bases, including an Overlay or ReadOnly FS wrapping OS(), only get
+// the lexical check, as their paths cannot be mapped to the paths links are
+// resolved on. Chroot the OS directory itself before wrapping it when links
+// under it are not trusted.
+func Chroot(base FS, root string, opts ...ChrootOption) RenameFS {
+	c := &chrootFS{base: base, isOS: IsOS(base)}
+	if inner, ok := base.(*chrootFS); ok && inner.isOS {
+		// A Chroot of an OS Chroot is flattened into a single OS root, so
+		// that links under it are checked as well. Like any path given to
+		// the inner Chroot, root cannot climb above the inner root.
+		c.base, c.isOS, c.readOnly = inner.base, true, inner.readOnly
+		root = filepath.Join(inner.root, filepath.FromSlash(path.Clean("/"+filepath.ToSlash(root))))
+	}
+	if c.isOS {
+		c.root = filepath.Clean(root)
+	} else {
+		c.root = path.Clean(filepath.ToSlash(root))
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type chrootFS struct {
+	base     FS
+	root     string
+	isOS     bool
+	readOnly bool
+}
+
+// resolve maps name to a path on the base FS.
+func (c *chrootFS) resolve(op, name string) (string, error) {
+	clean := path.Clean(filepath.ToSlash(name))
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", &fs.PathError{Op: op, Path: name, Err: ErrEscapesRoot}
+	}
+	clean = strings.TrimPrefix(clean, "/")
+
+	if !c.isOS {
+		return path.Join(c.root, clean), nil
+	}
+
+	full := filepath.Join(c.root, filepath.FromSlash(clean))
+	if err := c.checkSymlinks(full); err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return full, nil
+}
+
+// checkSymlinks ensures that the deepest existing ancestor of full, or full
+// itself, resolves inside of the root once symbolic links are followed.
+func (c *chrootFS) checkSymlinks(full string) error {
+	realRoot, err := filepath.EvalSymlinks(c.root)
+	if err != nil {
+		return err
+	}
+
+	p := full
+	for {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			if !within(realRoot, real) {
+				return ErrEscapesRoot
+			}
+			return nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		// A dangling link could be followed when creating the file, so it
+		// is rejected as we cannot tell where it leads.
+		if _, lerr := os.Lstat(p); lerr == nil {
+			return ErrEscapesRoot
+		}
+		parent := filepath.Dir(p)
+		if parent == p || len(parent) < len(c.root) {
+			return err
+		}
+		p = parent
+	}
+}
+
+// within reports whether p is root or a path below it.
+func within(root, p string) bool {
+	if p == root {
+		return true
+	}
+	// The file system root, "/" or a volume such as `C:\`, already ends
+	// with a separator.
+	if !strings.HasSuffix(root, string(filepath.Separator)) {
+		root += string(filepath.Separator)
+	}
+	return strings.HasPrefix(p, root)
+}
+
+func (c *chrootFS) Open(name string) (fs.File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (c *chrootFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if c.readOnly && flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrReadOnly}
+	}
+	p, err := c.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return c.base.OpenFile(p, flag, perm)
+}
+
+func (c *chrootFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := c.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return c.base.Stat(p)
+}
+
+func (c *chrootFS) Remove(name string) error {
+	if c.readOnly {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+	}
+	p, err := c.resolve("remove", name)
+	if err != nil {
+		return err
+	}
+	return c.base.Remove(p)
+}
+
+func (c *chrootFS) MkdirAll(name string, perm fs.FileMode) error {
+	if c.readOnly {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: ErrReadOnly}
+	}
+	p, err := c.resolve("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return c.base.MkdirAll(p, perm)
+}
+
+func (c *chrootFS) Rename(oldpath, newpath string) error {
+	if c.readOnly {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: ErrReadOnly}
+	}
+	oldp, err := c.resolve("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	newp, err := c.resolve("rename", newpath)
+	if err != nil {
+		return err
+	}
+	return Rename(c.base, oldp, newp)
+}
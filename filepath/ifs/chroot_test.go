@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ifs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChrootMemFS(t *testing.T) {
+	m := NewMemFS()
+	require.NoError(t, m.MkdirAll("tenants/a/certs", 0o755))
+	require.NoError(t, WriteFile(m, "tenants/a/certs/ca.pem", []byte("ca"), 0o600))
+	require.NoError(t, WriteFile(m, "secret", []byte("secret"), 0o600))
+
+	c := Chroot(m, "tenants/a")
+	for _, name := range []string{"certs/ca.pem", "/certs/ca.pem", "certs/../certs/ca.pem"} {
+		b, err := ReadFile(c, name)
+		require.NoError(t, err, name)
+		assert.Equal(t, "ca", string(b))
+	}
+
+	for _, name := range []string{"../../secret", "..", "certs/../../../secret"} {
+		_, err := ReadFile(c, name)
+		assert.ErrorIs(t, err, ErrEscapesRoot, name)
+		_, err = c.Stat(name)
+		assert.ErrorIs(t, err, ErrEscapesRoot, name)
+		assert.ErrorIs(t, WriteFile(c, name, nil, 0o600), ErrEscapesRoot, name)
+	}
+
+	require.NoError(t, WriteFileAtomic(c, "certs/key.pem", []byte("key"), 0o600))
+	b, err := m.ReadFile("tenants/a/certs/key.pem")
+	require.NoError(t, err)
+	assert.Equal(t, "key", string(b))
+
+	entries, err := fs.ReadDir(c, "certs")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	ro := Chroot(m, "tenants/a", ChrootReadOnly())
+	_, err = ReadFile(ro, "certs/ca.pem")
+	require.NoError(t, err)
+	assert.ErrorIs(t, WriteFile(ro, "certs/ca.pem", nil, 0o600), ErrReadOnly)
+	assert.ErrorIs(t, ro.Remove("certs/ca.pem"), ErrReadOnly)
+	assert.ErrorIs(t, ro.MkdirAll("more", 0o755), ErrReadOnly)
+	assert.ErrorIs(t, ro.Rename("certs/ca.pem", "ca.pem"), ErrReadOnly)
+}
+
+func TestChrootSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "shadow"), []byte("secret"), 0o600))
+
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "certs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "certs", "ca.pem"), []byte("ca"), 0o600))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "shadow"), filepath.Join(root, "shadow")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "out")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "missing"), filepath.Join(root, "dangling")))
+	require.NoError(t, os.Symlink("certs/ca.pem", filepath.Join(root, "ca.pem")))
+
+	c := Chroot(OS(), root)
+
+	b, err := ReadFile(c, "ca.pem")
+	require.NoError(t, err)
+	assert.Equal(t, "ca", string(b))
+
+	_, err = ReadFile(c, "shadow")
+	assert.ErrorIs(t, err, ErrEscapesRoot)
+	_, err = ReadFile(c, "out/shadow")
+	assert.ErrorIs(t, err, ErrEscapesRoot)
+	assert.ErrorIs(t, WriteFile(c, "out/new", nil, 0o600), ErrEscapesRoot)
+	assert.ErrorIs(t, WriteFile(c, "dangling", nil, 0o600), ErrEscapesRoot)
+	_, err = os.Stat(filepath.Join(outside, "missing"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.NoError(t, WriteFile(c, "certs/new.pem", []byte("new"), 0o600))
+	_, err = ReadFile(c, "certs/missing.pem")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestChrootNestedSymlinks(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "conf", "certs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "conf", "certs", "ca.pem"), []byte("ca"), 0o600))
+	require.NoError(t, os.Symlink(filepath.Join(root, "secret"), filepath.Join(root, "conf", "secret")))
+
+	c := Chroot(Chroot(OS(), root, ChrootReadOnly()), "../conf")
+
+	b, err := ReadFile(c, "certs/ca.pem")
+	require.NoError(t, err)
+	assert.Equal(t, "ca", string(b))
+	_, err = ReadFile(c, "secret")
+	assert.ErrorIs(t, err, ErrEscapesRoot)
+	assert.ErrorIs(t, WriteFile(c, "certs/new.pem", nil, 0o600), ErrReadOnly)
+}
+
+func TestChrootFileSystemRoot(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("ca"), 0o600))
+
+	c := Chroot(OS(), string(filepath.Separator))
+	b, err := ReadFile(c, filepath.ToSlash(filepath.Join(dir, "ca.pem")))
+	require.NoError(t, err)
+	assert.Equal(t, "ca", string(b))
+}
@@ -0,0 +1,539 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ifs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	_ RenameFS      = (*MemFS)(nil)
+	_ WatchFS       = (*MemFS)(nil)
+	_ fs.ReadDirFS  = (*MemFS)(nil)
+	_ fs.ReadFileFS = (*MemFS)(nil)
+)
+
+// MemFS is a thread-safe, in-memory implementation of FS. Like any fs.FS, it
+// only accepts unrooted, slash separated paths as described by fs.ValidPath.
+type MemFS struct {
+	mut   sync.RWMutex
+	nodes map[string]*memNode
+
+	watchMut sync.Mutex
+	watchers map[*memBackend]struct{}
+}
+
+type memNode struct {
+	mode    fs.FileMode
+	modTime time.Time
+	data    []byte
+}
+
+// NewMemFS creates an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		nodes: map[string]*memNode{
+			".": {mode: fs.ModeDir | 0o755, modTime: time.Now()},
+		},
+	}
+}
+
+// memPath validates name, which is used as is as the key of the nodes map.
+func memPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return name, nil
+}
+
+// Open opens the named file for reading.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the named file with the os.O_* flags given, creating it with
+// perm if os.O_CREATE is set and it does not exist. Its parent directory must
+// exist.
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	p, err := memPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	node, exists := m.nodes[p]
+	switch {
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !exists:
+		parent, ok := m.nodes[path.Dir(p)]
+		if !ok {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if !parent.mode.IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: errNotDir}
+		}
+		node = &memNode{mode: perm.Perm(), modTime: time.Now()}
+		m.nodes[p] = node
+		m.notify(p, Create)
+	case node.mode.IsDir() && writable:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+
+	if node.mode.IsDir() {
+		return &listedDir{info: node.info(path.Base(p)), entries: m.readDir(p)}, nil
+	}
+	if writable && flag&os.O_TRUNC != 0 && len(node.data) > 0 {
+		node.data = nil
+		node.modTime = time.Now()
+		m.notify(p, Write)
+	}
+	return &memFile{fs: m, name: p, node: node, flag: flag}, nil
+}
+
+// Stat returns a fs.FileInfo describing the named file.
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := memPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	node, ok := m.nodes[p]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return node.info(path.Base(p)), nil
+}
+
+// ReadFile returns the contents of the named file.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	p, err := memPath("read", name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	node, ok := m.nodes[p]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	return append([]byte(nil), node.data...), nil
+}
+
+// ReadDir returns the entries of the named directory sorted by name.
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := memPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	node, ok := m.nodes[p]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	return m.readDir(p), nil
+}
+
+// readDir lists the children of directory p, the lock must be held.
+func (m *MemFS) readDir(p string) []fs.DirEntry {
+	prefix := p + "/"
+	if p == "." {
+		prefix = ""
+	}
+
+	var entries []fs.DirEntry
+	for name, node := range m.nodes {
+		if name == "." || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		base := name[len(prefix):]
+		if strings.Contains(base, "/") {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(node.info(base)))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+// Remove removes the named file or empty directory.
+func (m *MemFS) Remove(name string) error {
+	p, err := memPath("remove", name)
+	if err != nil {
+		return err
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	node, ok := m.nodes[p]
+	switch {
+	case !ok:
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	case p == ".":
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	case node.mode.IsDir() && len(m.readDir(p)) > 0:
+		return &fs.PathError{Op: "remove", Path: name, Err: errDirNotEmpty}
+	}
+	delete(m.nodes, p)
+	m.notify(p, Remove)
+	return nil
+}
+
+// Rename moves oldpath to newpath, replacing newpath if it is an existing
+// file. Directories are moved along with their contents.
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	oldp, err := memPath("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	newp, err := memPath("rename", newpath)
+	if err != nil {
+		return err
+	}
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	node, ok := m.nodes[oldp]
+	switch {
+	case !ok:
+		return linkErr(fs.ErrNotExist)
+	case oldp == "." || newp == ".":
+		return linkErr(fs.ErrInvalid)
+	case oldp == newp:
+		return nil
+	case node.mode.IsDir() && strings.HasPrefix(newp, oldp+"/"):
+		return linkErr(fs.ErrInvalid)
+	}
+	if parent, ok := m.nodes[path.Dir(newp)]; !ok || !parent.mode.IsDir() {
+		return linkErr(fs.ErrNotExist)
+	}
+	if target, ok := m.nodes[newp]; ok {
+		if target.mode.IsDir() {
+			return linkErr(errIsDir)
+		}
+		if node.mode.IsDir() {
+			return linkErr(errNotDir)
+		}
+	}
+
+	_, replaced := m.nodes[newp]
+	m.nodes[newp] = node
+	delete(m.nodes, oldp)
+	m.notify(oldp, Remove)
+	if replaced {
+		m.notify(newp, Write)
+	} else {
+		m.notify(newp, Create)
+	}
+	if node.mode.IsDir() {
+		for name, child := range m.nodes {
+			if strings.HasPrefix(name, oldp+"/") {
+				moved := newp + name[len(oldp):]
+				m.nodes[moved] = child
+				delete(m.nodes, name)
+				m.notify(name, Remove)
+				m.notify(moved, Create)
+			}
+		}
+	}
+	return nil
+}
+
+// MkdirAll creates the named directory along with any missing parents.
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := memPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	var dirs []string
+	for d := p; d != "."; d = path.Dir(d) {
+		dirs = append(dirs, d)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		node, ok := m.nodes[dirs[i]]
+		if !ok {
+			m.nodes[dirs[i]] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+			m.notify(dirs[i], Create)
+			continue
+		}
+		if !node.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+		}
+	}
+	return nil
+}
+
+// Watch returns a Watcher notified directly by the changes made to m.
+func (m *MemFS) Watch(opts WatchOptions) (Watcher, error) {
+	b := &memBackend{fs: m}
+	b.w = newWatcher(b, opts.withDefaults())
+
+	m.watchMut.Lock()
+	defer m.watchMut.Unlock()
+	if m.watchers == nil {
+		m.watchers = map[*memBackend]struct{}{}
+	}
+	m.watchers[b] = struct{}{}
+	return b.w, nil
+}
+
+// notify reports a change to every watcher interested in name.
+func (m *MemFS) notify(name string, op Op) {
+	m.watchMut.Lock()
+	defer m.watchMut.Unlock()
+	for b := range m.watchers {
+		if b.set.matches(name, path.Dir(name)) {
+			b.w.push(Event{Name: name, Op: op})
+		}
+	}
+}
+
+type memBackend struct {
+	fs  *MemFS
+	w   *watcher
+	set watchSet
+}
+
+func (b *memBackend) add(name string) error {
+	p, err := memPath("watch", name)
+	if err != nil {
+		return err
+	}
+	b.set.add(p)
+	return nil
+}
+
+func (b *memBackend) remove(name string) error {
+	if !b.set.remove(name) {
+		return &fs.PathError{Op: "unwatch", Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (b *memBackend) close() error {
+	b.fs.watchMut.Lock()
+	defer b.fs.watchMut.Unlock()
+	delete(b.fs.watchers, b)
+	return nil
+}
+
+var (
+	errIsDir       = errors.New("is a directory")
+	errNotDir      = errors.New("not a directory")
+	errDirNotEmpty = errors.New("directory not empty")
+)
+
+// memFileInfo is a snapshot of a memNode, so that it can be used after the
+// lock is released while the node keeps changing.
+type memFileInfo struct {
+	name    string
+	mode    fs.FileMode
+	modTime time.Time
+	size    int64
+}
+
+// info returns a snapshot of n named name, the lock must be held.
+func (n *memNode) info(name string) memFileInfo {
+	return memFileInfo{name: name, mode: n.mode, modTime: n.modTime, size: int64(len(n.data))}
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memFileInfo) Sys() any           { return nil }
+
+// memFile is an open regular file of a MemFS.
+type memFile struct {
+	fs     *MemFS
+	name   string
+	node   *memNode
+	flag   int
+	offset int64
+	closed bool
+}
+
+var (
+	_ io.ReadWriteSeeker = (*memFile)(nil)
+	_ io.ReaderAt        = (*memFile)(nil)
+)
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.fs.mut.RLock()
+	defer f.fs.mut.RUnlock()
+	return f.node.info(path.Base(f.name)), nil
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	n, err := f.readAt("read", b, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.readAt("readat", b, off)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *memFile) readAt(op string, b []byte, off int64) (int, error) {
+	switch {
+	case f.closed:
+		return 0, &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	case f.flag&os.O_WRONLY != 0:
+		return 0, &fs.PathError{Op: op, Path: f.name, Err: fs.ErrPermission}
+	case off < 0:
+		return 0, &fs.PathError{Op: op, Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	f.fs.mut.RLock()
+	defer f.fs.mut.RUnlock()
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	return copy(b, f.node.data[off:]), nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	switch {
+	case f.closed:
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	case f.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+	}
+
+	f.fs.mut.Lock()
+	defer f.fs.mut.Unlock()
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	if end := f.offset + int64(len(b)); end > int64(len(f.node.data)) {
+		data := make([]byte, end)
+		copy(data, f.node.data)
+		f.node.data = data
+	}
+	copy(f.node.data[f.offset:], b)
+	f.offset += int64(len(b))
+	f.node.modTime = time.Now()
+	f.fs.notify(f.name, Write)
+	return len(b), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+
+	f.fs.mut.RLock()
+	size := int64(len(f.node.data))
+	f.fs.mut.RUnlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += size
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+// listedDir is an open directory listing the entries it was created with.
+type listedDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *listedDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *listedDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errIsDir}
+}
+
+func (d *listedDir) Close() error { return nil }
+
+func (d *listedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
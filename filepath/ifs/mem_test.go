@@ -0,0 +1,197 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ifs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeMemFile(t *testing.T, m *MemFS, name, data string) {
+	t.Helper()
+
+	f, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	require.NoError(t, err)
+	_, err = FileWrite(f, []byte(data))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS()
+	require.NoError(t, m.MkdirAll("src/cats/meows", 0o755))
+	writeMemFile(t, m, "src/cats/a.js", "a")
+	writeMemFile(t, m, "src/cats/b.js", "bb")
+	writeMemFile(t, m, "src/cats/meows/c.js", "ccc")
+
+	require.NoError(t, fstest.TestFS(m, "src/cats/a.js", "src/cats/b.js", "src/cats/meows/c.js"))
+
+	info, err := m.Stat("src/cats/b.js")
+	require.NoError(t, err)
+	assert.Equal(t, "b.js", info.Name())
+	assert.Equal(t, int64(2), info.Size())
+	assert.Equal(t, fs.FileMode(0o644), info.Mode())
+	assert.False(t, info.IsDir())
+
+	entries, err := fs.ReadDir(m, "src/cats")
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"a.js", "b.js", "meows"}, names)
+
+	var walked []string
+	require.NoError(t, fs.WalkDir(m, ".", func(p string, d fs.DirEntry, err error) error {
+		walked = append(walked, p)
+		return err
+	}))
+	assert.Equal(t, []string{".", "src", "src/cats", "src/cats/a.js", "src/cats/b.js", "src/cats/meows", "src/cats/meows/c.js"}, walked)
+
+	b, err := ReadFile(m, "src/cats/meows/c.js")
+	require.NoError(t, err)
+	assert.Equal(t, "ccc", string(b))
+}
+
+func TestMemFSFlags(t *testing.T) {
+	m := NewMemFS()
+
+	_, err := m.OpenFile("missing/a.txt", os.O_WRONLY|os.O_CREATE, 0o644)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = m.Open("a.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = m.Open("../a.txt")
+	assert.ErrorIs(t, err, fs.ErrInvalid)
+	assert.ErrorIs(t, m.MkdirAll("/abs", 0o755), fs.ErrInvalid)
+
+	writeMemFile(t, m, "a.txt", "hello")
+	_, err = m.OpenFile("a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	assert.ErrorIs(t, err, fs.ErrExist)
+
+	f, err := m.OpenFile("a.txt", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = FileWrite(f, []byte(" world"))
+	require.NoError(t, err)
+	_, err = f.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	require.NoError(t, f.Close())
+	assert.ErrorIs(t, f.Close(), fs.ErrClosed)
+
+	b, err := m.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+
+	f, err = m.OpenFile("a.txt", os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = f.(io.Seeker).Seek(6, io.SeekStart)
+	require.NoError(t, err)
+	_, err = FileWrite(f, []byte("there"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	b, err = m.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello there", string(b))
+
+	writeMemFile(t, m, "a.txt", "x")
+	b, err = m.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "x", string(b))
+
+	f, err = m.Open("a.txt")
+	require.NoError(t, err)
+	_, err = FileWrite(f, []byte("y"))
+	assert.Error(t, err)
+
+	require.NoError(t, m.MkdirAll("dir/sub", 0o755))
+	_, err = m.OpenFile("dir", os.O_WRONLY, 0)
+	assert.Error(t, err)
+	assert.Error(t, m.MkdirAll("a.txt/sub", 0o755))
+	assert.Error(t, m.Remove("dir"))
+	require.NoError(t, m.Remove("dir/sub"))
+	require.NoError(t, m.Remove("dir"))
+	require.NoError(t, m.Remove("a.txt"))
+	assert.ErrorIs(t, m.Remove("a.txt"), fs.ErrNotExist)
+}
+
+func TestMemFSConcurrency(t *testing.T) {
+	m := NewMemFS()
+	require.NoError(t, m.MkdirAll("logs", 0o755))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			name := fmt.Sprintf("logs/%d.txt", i)
+			for j := 0; j < 50; j++ {
+				f, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+				if !assert.NoError(t, err) {
+					return
+				}
+				_, _ = FileWrite(f, []byte("x"))
+				_ = f.Close()
+				_, _ = fs.ReadDir(m, "logs")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 8; i++ {
+		info, err := m.Stat(fmt.Sprintf("logs/%d.txt", i))
+		require.NoError(t, err)
+		assert.Equal(t, int64(50), info.Size())
+	}
+}
+
+func TestMemFSFileInfoSnapshot(t *testing.T) {
+	m := NewMemFS()
+	f, err := m.OpenFile("a.txt", os.O_WRONLY|os.O_CREATE, 0o644)
+	require.NoError(t, err)
+	info, err := m.Stat("a.txt")
+	require.NoError(t, err)
+	entries, err := m.ReadDir(".")
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_, _ = FileWrite(f, []byte("x"))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_, _ = info.ModTime(), info.Mode()
+		_, _ = entries[0].Info()
+	}
+	<-done
+
+	assert.Equal(t, int64(0), info.Size())
+	latest, err := m.Stat("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), latest.Size())
+	assert.False(t, latest.ModTime().Before(info.ModTime()))
+}
@@ -19,9 +19,12 @@ package ifs
 import (
 	"errors"
 	"io/fs"
+	"os"
+	"path/filepath"
 	"testing"
 	"testing/fstest"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -50,3 +53,78 @@ func TestOSAccess(t *testing.T) {
 
 	require.True(t, IsOS(fs))
 }
+
+func TestWriteFile(t *testing.T) {
+	m := NewMemFS()
+	require.NoError(t, WriteFile(m, "a.txt", []byte("hello"), 0o600))
+	require.NoError(t, AppendFile(m, "a.txt", []byte(" world"), 0o600))
+	require.NoError(t, AppendFile(m, "b.txt", []byte("new"), 0o600))
+
+	b, err := ReadFile(m, "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+	b, err = ReadFile(m, "b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(b))
+
+	_, err = os.Stat("a.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist, "write must not escape to the OS")
+
+	ro := fstest.MapFS{}
+	assert.ErrorIs(t, WriteFile(ro, "a.txt", nil, 0o600), ErrReadOnly)
+	assert.ErrorIs(t, AppendFile(ro, "a.txt", nil, 0o600), ErrReadOnly)
+	assert.ErrorIs(t, Rename(ro, "a.txt", "b.txt"), ErrReadOnly)
+	assert.ErrorIs(t, WriteFileAtomic(ro, "a.txt", nil, 0o600), ErrReadOnly)
+	assert.ErrorIs(t, WriteFileAtomic(testFS{}, "a.txt", nil, 0o600), ErrReadOnly)
+}
+
+func TestRename(t *testing.T) {
+	m := NewMemFS()
+	require.NoError(t, m.MkdirAll("a/b", 0o755))
+	require.NoError(t, WriteFile(m, "a/b/c.txt", []byte("c"), 0o600))
+	require.NoError(t, WriteFile(m, "d.txt", []byte("d"), 0o600))
+
+	require.NoError(t, Rename(m, "a", "z"))
+	b, err := ReadFile(m, "z/b/c.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "c", string(b))
+	_, err = m.Stat("a/b")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.NoError(t, Rename(m, "d.txt", "z/b/c.txt"))
+	b, err = ReadFile(m, "z/b/c.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "d", string(b))
+
+	assert.ErrorIs(t, Rename(m, "missing", "x"), fs.ErrNotExist)
+	assert.ErrorIs(t, Rename(m, "z", "z/b/y"), fs.ErrInvalid)
+	assert.ErrorIs(t, Rename(m, "z/b/c.txt", "nope/c.txt"), fs.ErrNotExist)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	for name, f := range map[string]FS{"mem": NewMemFS(), "os": OS()} {
+		t.Run(name, func(t *testing.T) {
+			dir := "spool"
+			if IsOS(f) {
+				dir = t.TempDir()
+			} else {
+				require.NoError(t, f.MkdirAll(dir, 0o755))
+			}
+			p := filepath.ToSlash(filepath.Join(dir, "checkpoint"))
+
+			require.NoError(t, WriteFileAtomic(f, p, []byte("first"), 0o600))
+			require.NoError(t, WriteFileAtomic(f, p, []byte("second"), 0o600))
+
+			b, err := ReadFile(f, p)
+			require.NoError(t, err)
+			assert.Equal(t, "second", string(b))
+
+			entries, err := fs.ReadDir(f, dir)
+			require.NoError(t, err)
+			require.Len(t, entries, 1, "temporary files must not be left behind")
+			assert.Equal(t, "checkpoint", entries[0].Name())
+
+			assert.Error(t, WriteFileAtomic(f, filepath.ToSlash(filepath.Join(dir, "missing", "checkpoint")), nil, 0o600))
+		})
+	}
+}
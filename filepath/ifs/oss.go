@@ -18,8 +18,10 @@ package ifs
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
+	"math/rand"
 	"os"
 )
 
@@ -35,6 +37,10 @@ type FS interface {
 	MkdirAll(path string, perm fs.FileMode) error
 }
 
+// ErrReadOnly is returned when writing to an fs.FS that does not implement
+// FS, or to an FS that does not support the requested operation.
+var ErrReadOnly = errors.New("file system is read-only")
+
 // ReadFile opens a file with the RDONLY flag and returns all bytes from it.
 func ReadFile(f fs.FS, name string) ([]byte, error) {
 	var i fs.File
@@ -47,23 +53,96 @@ func ReadFile(f fs.FS, name string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer i.Close()
 	return io.ReadAll(i)
 }
 
 // WriteFile opens a file with O_WRONLY|O_CREATE|O_TRUNC flags and writes the
-// data to it.
+// data to it. An error wrapping ErrReadOnly is returned if f does not
+// implement FS.
 func WriteFile(f fs.FS, name string, data []byte, perm fs.FileMode) error {
-	h, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	return writeFile(f, name, data, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+// AppendFile opens a file with O_WRONLY|O_CREATE|O_APPEND flags and appends
+// the data to it. An error wrapping ErrReadOnly is returned if f does not
+// implement FS.
+func AppendFile(f fs.FS, name string, data []byte, perm fs.FileMode) error {
+	return writeFile(f, name, data, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+}
+
+func writeFile(f fs.FS, name string, data []byte, flag int, perm fs.FileMode) error {
+	ef, ok := f.(FS)
+	if !ok {
+		return &fs.PathError{Op: "open", Path: name, Err: ErrReadOnly}
+	}
+	h, err := ef.OpenFile(name, flag, perm)
 	if err != nil {
 		return err
 	}
-	_, err = h.Write(data)
+	_, err = FileWrite(h, data)
 	if err1 := h.Close(); err1 != nil && err == nil {
 		err = err1
 	}
 	return err
 }
 
+// RenameFS is an FS that can rename files.
+type RenameFS interface {
+	FS
+	Rename(oldpath, newpath string) error
+}
+
+// Rename renames oldpath to newpath, replacing newpath if it is an existing
+// file. An error wrapping ErrReadOnly is returned if f does not implement
+// RenameFS.
+func Rename(f fs.FS, oldpath, newpath string) error {
+	rf, ok := f.(RenameFS)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: ErrReadOnly}
+	}
+	return rf.Rename(oldpath, newpath)
+}
+
+// WriteFileAtomic writes data to a temporary file next to name and renames it
+// over name once fully written and synced, so that readers observe either the
+// previous or the new contents but never a partial write. f must implement
+// RenameFS.
+func WriteFileAtomic(f fs.FS, name string, data []byte, perm fs.FileMode) error {
+	rf, ok := f.(RenameFS)
+	if !ok {
+		return &fs.PathError{Op: "open", Path: name, Err: ErrReadOnly}
+	}
+
+	var tmp string
+	var h fs.File
+	var err error
+	for i := 0; i < 10; i++ {
+		tmp = fmt.Sprintf("%s.tmp-%d", name, rand.Uint32())
+		if h, err = rf.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm); !errors.Is(err, fs.ErrExist) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = FileWrite(h, data)
+	if s, ok := h.(interface{ Sync() error }); ok && err == nil {
+		err = s.Sync()
+	}
+	if err1 := h.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = rf.Rename(tmp, name)
+	}
+	if err != nil {
+		_ = rf.Remove(tmp)
+	}
+	return err
+}
+
 // FileWrite attempts to write to an fs.File provided it supports io.Writer.
 func FileWrite(file fs.File, data []byte) (int, error) {
 	writer, isw := file.(io.Writer)
@@ -109,3 +188,7 @@ func (o *osPT) Remove(name string) error {
 func (o *osPT) MkdirAll(path string, perm fs.FileMode) error {
 	return os.MkdirAll(path, perm)
 }
+
+func (o *osPT) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ifs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+)
+
+// Overlay returns an FS layering upper over a read-only lower FS. Files are
+// read from upper when they exist there and from lower otherwise, and
+// directory listings merge both layers. Writes always go to upper, files only
+// present in lower are copied up before being modified in place.
+//
+// A typical use overrides files embedded in the binary with an OS directory:
+//
+//	ifs.Overlay(ifs.Chroot(ifs.OS(), "/etc/nsqcc"), embedded)
+func Overlay(upper FS, lower fs.FS) RenameFS {
+	return &overlayFS{upper: upper, lower: lower}
+}
+
+type overlayFS struct {
+	upper FS
+	lower fs.FS
+}
+
+var _ fs.ReadDirFS = (*overlayFS)(nil)
+
+func (o *overlayFS) inLower(name string) bool {
+	_, err := fs.Stat(o.lower, name)
+	return err == nil
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil || !info.IsDir() {
+		return f, err
+	}
+	if lowerInfo, lerr := fs.Stat(o.lower, name); lerr != nil || !lowerInfo.IsDir() {
+		return f, nil
+	}
+	_ = f.Close()
+
+	entries, err := o.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &listedDir{info: info, entries: entries}, nil
+}
+
+func (o *overlayFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return o.Open(name)
+	}
+	if _, err := o.upper.Stat(name); errors.Is(err, fs.ErrNotExist) && o.inLower(name) {
+		if err := o.copyUp(name, flag&os.O_TRUNC == 0); err != nil {
+			return nil, err
+		}
+	} else if errors.Is(err, fs.ErrNotExist) && o.inLower(path.Dir(name)) {
+		if err := o.upper.MkdirAll(path.Dir(name), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return o.upper.OpenFile(name, flag, perm)
+}
+
+// copyUp creates name in upper with the mode of the lower file, along with
+// its parent directories, copying its contents when withData is set.
+func (o *overlayFS) copyUp(name string, withData bool) error {
+	info, err := fs.Stat(o.lower, name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return o.upper.MkdirAll(name, info.Mode().Perm())
+	}
+	if err := o.upper.MkdirAll(path.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	dst, err := o.upper.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if withData {
+		var src fs.File
+		if src, err = o.lower.Open(name); err == nil {
+			w, ok := dst.(io.Writer)
+			if !ok {
+				err = errors.New("failed to open a writable file")
+			} else {
+				_, err = io.Copy(w, src)
+			}
+			_ = src.Close()
+		}
+	}
+	if err1 := dst.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	return err
+}
+
+func (o *overlayFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := o.upper.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fs.Stat(o.lower, name)
+	}
+	return info, err
+}
+
+func (o *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	merged := map[string]fs.DirEntry{}
+	lowerEntries, lerr := fs.ReadDir(o.lower, name)
+	for _, e := range lowerEntries {
+		merged[e.Name()] = e
+	}
+	upperEntries, uerr := fs.ReadDir(o.upper, name)
+	for _, e := range upperEntries {
+		merged[e.Name()] = e
+	}
+	if lerr != nil && uerr != nil {
+		return nil, uerr
+	}
+
+	entries := make([]fs.DirEntry, 0, len(merged))
+	for _, e := range merged {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// Remove removes name from upper. Files present in lower cannot be removed
+// and return an error wrapping ErrReadOnly.
+func (o *overlayFS) Remove(name string) error {
+	if o.inLower(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+	}
+	return o.upper.Remove(name)
+}
+
+func (o *overlayFS) MkdirAll(name string, perm fs.FileMode) error {
+	return o.upper.MkdirAll(name, perm)
+}
+
+// Rename renames oldpath to newpath within upper. Files present in lower
+// cannot be renamed and return an error wrapping ErrReadOnly.
+func (o *overlayFS) Rename(oldpath, newpath string) error {
+	if o.inLower(oldpath) {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: ErrReadOnly}
+	}
+	if _, err := o.upper.Stat(path.Dir(newpath)); errors.Is(err, fs.ErrNotExist) && o.inLower(path.Dir(newpath)) {
+		if err := o.upper.MkdirAll(path.Dir(newpath), 0o755); err != nil {
+			return err
+		}
+	}
+	return Rename(o.upper, oldpath, newpath)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ifs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// embedded stands in for an embed.FS, which is just as read-only.
+var embedded = fstest.MapFS{
+	"certs/ca.pem":       {Data: []byte("embedded ca")},
+	"certs/bundle.pem":   {Data: []byte("embedded bundle")},
+	"config/nsqcc.yaml":  {Data: []byte("topic: default")},
+	"config/extra/a.txt": {Data: []byte("a")},
+}
+
+func TestReadOnly(t *testing.T) {
+	ro := ReadOnly(embedded)
+	require.NoError(t, fstest.TestFS(ro, "certs/ca.pem", "config/extra/a.txt"))
+
+	b, err := ReadFile(ro, "certs/ca.pem")
+	require.NoError(t, err)
+	assert.Equal(t, "embedded ca", string(b))
+
+	info, err := ro.Stat("certs")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	assert.ErrorIs(t, WriteFile(ro, "certs/ca.pem", nil, 0o600), ErrReadOnly)
+	_, err = ro.OpenFile("certs/ca.pem", os.O_RDWR, 0)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.ErrorIs(t, ro.Remove("certs/ca.pem"), ErrReadOnly)
+	assert.ErrorIs(t, ro.MkdirAll("more", 0o755), ErrReadOnly)
+	assert.Same(t, ro, ReadOnly(ro))
+}
+
+func TestOverlay(t *testing.T) {
+	upper := NewMemFS()
+	require.NoError(t, upper.MkdirAll("certs", 0o755))
+	require.NoError(t, WriteFile(upper, "certs/ca.pem", []byte("override ca"), 0o600))
+	require.NoError(t, WriteFile(upper, "certs/client.pem", []byte("client"), 0o600))
+
+	o := Overlay(upper, embedded)
+	require.NoError(t, fstest.TestFS(o, "certs/ca.pem", "certs/bundle.pem", "certs/client.pem", "config/nsqcc.yaml"))
+
+	for name, want := range map[string]string{
+		"certs/ca.pem":      "override ca",
+		"certs/bundle.pem":  "embedded bundle",
+		"certs/client.pem":  "client",
+		"config/nsqcc.yaml": "topic: default",
+	} {
+		b, err := ReadFile(o, name)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, string(b), name)
+	}
+
+	entries, err := fs.ReadDir(o, "certs")
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"bundle.pem", "ca.pem", "client.pem"}, names)
+
+	// Appending to an embedded file copies it up first.
+	require.NoError(t, AppendFile(o, "config/nsqcc.yaml", []byte("\nchannel: c"), 0o600))
+	b, err := upper.ReadFile("config/nsqcc.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "topic: default\nchannel: c", string(b))
+
+	// New files in embedded directories get their parent created in upper.
+	require.NoError(t, WriteFile(o, "config/extra/b.txt", []byte("b"), 0o600))
+	b, err = upper.ReadFile("config/extra/b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(b))
+
+	assert.ErrorIs(t, o.Remove("certs/bundle.pem"), ErrReadOnly)
+	require.NoError(t, o.Remove("certs/client.pem"))
+	assert.ErrorIs(t, Rename(o, "certs/bundle.pem", "certs/x.pem"), ErrReadOnly)
+	require.NoError(t, WriteFileAtomic(o, "certs/bundle.pem", []byte("new bundle"), 0o600))
+	b, err = ReadFile(o, "certs/bundle.pem")
+	require.NoError(t, err)
+	assert.Equal(t, "new bundle", string(b))
+}
+
+func TestOverlayOSDirectory(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "certs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "certs", "ca.pem"), []byte("os ca"), 0o600))
+
+	o := Overlay(Chroot(OS(), dir), embedded)
+	b, err := ReadFile(o, "certs/ca.pem")
+	require.NoError(t, err)
+	assert.Equal(t, "os ca", string(b))
+	b, err = ReadFile(o, "config/nsqcc.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "topic: default", string(b))
+
+	require.NoError(t, WriteFile(o, "config/local.yaml", []byte("local"), 0o600))
+	b, err = os.ReadFile(filepath.Join(dir, "config", "local.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "local", string(b))
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ifs
+
+import (
+	"io/fs"
+	"os"
+)
+
+// ReadOnly adapts any fs.FS, such as an embed.FS, into an FS. Calls that
+// would modify it return an error wrapping ErrReadOnly.
+func ReadOnly(fsys fs.FS) FS {
+	if ro, ok := fsys.(*readOnlyFS); ok {
+		return ro
+	}
+	return &readOnlyFS{fsys: fsys}
+}
+
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+var (
+	_ fs.ReadDirFS  = (*readOnlyFS)(nil)
+	_ fs.ReadFileFS = (*readOnlyFS)(nil)
+)
+
+func (r *readOnlyFS) Open(name string) (fs.File, error) {
+	return r.fsys.Open(name)
+}
+
+func (r *readOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrReadOnly}
+	}
+	return r.fsys.Open(name)
+}
+
+func (r *readOnlyFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(r.fsys, name)
+}
+
+func (r *readOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(r.fsys, name)
+}
+
+func (r *readOnlyFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(r.fsys, name)
+}
+
+func (r *readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+}
+
+func (r *readOnlyFS) MkdirAll(path string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: path, Err: ErrReadOnly}
+}
@@ -0,0 +1,438 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ifs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Op describes the kind of change reported by a Watcher. Debounced events
+// may combine several operations.
+type Op uint32
+
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+)
+
+func (o Op) String() string {
+	var ops []string
+	if o&Create != 0 {
+		ops = append(ops, "CREATE")
+	}
+	if o&Write != 0 {
+		ops = append(ops, "WRITE")
+	}
+	if o&Remove != 0 {
+		ops = append(ops, "REMOVE")
+	}
+	return strings.Join(ops, "|")
+}
+
+// Event is a change to a watched file.
+type Event struct {
+	Name string
+	Op   Op
+}
+
+// Has reports whether the event includes op.
+func (e Event) Has(op Op) bool {
+	return e.Op&op != 0
+}
+
+// Watcher reports changes to files. Watching a directory reports changes to
+// the directory and its direct children.
+type Watcher interface {
+	// Add starts watching name, which does not need to exist yet.
+	Add(name string) error
+	// Remove stops watching name.
+	Remove(name string) error
+	// Events returns the channel of changes, closed by Close.
+	Events() <-chan Event
+	// Errors returns the channel of watch errors, closed by Close. Errors
+	// are dropped when they are not received in time.
+	Errors() <-chan error
+	// Close stops watching every file.
+	Close() error
+}
+
+// WatchOptions configure a Watcher.
+type WatchOptions struct {
+	// Debounce coalesces the changes to a file occurring within the given
+	// quiet period into a single event. Defaults to 100ms, a negative value
+	// disables debouncing.
+	Debounce time.Duration
+	// PollInterval is how often files are checked by the polling fallback.
+	// Defaults to 1s.
+	PollInterval time.Duration
+}
+
+func (o WatchOptions) withDefaults() WatchOptions {
+	if o.Debounce == 0 {
+		o.Debounce = 100 * time.Millisecond
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	return o
+}
+
+// WatchFS is an FS able to notify about changes to its own files.
+type WatchFS interface {
+	FS
+	Watch(opts WatchOptions) (Watcher, error)
+}
+
+// NewWatcher returns a Watcher for files of f. It uses f itself when it
+// implements WatchFS, inotify and its equivalents for OS(), and falls back to
+// polling every other fs.FS.
+func NewWatcher(f fs.FS, opts WatchOptions) (Watcher, error) {
+	opts = opts.withDefaults()
+	if wf, ok := f.(WatchFS); ok {
+		return wf.Watch(opts)
+	}
+	if ef, ok := f.(FS); ok && IsOS(ef) {
+		return newOSWatcher(opts)
+	}
+	return newPollWatcher(f, opts), nil
+}
+
+// ErrWatcherClosed is returned when adding files to a closed Watcher.
+var ErrWatcherClosed = errors.New("watcher closed")
+
+// watchBackend produces raw events for a watcher.
+type watchBackend interface {
+	add(name string) error
+	remove(name string) error
+	close() error
+}
+
+// watcher implements the debouncing and channel handling shared by every
+// Watcher, backends push raw events and errors to it.
+type watcher struct {
+	backend  watchBackend
+	debounce time.Duration
+
+	mut     sync.Mutex
+	pending []Event
+	closed  bool
+	signal  chan struct{}
+
+	events chan Event
+	errors chan error
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newWatcher(backend watchBackend, opts WatchOptions) *watcher {
+	w := &watcher{
+		backend:  backend,
+		debounce: opts.Debounce,
+		signal:   make(chan struct{}, 1),
+		events:   make(chan Event, 16),
+		errors:   make(chan error, 16),
+		done:     make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+func (w *watcher) Add(name string) error {
+	if w.isClosed() {
+		return ErrWatcherClosed
+	}
+	return w.backend.add(name)
+}
+
+func (w *watcher) Remove(name string) error {
+	if w.isClosed() {
+		return ErrWatcherClosed
+	}
+	return w.backend.remove(name)
+}
+
+func (w *watcher) Events() <-chan Event { return w.events }
+func (w *watcher) Errors() <-chan error { return w.errors }
+
+func (w *watcher) isClosed() bool {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.closed
+}
+
+func (w *watcher) Close() error {
+	w.mut.Lock()
+	if w.closed {
+		w.mut.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mut.Unlock()
+
+	err := w.backend.close()
+	close(w.done)
+	w.wg.Wait()
+	close(w.events)
+	close(w.errors)
+	return err
+}
+
+// push queues a raw event without blocking.
+func (w *watcher) push(e Event) {
+	w.mut.Lock()
+	if w.closed {
+		w.mut.Unlock()
+		return
+	}
+	w.pending = append(w.pending, e)
+	w.mut.Unlock()
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+// pushErr reports an error, dropping it if the receiver is not keeping up.
+func (w *watcher) pushErr(err error) {
+	select {
+	case w.errors <- err:
+	case <-w.done:
+	default:
+	}
+}
+
+func (w *watcher) loop() {
+	defer w.wg.Done()
+
+	coalesced := map[string]Op{}
+	var order []string
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	flush := func() bool {
+		for _, name := range order {
+			select {
+			case w.events <- Event{Name: name, Op: coalesced[name]}:
+			case <-w.done:
+				return false
+			}
+		}
+		coalesced, order = map[string]Op{}, nil
+		return true
+	}
+
+	for {
+		select {
+		case <-w.done:
+			timer.Stop()
+			return
+		case <-w.signal:
+			w.mut.Lock()
+			pending := w.pending
+			w.pending = nil
+			w.mut.Unlock()
+
+			for _, e := range pending {
+				if _, seen := coalesced[e.Name]; !seen {
+					order = append(order, e.Name)
+				}
+				coalesced[e.Name] |= e.Op
+			}
+			if w.debounce < 0 {
+				if !flush() {
+					return
+				}
+				continue
+			}
+			timer.Reset(w.debounce)
+		case <-timer.C:
+			if !flush() {
+				return
+			}
+		}
+	}
+}
+
+// watchSet tracks watched names and matches event paths against them.
+type watchSet struct {
+	mut   sync.RWMutex
+	names map[string]struct{}
+}
+
+func (s *watchSet) add(name string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.names == nil {
+		s.names = map[string]struct{}{}
+	}
+	s.names[name] = struct{}{}
+}
+
+func (s *watchSet) remove(name string) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	_, ok := s.names[name]
+	delete(s.names, name)
+	return ok
+}
+
+// matches reports whether name, or the directory containing it, is watched.
+func (s *watchSet) matches(name, dir string) bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	if _, ok := s.names[name]; ok {
+		return true
+	}
+	_, ok := s.names[dir]
+	return ok
+}
+
+func (s *watchSet) list() []string {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	names := make([]string, 0, len(s.names))
+	for name := range s.names {
+		names = append(names, name)
+	}
+	return names
+}
+
+// pollBackend detects changes by periodically comparing the stat results of
+// watched files, and of the direct children of watched directories.
+type pollBackend struct {
+	w     *watcher
+	f     fs.FS
+	set   watchSet
+	mut   sync.Mutex
+	state map[string]fileStamp
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	mode    fs.FileMode
+}
+
+func newPollWatcher(f fs.FS, opts WatchOptions) Watcher {
+	b := &pollBackend{f: f, state: map[string]fileStamp{}, stop: make(chan struct{})}
+	b.w = newWatcher(b, opts)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(opts.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.poll()
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+	return b.w
+}
+
+// snapshot stats name and, when it is a directory, its children.
+func (b *pollBackend) snapshot(name string, into map[string]fileStamp) {
+	info, err := fs.Stat(b.f, name)
+	if err != nil {
+		return
+	}
+	into[name] = fileStamp{modTime: info.ModTime(), size: info.Size(), mode: info.Mode()}
+	if !info.IsDir() {
+		return
+	}
+	entries, err := fs.ReadDir(b.f, name)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		child := path.Join(name, e.Name())
+		if info, err := e.Info(); err == nil {
+			into[child] = fileStamp{modTime: info.ModTime(), size: info.Size(), mode: info.Mode()}
+		}
+	}
+}
+
+func (b *pollBackend) poll() {
+	current := map[string]fileStamp{}
+	for _, name := range b.set.list() {
+		b.snapshot(name, current)
+	}
+
+	b.mut.Lock()
+	previous := b.state
+	b.state = current
+	b.mut.Unlock()
+
+	for name, stamp := range current {
+		prev, existed := previous[name]
+		switch {
+		case !existed:
+			b.w.push(Event{Name: name, Op: Create})
+		case prev != stamp:
+			b.w.push(Event{Name: name, Op: Write})
+		}
+	}
+	for name := range previous {
+		if _, exists := current[name]; !exists {
+			b.w.push(Event{Name: name, Op: Remove})
+		}
+	}
+}
+
+func (b *pollBackend) add(name string) error {
+	b.set.add(name)
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.snapshot(name, b.state)
+	return nil
+}
+
+func (b *pollBackend) remove(name string) error {
+	if !b.set.remove(name) {
+		return &fs.PathError{Op: "unwatch", Path: name, Err: fs.ErrNotExist}
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for p := range b.state {
+		if p == name || path.Dir(p) == name {
+			delete(b.state, p)
+		}
+	}
+	return nil
+}
+
+func (b *pollBackend) close() error {
+	close(b.stop)
+	b.wg.Wait()
+	return nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ifs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// osBackend watches OS files with fsnotify. Files are watched through their
+// parent directory so that atomic replacements, which swap the inode, keep
+// being reported.
+type osBackend struct {
+	w      *watcher
+	fsw    *fsnotify.Watcher
+	set    watchSet
+	mut    sync.Mutex
+	dirRef map[string]int
+	owned  map[string][]string
+	wg     sync.WaitGroup
+}
+
+func newOSWatcher(opts WatchOptions) (Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	b := &osBackend{fsw: fsw, dirRef: map[string]int{}, owned: map[string][]string{}}
+	b.w = newWatcher(b, opts)
+
+	b.wg.Add(1)
+	go b.loop()
+	return b.w, nil
+}
+
+func (b *osBackend) loop() {
+	defer b.wg.Done()
+	for {
+		select {
+		case e, ok := <-b.fsw.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Clean(e.Name)
+			if !b.set.matches(name, filepath.Dir(name)) {
+				continue
+			}
+			var op Op
+			if e.Has(fsnotify.Create) {
+				op |= Create
+			}
+			if e.Has(fsnotify.Write) {
+				op |= Write
+			}
+			if e.Has(fsnotify.Remove) || e.Has(fsnotify.Rename) {
+				op |= Remove
+			}
+			if op != 0 {
+				b.w.push(Event{Name: name, Op: op})
+			}
+		case err, ok := <-b.fsw.Errors:
+			if !ok {
+				return
+			}
+			b.w.pushErr(err)
+		}
+	}
+}
+
+// dirs returns the directories to watch for name: its parent and, when it is
+// a directory, itself.
+func dirs(name string) []string {
+	d := []string{filepath.Dir(name)}
+	if info, err := os.Stat(name); err == nil && info.IsDir() {
+		d = append(d, name)
+	}
+	return d
+}
+
+func (b *osBackend) add(name string) error {
+	name = filepath.Clean(name)
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if _, ok := b.owned[name]; ok {
+		return nil
+	}
+	var added []string
+	for _, d := range dirs(name) {
+		if b.dirRef[d] == 0 {
+			if err := b.fsw.Add(d); err != nil {
+				for _, a := range added {
+					b.unref(a)
+				}
+				return &fs.PathError{Op: "watch", Path: name, Err: err}
+			}
+		}
+		b.dirRef[d]++
+		added = append(added, d)
+	}
+	b.owned[name] = added
+	b.set.add(name)
+	return nil
+}
+
+func (b *osBackend) unref(d string) {
+	if b.dirRef[d]--; b.dirRef[d] <= 0 {
+		delete(b.dirRef, d)
+		_ = b.fsw.Remove(d)
+	}
+}
+
+func (b *osBackend) remove(name string) error {
+	name = filepath.Clean(name)
+	if !b.set.remove(name) {
+		return &fs.PathError{Op: "unwatch", Path: name, Err: fs.ErrNotExist}
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for _, d := range b.owned[name] {
+		b.unref(d)
+	}
+	delete(b.owned, name)
+	return nil
+}
+
+func (b *osBackend) close() error {
+	err := b.fsw.Close()
+	b.wg.Wait()
+	if errors.Is(err, fsnotify.ErrClosed) {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ifs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextEvent returns the next event for name, skipping events for other files.
+func nextEvent(t *testing.T, w Watcher, name string) Event {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-w.Events():
+			if e.Name == name {
+				return e
+			}
+		case err := <-w.Errors():
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("timed out waiting for an event on %s", name)
+		}
+	}
+}
+
+func noEvent(t *testing.T, w Watcher, wait time.Duration) {
+	t.Helper()
+
+	select {
+	case e := <-w.Events():
+		t.Fatalf("unexpected event %s on %s", e.Op, e.Name)
+	case <-time.After(wait):
+	}
+}
+
+func TestWatchMemFS(t *testing.T) {
+	m := NewMemFS()
+	require.NoError(t, m.MkdirAll("certs", 0o755))
+
+	w, err := NewWatcher(m, WatchOptions{Debounce: 20 * time.Millisecond})
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, w.Add("certs/ca.pem"))
+
+	require.NoError(t, WriteFile(m, "certs/other.pem", []byte("x"), 0o600))
+	require.NoError(t, WriteFile(m, "certs/ca.pem", []byte("a"), 0o600))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, AppendFile(m, "certs/ca.pem", []byte("b"), 0o600))
+	}
+	e := nextEvent(t, w, "certs/ca.pem")
+	assert.Equal(t, Create|Write, e.Op, "writes are debounced into one event")
+	noEvent(t, w, 50*time.Millisecond)
+
+	require.NoError(t, m.Remove("certs/ca.pem"))
+	assert.Equal(t, Remove, nextEvent(t, w, "certs/ca.pem").Op)
+
+	require.NoError(t, w.Add("certs"))
+	require.NoError(t, WriteFile(m, "certs/new.pem", nil, 0o600))
+	assert.True(t, nextEvent(t, w, "certs/new.pem").Has(Create))
+
+	require.NoError(t, w.Close())
+	_, open := <-w.Events()
+	assert.False(t, open)
+	assert.ErrorIs(t, w.Add("certs"), ErrWatcherClosed)
+}
+
+func TestWatchOS(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "ca.pem")
+
+	w, err := NewWatcher(OS(), WatchOptions{Debounce: 20 * time.Millisecond})
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, w.Add(name))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.pem"), []byte("x"), 0o600))
+	require.NoError(t, os.WriteFile(name, []byte("a"), 0o600))
+	assert.True(t, nextEvent(t, w, name).Has(Create))
+
+	require.NoError(t, os.WriteFile(name, []byte("b"), 0o600))
+	assert.True(t, nextEvent(t, w, name).Has(Write))
+
+	// Atomic replacements keep being reported after the inode changes.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, WriteFileAtomic(OS(), name, []byte("c"), 0o600))
+		assert.True(t, nextEvent(t, w, name).Has(Create))
+	}
+
+	require.NoError(t, os.Remove(name))
+	assert.True(t, nextEvent(t, w, name).Has(Remove))
+
+	require.NoError(t, w.Remove(name))
+	assert.Error(t, w.Remove(name))
+}
+
+func TestWatchPolling(t *testing.T) {
+	m := NewMemFS()
+	require.NoError(t, m.MkdirAll("certs", 0o755))
+	require.NoError(t, WriteFile(m, "certs/ca.pem", []byte("a"), 0o600))
+
+	w, err := NewWatcher(ReadOnly(m), WatchOptions{Debounce: -1, PollInterval: 5 * time.Millisecond})
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, w.Add("certs"))
+
+	require.NoError(t, WriteFile(m, "certs/ca.pem", []byte("bb"), 0o600))
+	assert.Equal(t, Write, nextEvent(t, w, "certs/ca.pem").Op)
+
+	require.NoError(t, WriteFile(m, "certs/new.pem", nil, 0o600))
+	assert.Equal(t, Create, nextEvent(t, w, "certs/new.pem").Op)
+
+	require.NoError(t, m.Remove("certs/new.pem"))
+	assert.Equal(t, Remove, nextEvent(t, w, "certs/new.pem").Op)
+}
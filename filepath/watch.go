@@ -0,0 +1,287 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filepath
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+)
+
+// WatchGlobs returns an ifs.Watcher reporting changes to the files of f that
+// match patterns, resolved with Globs. Patterns are resolved again after
+// every change, so files created later that match are reported as well.
+// Add and Remove of the returned Watcher take patterns.
+func WatchGlobs(f fs.FS, patterns []string, opts ifs.WatchOptions) (ifs.Watcher, error) {
+	inner, err := ifs.NewWatcher(f, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &globWatcher{
+		f:       f,
+		inner:   inner,
+		dirs:    map[string]struct{}{},
+		matched: map[string]struct{}{},
+		events:  make(chan ifs.Event, 16),
+		errors:  make(chan error, 16),
+		done:    make(chan struct{}),
+	}
+	for _, p := range patterns {
+		if err := g.Add(p); err != nil {
+			_ = inner.Close()
+			return nil, err
+		}
+	}
+
+	g.wg.Add(1)
+	go g.loop()
+	return g, nil
+}
+
+type globWatcher struct {
+	f     fs.FS
+	inner ifs.Watcher
+
+	mut      sync.Mutex
+	patterns []string
+	dirs     map[string]struct{}
+	matched  map[string]struct{}
+
+	events    chan ifs.Event
+	errors    chan error
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// staticDir returns the longest directory prefix of pattern without magic
+// characters.
+func staticDir(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, s := range segments {
+		if hasMeta(s) || strings.Contains(s, "**") {
+			dir := strings.Join(segments[:i], "/")
+			if dir == "" && strings.HasPrefix(pattern, "/") {
+				return "/"
+			}
+			if dir == "" {
+				return "."
+			}
+			return dir
+		}
+	}
+	return path.Dir(pattern)
+}
+
+// resolve expands the patterns and watches the directories that matching
+// files could appear in. The lock must be held.
+func (g *globWatcher) resolve() (map[string]struct{}, error) {
+	paths, err := Globs(g.f, g.patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := map[string]struct{}{}
+	dirs := map[string]struct{}{}
+	for _, p := range paths {
+		matched[p] = struct{}{}
+		dirs[path.Dir(p)] = struct{}{}
+	}
+	for _, original := range g.patterns {
+		if strings.HasPrefix(original, "!") {
+			continue
+		}
+		for _, pattern := range expandBraces(original) {
+			base := staticDir(pattern)
+			dirs[base] = struct{}{}
+			if !strings.Contains(pattern, "**") {
+				continue
+			}
+			_ = fs.WalkDir(g.f, base, func(p string, d fs.DirEntry, err error) error {
+				if err == nil && d.IsDir() {
+					dirs[p] = struct{}{}
+				}
+				return nil
+			})
+		}
+	}
+
+	// Directories that do not exist yet are watched through their closest
+	// existing ancestor, so that their creation triggers another resolution.
+	watch := map[string]struct{}{}
+	for d := range dirs {
+		watch[existingDir(g.f, d)] = struct{}{}
+	}
+	for d := range g.dirs {
+		if _, ok := watch[d]; !ok {
+			// The watch of a removed directory may be gone already.
+			_ = g.inner.Remove(d)
+			delete(g.dirs, d)
+		}
+	}
+	for d := range watch {
+		if _, ok := g.dirs[d]; ok {
+			continue
+		}
+		if err := g.inner.Add(d); err != nil {
+			return nil, err
+		}
+		g.dirs[d] = struct{}{}
+	}
+	return matched, nil
+}
+
+// existingDir returns dir, or its closest ancestor that exists when it does
+// not.
+func existingDir(f fs.FS, dir string) string {
+	for {
+		if info, err := fs.Stat(f, dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := path.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+func (g *globWatcher) Add(pattern string) error {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	g.patterns = append(g.patterns, pattern)
+	matched, err := g.resolve()
+	if err != nil {
+		g.patterns = g.patterns[:len(g.patterns)-1]
+		return err
+	}
+	g.matched = matched
+	return nil
+}
+
+func (g *globWatcher) Remove(pattern string) error {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	for i, p := range g.patterns {
+		if p == pattern {
+			g.patterns = append(g.patterns[:i], g.patterns[i+1:]...)
+			matched, err := g.resolve()
+			if err != nil {
+				return err
+			}
+			g.matched = matched
+			return nil
+		}
+	}
+	return &fs.PathError{Op: "unwatch", Path: pattern, Err: fs.ErrNotExist}
+}
+
+func (g *globWatcher) Events() <-chan ifs.Event { return g.events }
+func (g *globWatcher) Errors() <-chan error     { return g.errors }
+
+func (g *globWatcher) Close() error {
+	var err error
+	g.closeOnce.Do(func() {
+		close(g.done)
+		err = g.inner.Close()
+		g.wg.Wait()
+	})
+	return err
+}
+
+func (g *globWatcher) loop() {
+	defer g.wg.Done()
+	defer close(g.events)
+	defer close(g.errors)
+
+	errs := g.inner.Errors()
+	for {
+		select {
+		case e, ok := <-g.inner.Events():
+			if !ok {
+				return
+			}
+			for _, out := range g.handle(e) {
+				select {
+				case g.events <- out:
+				case <-g.done:
+					return
+				}
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			select {
+			case g.errors <- err:
+			default:
+			}
+		}
+	}
+}
+
+// handle re-resolves the patterns after e and returns the events to report:
+// e itself when it concerns a matching file, and creations or removals of
+// matching files it implied, such as files inside a newly created directory.
+func (g *globWatcher) handle(e ifs.Event) []ifs.Event {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if _, ok := g.dirs[e.Name]; ok && e.Has(ifs.Create|ifs.Remove) {
+		// A watched directory that is removed or replaced loses its watch,
+		// resolve adds it again when it exists.
+		_ = g.inner.Remove(e.Name)
+		delete(g.dirs, e.Name)
+	}
+	matched, err := g.resolve()
+	if err != nil {
+		select {
+		case g.errors <- err:
+		default:
+		}
+		return nil
+	}
+	previous := g.matched
+	g.matched = matched
+
+	var out []ifs.Event
+	_, wasMatched := previous[e.Name]
+	_, isMatched := matched[e.Name]
+	if wasMatched || isMatched {
+		out = append(out, e)
+	}
+	for _, p := range sortedKeys(matched) {
+		if _, ok := previous[p]; !ok && p != e.Name {
+			if _, err := fs.Stat(g.f, p); err == nil {
+				out = append(out, ifs.Event{Name: p, Op: ifs.Create})
+			}
+		}
+	}
+	for _, p := range sortedKeys(previous) {
+		if _, ok := matched[p]; !ok && p != e.Name {
+			out = append(out, ifs.Event{Name: p, Op: ifs.Remove})
+		}
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filepath
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextEvent(t *testing.T, w ifs.Watcher) ifs.Event {
+	t.Helper()
+
+	select {
+	case e := <-w.Events():
+		return e
+	case err := <-w.Errors():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return ifs.Event{}
+}
+
+func TestWatchGlobsMemFS(t *testing.T) {
+	mfs := ifs.NewMemFS()
+	require.NoError(t, mfs.MkdirAll("src/cats", 0o755))
+	require.NoError(t, ifs.WriteFile(mfs, "src/cats/a.js", nil, 0o600))
+
+	w, err := WatchGlobs(mfs, []string{"src/**/*.js"}, ifs.WatchOptions{Debounce: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, ifs.WriteFile(mfs, "src/cats/b.txt", nil, 0o600))
+	require.NoError(t, ifs.WriteFile(mfs, "src/cats/a.js", []byte("a"), 0o600))
+	assert.Equal(t, ifs.Event{Name: "src/cats/a.js", Op: ifs.Write}, nextEvent(t, w))
+
+	// Files in directories created after the watch started are picked up.
+	require.NoError(t, mfs.MkdirAll("src/dogs", 0o755))
+	require.NoError(t, ifs.WriteFile(mfs, "src/dogs/c.js", nil, 0o600))
+	e := nextEvent(t, w)
+	assert.Equal(t, "src/dogs/c.js", e.Name)
+	assert.True(t, e.Has(ifs.Create))
+
+	require.NoError(t, mfs.Remove("src/cats/a.js"))
+	assert.Equal(t, ifs.Event{Name: "src/cats/a.js", Op: ifs.Remove}, nextEvent(t, w))
+
+	require.NoError(t, w.Close())
+	_, open := <-w.Events()
+	assert.False(t, open)
+}
+
+func TestWatchGlobsOS(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := WatchGlobs(ifs.OS(), []string{dir + "/*.pem"}, ifs.WatchOptions{Debounce: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ignored.txt"), nil, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ca.pem"), nil, 0o600))
+	e := nextEvent(t, w)
+	assert.Equal(t, filepath.Join(dir, "ca.pem"), e.Name)
+	assert.True(t, e.Has(ifs.Create))
+}
+
+// waitEvent returns the first event about name, skipping the others.
+func waitEvent(t *testing.T, w ifs.Watcher, name string) ifs.Event {
+	t.Helper()
+
+	for {
+		if e := nextEvent(t, w); e.Name == name {
+			return e
+		}
+	}
+}
+
+func TestWatchGlobsRecreatedDir(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "conf.d")
+
+	w, err := WatchGlobs(ifs.OS(), []string{conf + "/*.yaml"}, ifs.WatchOptions{Debounce: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer w.Close()
+
+	// A directory moved into place reports the files it holds, in order.
+	staging := filepath.Join(dir, "staging")
+	require.NoError(t, os.Mkdir(staging, 0o755))
+	for _, name := range []string{"c.yaml", "a.yaml", "b.yaml"} {
+		require.NoError(t, os.WriteFile(filepath.Join(staging, name), nil, 0o600))
+	}
+	require.NoError(t, os.Rename(staging, conf))
+	for _, name := range []string{"a.yaml", "b.yaml", "c.yaml"} {
+		assert.Equal(t, ifs.Event{Name: filepath.Join(conf, name), Op: ifs.Create}, nextEvent(t, w))
+	}
+
+	require.NoError(t, os.RemoveAll(conf))
+	assert.True(t, waitEvent(t, w, filepath.Join(conf, "c.yaml")).Has(ifs.Remove))
+	require.NoError(t, os.Mkdir(conf, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(conf, "d.yaml"), nil, 0o600))
+	assert.True(t, waitEvent(t, w, filepath.Join(conf, "d.yaml")).Has(ifs.Create))
+}
@@ -17,66 +17,234 @@ limitations under the License.
 package in
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/asaskevich/govalidator"
-	"log"
+	"time"
 
+	"github.com/deepauto-io/nsqcc"
+	"github.com/deepauto-io/nsqcc/auth"
+	"github.com/deepauto-io/nsqcc/config"
+	"github.com/deepauto-io/nsqcc/encrypt"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
 	ntls "github.com/deepauto-io/nsqcc/tls"
-	"github.com/kelseyhightower/envconfig"
+	"github.com/nsqio/go-nsq"
 )
 
+// Policies for messages whose payload cannot be decoded by the reader.
+const (
+	DecodeFailureRequeue = "requeue"
+	DecodeFailureDrop    = "drop"
+)
+
+// NSQ topic and channel names must match NamePattern and be at most
+// MaxNameLength characters long.
+const (
+	NamePattern   = `^[.a-zA-Z0-9_-]+(#ephemeral)?$`
+	MaxNameLength = 64
+)
+
+var nameRule = fmt.Sprintf("must match %s and be at most %d characters long", NamePattern, MaxNameLength)
+
 // Config is the configuration for the reader.
 type Config struct {
-	Addresses       []string `json:"addresses" envconfig:"NSQ_ADDRESSES"                   default:"127.0.0.1:4150"`         // Nsqd 地址列表
-	LookupAddresses []string `json:"lookupAddresses" envconfig:"NSQ_LOOKUP_ADDRESSES"            default:"127.0.0.1:4161"`   // NSQLookupd 地址列表
-	Topic           string   `json:"topic" envconfig:"NSQ_TOPIC"`                                                            // 消费的主题名
-	Channel         string   `json:"channel" envconfig:"NSQ_CHANNEL"                     default:"default"`                  // 消费的频道名
-	UserAgent       string   `json:"user_agent" envconfig:"NSQ_USER_AGENT"                  default:"DeepAuto Consumer/1.0"` // 连接时使用的用户UA
-	MaxInFlight     int      `json:"max_in_flight" envconfig:"NSQ_MAX_IN_FLIGHT"               default:"64"`                 // 同时处理的最大消息数量.
-	MaxAttempts     uint16   `json:"max_attempts" envconfig:"NSQ_MAX_ATTEMPTS"                default:"3"`                   // 消息最大重试次数
-	TLS             ntls.Config
+	Addresses       []string `json:"addresses" envconfig:"NSQ_ADDRESSES"                   default:"127.0.0.1:4150" yaml:"addresses"`              // Nsqd 地址列表
+	LookupAddresses []string `json:"lookup_addresses" envconfig:"NSQ_LOOKUP_ADDRESSES"           default:"127.0.0.1:4161" yaml:"lookup_addresses"` // NSQLookupd 地址列表
+	Topic           string   `json:"topic" envconfig:"NSQ_TOPIC" yaml:"topic"`                                                                     // 消费的主题名
+	Channel         string   `json:"channel" envconfig:"NSQ_CHANNEL"                     default:"default" yaml:"channel"`                         // 消费的频道名
+	UserAgent       string   `json:"user_agent" envconfig:"NSQ_USER_AGENT"                  default:"DeepAuto Consumer/1.0" yaml:"user_agent"`     // 连接时使用的用户UA
+	MaxInFlight     int      `json:"max_in_flight" envconfig:"NSQ_MAX_IN_FLIGHT"               default:"64" yaml:"max_in_flight"`                  // 同时处理的最大消息数量.
+	MaxAttempts     uint16   `json:"max_attempts" envconfig:"NSQ_MAX_ATTEMPTS"                default:"5" yaml:"max_attempts"`                     // 消息最大重试次数
+	Snappy          bool     `json:"snappy" envconfig:"NSQ_SNAPPY" yaml:"snappy"`                                                                  // 连接启用 Snappy 压缩
+	Deflate         bool     `json:"deflate" envconfig:"NSQ_DEFLATE" yaml:"deflate"`                                                               // 连接启用 Deflate 压缩
+	DeflateLevel    int      `json:"deflate_level" envconfig:"NSQ_DEFLATE_LEVEL"               default:"6" yaml:"deflate_level"`                   // Deflate 压缩级别 (1-9)
+	DecodeFailure   string   `json:"decode_failure" envconfig:"NSQ_DECODE_FAILURE"              default:"requeue" yaml:"decode_failure"`           // 消息解码失败时的处理方式 (requeue, drop)
+
+	DialTimeout         time.Duration `json:"dial_timeout" envconfig:"NSQ_DIAL_TIMEOUT" default:"1s" yaml:"dial_timeout"`                               // 建立连接超时时间
+	ReadTimeout         time.Duration `json:"read_timeout" envconfig:"NSQ_READ_TIMEOUT" default:"60s" yaml:"read_timeout"`                              // 网络读超时时间 (100ms-5m)
+	WriteTimeout        time.Duration `json:"write_timeout" envconfig:"NSQ_WRITE_TIMEOUT" default:"1s" yaml:"write_timeout"`                            // 网络写超时时间 (100ms-5m)
+	HeartbeatInterval   time.Duration `json:"heartbeat_interval" envconfig:"NSQ_HEARTBEAT_INTERVAL" default:"30s" yaml:"heartbeat_interval"`            // 心跳间隔, 必须小于读超时时间
+	MsgTimeout          time.Duration `json:"msg_timeout" envconfig:"NSQ_MSG_TIMEOUT" yaml:"msg_timeout"`                                               // 服务端消息超时时间, 0 表示使用 nsqd 默认值
+	LookupdPollInterval time.Duration `json:"lookupd_poll_interval" envconfig:"NSQ_LOOKUPD_POLL_INTERVAL" default:"60s" yaml:"lookupd_poll_interval"`   // 轮询 nsqlookupd 的间隔 (10ms-5m)
+	BackoffStrategy     string        `json:"backoff_strategy" envconfig:"NSQ_BACKOFF_STRATEGY" default:"exponential" yaml:"backoff_strategy"`          // 退避策略 (exponential, full_jitter)
+	BackoffMultiplier   time.Duration `json:"backoff_multiplier" envconfig:"NSQ_BACKOFF_MULTIPLIER" default:"1s" yaml:"backoff_multiplier"`             // 退避时间单位 (0-60m)
+	MaxBackoffDuration  time.Duration `json:"max_backoff_duration" envconfig:"NSQ_MAX_BACKOFF_DURATION" default:"2m" yaml:"max_backoff_duration"`       // 最大退避时间, 0 表示不退避 (0-60m)
+	SampleRate          int32         `json:"sample_rate" envconfig:"NSQ_SAMPLE_RATE" yaml:"sample_rate"`                                               // 频道采样百分比 (0-99), 0 表示不采样
+	OutputBufferSize    int64         `json:"output_buffer_size" envconfig:"NSQ_OUTPUT_BUFFER_SIZE" default:"16384" yaml:"output_buffer_size"`          // nsqd 写缓冲区大小 (字节)
+	OutputBufferTimeout time.Duration `json:"output_buffer_timeout" envconfig:"NSQ_OUTPUT_BUFFER_TIMEOUT" default:"250ms" yaml:"output_buffer_timeout"` // nsqd 刷新写缓冲区的超时时间, 负数表示禁用
+	ClientID            string        `json:"client_id" envconfig:"NSQ_CLIENT_ID" yaml:"client_id"`                                                     // 客户端标识, 默认为短主机名
+	Hostname            string        `json:"hostname" envconfig:"NSQ_HOSTNAME" yaml:"hostname"`                                                        // 客户端主机名, 默认为系统主机名
+
+	// The nested blocks share the NSQ prefix of the fields above, as in
+	// NSQ_TLS_ENABLE, so that every variable of a reader starts with NSQ_.
+	// Writers use NSQ_WRITER the same way.
+	TLS        ntls.Config    `json:"tls" yaml:"tls" envprefix:"NSQ"`
+	Encryption encrypt.Config `json:"encryption" yaml:"encryption" envprefix:"NSQ"`
+	Auth       auth.Config    `json:"auth" yaml:"auth" envprefix:"NSQ"`
 }
 
-// NewConfig creates a new Config with default values.
+// NewConfig creates a new Config with the default values of its default tags.
 func NewConfig() Config {
-	return Config{
-		Channel:         "default",
-		Addresses:       []string{"127.0.0.1:4150"},
-		LookupAddresses: []string{"127.0.0.1:4161"},
-		UserAgent:       "DeepAuto NSQ/1.0",
-		MaxInFlight:     64,
-		MaxAttempts:     5,
-		TLS:             ntls.NewConfig(),
-	}
+	return config.Defaults[Config]()
 }
 
-// Validate validates the configuration.
+// Validate validates the configuration, reporting every problem at once.
 func (c Config) Validate() error {
+	var errs []error
 	if len(c.Addresses) == 0 {
-		return fmt.Errorf("nsq address is required")
+		errs = append(errs, errors.New("nsq address is required"))
+	}
+	for _, addr := range c.Addresses {
+		if err := config.CheckHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("nsq addresses: %w", err))
+		}
 	}
 
 	if len(c.LookupAddresses) == 0 {
-		return fmt.Errorf("nsq lookupd addresses is required")
+		errs = append(errs, errors.New("nsq lookupd addresses is required"))
+	}
+	for _, addr := range c.LookupAddresses {
+		if err := config.CheckHTTPAddress(addr); err != nil {
+			errs = append(errs, fmt.Errorf("nsq lookupd addresses: %w", err))
+		}
 	}
 
 	if govalidator.IsNull(c.Topic) {
-		return fmt.Errorf("nsq topic is required")
+		errs = append(errs, errors.New("nsq topic is required"))
+	} else if !nsq.IsValidTopicName(c.Topic) {
+		errs = append(errs, fmt.Errorf("invalid nsq topic %q: %s", c.Topic, nameRule))
 	}
 
 	if govalidator.IsNull(c.Channel) {
-		return fmt.Errorf("nsq channel is required")
+		errs = append(errs, errors.New("nsq channel is required"))
+	} else if !nsq.IsValidChannelName(c.Channel) {
+		errs = append(errs, fmt.Errorf("invalid nsq channel %q: %s", c.Channel, nameRule))
 	}
-	return nil
+
+	if c.MaxInFlight <= 0 {
+		errs = append(errs, errors.New("nsq max in flight must be greater than 0"))
+	}
+
+	if c.Snappy && c.Deflate {
+		errs = append(errs, errors.New("only one field between snappy and deflate can be enabled"))
+	}
+
+	if c.Deflate && (c.DeflateLevel < 1 || c.DeflateLevel > 9) {
+		errs = append(errs, errors.New("nsq deflate level must be between 1 and 9"))
+	}
+
+	switch c.DecodeFailure {
+	case DecodeFailureRequeue, DecodeFailureDrop:
+	default:
+		errs = append(errs, fmt.Errorf("unknown nsq decode failure policy: %s", c.DecodeFailure))
+	}
+
+	if err := c.TLS.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Encryption.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Auth.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := c.nsqConfig(nil); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 }
 
-func MustLoadConfig(cfgPath string) Config {
-	cfg := Config{}
-	if cfgPath == "" {
-		if err := envconfig.Process("", &cfg); err != nil {
-			log.Fatal(err)
+// nsqConfig builds the go-nsq configuration of the reader, see
+// nsqcc.NSQSettings.NSQConfig.
+func (c Config) nsqConfig(tlsConf *tls.Config) (*nsq.Config, error) {
+	return nsqcc.NSQSettings{
+		UserAgent:           c.UserAgent,
+		MaxInFlight:         c.MaxInFlight,
+		Snappy:              c.Snappy,
+		Deflate:             c.Deflate,
+		DeflateLevel:        c.DeflateLevel,
+		MsgTimeout:          c.MsgTimeout,
+		ClientID:            c.ClientID,
+		Hostname:            c.Hostname,
+		DialTimeout:         c.DialTimeout,
+		ReadTimeout:         c.ReadTimeout,
+		WriteTimeout:        c.WriteTimeout,
+		HeartbeatInterval:   c.HeartbeatInterval,
+		OutputBufferTimeout: c.OutputBufferTimeout,
+		OutputBufferSize:    c.OutputBufferSize,
+	}.NSQConfig(tlsConf, func(cfg *nsq.Config) error {
+		cfg.MaxAttempts = c.MaxAttempts
+		cfg.SampleRate = c.SampleRate
+		for _, d := range []struct {
+			dst *time.Duration
+			val time.Duration
+		}{
+			{&cfg.LookupdPollInterval, c.LookupdPollInterval},
+			{&cfg.BackoffMultiplier, c.BackoffMultiplier},
+			{&cfg.MaxBackoffDuration, c.MaxBackoffDuration},
+		} {
+			if d.val != 0 {
+				*d.dst = d.val
+			}
 		}
-		return cfg
+
+		if c.BackoffStrategy == "" {
+			return nil
+		}
+		if c.BackoffStrategy != "exponential" && c.BackoffStrategy != "full_jitter" {
+			return fmt.Errorf("unknown nsq backoff strategy: %s", c.BackoffStrategy)
+		}
+		return cfg.Set("backoff_strategy", c.BackoffStrategy)
+	})
+}
+
+// Describe returns every field of the reader configuration, for generating
+// documentation, see config.Describe.
+func Describe() []config.Field {
+	return config.MustDescribe[Config]()
+}
+
+// Schema returns the JSON Schema of reader configuration files.
+func Schema() *config.Schema {
+	s := config.MustSchemaOf(&Config{})
+	s.Title = "nsqcc reader"
+	for _, key := range []string{"topic", "channel"} {
+		p := s.Property(key)
+		p.Pattern, p.MinLength, p.MaxLength = NamePattern, config.Int(1), config.Int(MaxNameLength)
 	}
-	return cfg
+	s.Property("addresses").Items.Pattern = config.HostPortPattern
+	s.Property("max_in_flight").Minimum = config.Float(1)
+	s.Property("deflate_level").Minimum, s.Property("deflate_level").Maximum = config.Float(1), config.Float(9)
+	s.Property("decode_failure").Enum = []any{DecodeFailureRequeue, DecodeFailureDrop}
+	s.Property("backoff_strategy").Enum = []any{"exponential", "full_jitter"}
+	s.Properties["tls"] = ntls.Schema()
+	s.Properties["tls"].Dialect = ""
+	return s
+}
+
+// LoadConfig loads the configuration from files, see config.LoadFiles.
+func LoadConfig(f ifs.FS, paths ...string) (Config, error) {
+	return config.LoadFiles[Config](f, paths...)
+}
+
+// LoadEnvConfig loads the configuration from environment variables named
+// after prefix, see config.LoadEnv.
+func LoadEnvConfig(prefix string) (Config, error) {
+	return config.LoadEnv[Config](prefix)
+}
+
+// MustLoadConfig loads the configuration from the environment or from the
+// comma separated list of paths in cfgPath, see config.MustLoad.
+func MustLoadConfig(cfgPath string) Config {
+	return config.MustLoad[Config](cfgPath)
+}
+
+// String returns the configuration with its secrets redacted, so that it can
+// be printed or logged.
+func (c Config) String() string {
+	return config.Format(c)
 }
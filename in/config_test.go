@@ -0,0 +1,235 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package in
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/config"
+	"github.com/deepauto-io/nsqcc/encrypt"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	ntls "github.com/deepauto-io/nsqcc/tls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigTuning(t *testing.T) {
+	t.Setenv("NSQ_TOPIC", "orders")
+	t.Setenv("NSQ_DIAL_TIMEOUT", "3s")
+	t.Setenv("NSQ_BACKOFF_STRATEGY", "full_jitter")
+	t.Setenv("NSQ_OUTPUT_BUFFER_TIMEOUT", "-1s")
+
+	conf := MustLoadConfig("")
+	require.NoError(t, conf.Validate())
+
+	cfg, err := conf.nsqConfig(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, cfg.DialTimeout)
+	assert.Equal(t, -time.Millisecond, cfg.OutputBufferTimeout)
+	assert.Equal(t, time.Minute, cfg.ReadTimeout)
+
+	// Zero values keep the go-nsq defaults, which reject a deflate level of 0.
+	cfg, err = Config{}.nsqConfig(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 6, cfg.DeflateLevel)
+
+	tests := map[string]func(c *Config){
+		"heartbeat above read timeout": func(c *Config) { c.HeartbeatInterval = 2 * time.Minute },
+		"read timeout out of range":    func(c *Config) { c.ReadTimeout = time.Hour },
+		"unknown backoff strategy":     func(c *Config) { c.BackoffStrategy = "linear" },
+		"sample rate out of range":     func(c *Config) { c.SampleRate = 100 },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := NewConfig()
+			c.Topic = "orders"
+			mutate(&c)
+			assert.Error(t, c.Validate())
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	m := ifs.NewMemFS()
+	require.NoError(t, m.MkdirAll("conf", 0o755))
+	require.NoError(t, ifs.WriteFile(m, "conf/tls.yaml", []byte("tls:\n  enabled: true\n  server_name: nsqd.internal\n"), 0o644))
+	require.NoError(t, ifs.WriteFile(m, "conf/reader.yaml", []byte("include: tls.yaml\ntopic: orders\nlookup_addresses: [lookupd:4161]\nread_timeout: 45s\n"), 0o644))
+
+	conf, err := LoadConfig(m, "conf/reader.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "orders", conf.Topic)
+	assert.Equal(t, "default", conf.Channel)
+	assert.Equal(t, []string{"lookupd:4161"}, conf.LookupAddresses)
+	assert.Equal(t, 45*time.Second, conf.ReadTimeout)
+	assert.True(t, conf.TLS.Enabled)
+	assert.Equal(t, "nsqd.internal", conf.TLS.ServerName)
+	require.NoError(t, conf.Validate())
+}
+
+// Files holding the JSON encoding of a configuration load back into it.
+func TestLoadConfigJSONRoundTrip(t *testing.T) {
+	conf := NewConfig()
+	conf.Topic = "orders"
+	conf.LookupAddresses = []string{"lookupd:4161"}
+	conf.ReadTimeout = 45 * time.Second
+	conf.TLS.Enabled = true
+	conf.TLS.InsecureSkipVerify = true
+	conf.TLS.RootCAsFile = "ca.pem"
+	conf.TLS.ClientCertificates = []ntls.ClientCertConfig{{CertFile: "cert.pem", KeyFile: "key.pem"}}
+	b, err := json.Marshal(conf)
+	require.NoError(t, err)
+
+	m := ifs.NewMemFS()
+	require.NoError(t, ifs.WriteFile(m, "reader.json", b, 0o644))
+	loaded, err := LoadConfig(m, "reader.json")
+	require.NoError(t, err)
+	assert.Equal(t, conf, loaded)
+}
+
+func TestDescribeJSONKeysLoad(t *testing.T) {
+	keys := map[string]string{}
+	for _, f := range Describe() {
+		keys[f.Name] = f.JSON
+	}
+	assert.Equal(t, "lookup_addresses", keys["LookupAddresses"])
+	assert.Equal(t, "tls.skip_cert_verify", keys["TLS.InsecureSkipVerify"])
+
+	m := ifs.NewMemFS()
+	require.NoError(t, ifs.WriteFile(m, "reader.json", []byte(`{"lookup_addresses": ["lookupd:4161"], "tls": {"skip_cert_verify": true}}`), 0o644))
+	conf, err := LoadConfig(m, "reader.json")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lookupd:4161"}, conf.LookupAddresses)
+	assert.True(t, conf.TLS.InsecureSkipVerify)
+}
+
+func TestConfigStringRedactsSecrets(t *testing.T) {
+	conf := NewConfig()
+	conf.Topic = "orders"
+	conf.Auth.Secret = "auth-secret"
+	conf.TLS.ClientCertificates = []ntls.ClientCertConfig{{Key: "private-key", Password: "key-password"}}
+
+	s := conf.String()
+	assert.Contains(t, s, "orders")
+	assert.Contains(t, s, config.Redacted)
+	for _, secret := range []string{"auth-secret", "private-key", "key-password"} {
+		assert.NotContains(t, s, secret)
+		assert.NotContains(t, fmt.Sprintf("%v", conf), secret)
+	}
+	assert.Equal(t, "auth-secret", conf.Auth.Secret)
+}
+
+func TestLoadEnvConfigPrefixes(t *testing.T) {
+	t.Setenv("ORDERS_NSQ_TOPIC", "orders")
+	t.Setenv("ORDERS_NSQ_TLS_ENABLE", "true")
+	t.Setenv("ORDERS_NSQ_AUTH_SECRET", "orders-secret")
+	t.Setenv("AUDIT_NSQ_TOPIC", "audit")
+	t.Setenv("TLS_ENABLE", "true")
+
+	orders, err := LoadEnvConfig("ORDERS")
+	require.NoError(t, err)
+	assert.Equal(t, "orders", orders.Topic)
+	assert.True(t, orders.TLS.Enabled)
+	assert.Equal(t, "orders-secret", orders.Auth.Secret)
+
+	audit, err := LoadEnvConfig("AUDIT")
+	require.NoError(t, err)
+	assert.Equal(t, "audit", audit.Topic)
+	assert.False(t, audit.TLS.Enabled)
+	assert.Empty(t, audit.Auth.Secret)
+
+	legacy, err := LoadEnvConfig("")
+	require.NoError(t, err)
+	assert.True(t, legacy.TLS.Enabled)
+}
+
+func TestConfigDefaultsAgree(t *testing.T) {
+	defaults := NewConfig()
+	assert.Equal(t, uint16(5), defaults.MaxAttempts)
+	assert.Equal(t, "DeepAuto Consumer/1.0", defaults.UserAgent)
+	assert.Equal(t, DecodeFailureRequeue, defaults.DecodeFailure)
+
+	fromEnv, err := LoadEnvConfig("NSQCC_TEST_UNSET")
+	require.NoError(t, err)
+	assert.Equal(t, defaults, fromEnv)
+
+	m := ifs.NewMemFS()
+	require.NoError(t, ifs.WriteFile(m, "empty.yaml", nil, 0o644))
+	fromFile, err := LoadConfig(m, "empty.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, defaults, fromFile)
+
+	for _, f := range Describe() {
+		if f.Name == "MaxAttempts" {
+			assert.Equal(t, "5", f.Default)
+			assert.Equal(t, "NSQ_MAX_ATTEMPTS", f.Env)
+			assert.Equal(t, "max_attempts", f.YAML)
+		}
+	}
+}
+
+func TestConfigValidateStrict(t *testing.T) {
+	conf := NewConfig()
+	conf.Topic = "orders#ephemeral"
+	conf.Channel = "archive.v2"
+	conf.LookupAddresses = []string{"lookupd:4161", "http://lookupd:4161"}
+	require.NoError(t, conf.Validate())
+
+	conf = NewConfig()
+	conf.Topic = "orders/eu"
+	conf.Channel = strings.Repeat("c", MaxNameLength+1)
+	conf.Addresses = []string{"nsqd"}
+	conf.LookupAddresses = []string{"ftp://lookupd:4161"}
+	conf.MaxInFlight = 0
+	conf.TLS.ClientCertificates = []ntls.ClientCertConfig{{PKCS12File: "a.p12", Key: "inline"}}
+	conf.Encryption = encrypt.Config{Enabled: true, Algorithm: "rot13"}
+
+	err := conf.Validate()
+	require.Error(t, err)
+	for _, want := range []string{
+		`invalid nsq topic "orders/eu"`,
+		"invalid nsq channel",
+		"nsq addresses: invalid address \"nsqd\"",
+		"nsq lookupd addresses",
+		"max in flight must be greater than 0",
+		"tls client_certs[0]: pkcs12_file cannot be combined",
+		"unknown encryption algorithm: rot13",
+		"encryption requires either keyring_file or keys",
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	m := ifs.NewMemFS()
+	require.NoError(t, ifs.WriteFile(m, "reader.yaml", []byte("topic: orders\nmax_inflight: 10\ntls:\n  enable: true\n"), 0o644))
+
+	_, err := LoadConfig(m, "reader.yaml")
+	assert.ErrorContains(t, err, "reader.yaml: unknown key max_inflight")
+	assert.ErrorContains(t, err, "reader.yaml: unknown key tls.enable")
+
+	s := Schema()
+	assert.Equal(t, NamePattern, s.Property("topic").Pattern)
+	assert.Equal(t, MaxNameLength, *s.Property("channel").MaxLength)
+	assert.Equal(t, float64(1), *s.Property("max_in_flight").Minimum)
+	assert.Equal(t, "boolean", s.Property("tls.enabled").Type)
+	assert.Empty(t, s.Property("tls").Dialect)
+	assert.True(t, s.Property("tls.client_certs[].password").WriteOnly)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package in
+
+import (
+	"context"
+	"errors"
+
+	"github.com/deepauto-io/nsqcc"
+	"github.com/nsqio/go-nsq"
+)
+
+// Handler processes a single message. A nil error finishes the message, any
+// other error requeues it unless it has been marked as permanent.
+type Handler func(ctx context.Context, msg *nsq.Message) error
+
+// Middleware wraps a Handler with additional behaviour.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with the provided middlewares. The first middleware is the
+// outermost, and therefore sees every message first.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Consume reads messages from r and passes them to h until the context is
+// cancelled or the reader is closed, acknowledging each message with the
+// result of the handler. Closing the reader results in a nil error.
+// Messages that fail to decode have already been finished or requeued by the
+// reader and are skipped, other read errors than timeouts are retried after a
+// growing delay, see nsqcc.RetryBackoff.
+func Consume(ctx context.Context, r nsqcc.Async, h Handler) error {
+	var backoff nsqcc.RetryBackoff
+	for {
+		msg, ack, err := r.ReadBatch(ctx)
+		if err != nil {
+			switch {
+			case errors.Is(err, nsqcc.ErrTypeClosed):
+				return nil
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case errors.Is(err, nsqcc.ErrTimeout), errors.Is(err, nsqcc.ErrDecode):
+			default:
+				if err := backoff.Wait(ctx); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		backoff.Reset()
+
+		res := h(ctx, msg)
+		if IsPermanent(res) {
+			res = nil
+		}
+		if err := ack(ctx, res); err != nil {
+			return err
+		}
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanentError) Unwrap() error {
+	return p.err
+}
+
+// Permanent marks err as not retryable. Messages that fail with a permanent
+// error are finished rather than requeued.
+func Permanent(err error) error {
+	if err == nil || IsPermanent(err) {
+		return err
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns true if err, or any error it wraps, was marked with
+// Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
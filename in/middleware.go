@@ -0,0 +1,190 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package in
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/deepauto-io/nsqcc"
+	"github.com/nsqio/go-nsq"
+)
+
+// Logging logs every failed message along with its ID, attempt count and
+// handling duration.
+func Logging(l *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *nsq.Message) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			if err != nil {
+				l.Printf("nsq message %s failed after %v (attempt %d, permanent %t): %v",
+					msg.ID[:], time.Since(start), msg.Attempts, IsPermanent(err), err)
+			}
+			return err
+		}
+	}
+}
+
+// Recorder receives the outcome of every handled message.
+type Recorder interface {
+	Observe(msg *nsq.Message, duration time.Duration, err error)
+}
+
+// Metrics reports the duration and result of every handled message to r.
+func Metrics(r Recorder) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *nsq.Message) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			r.Observe(msg, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Tracer starts a span for a message. The returned function is called with
+// the result of the handler once it returns.
+type Tracer interface {
+	Start(ctx context.Context, msg *nsq.Message) (context.Context, func(error))
+}
+
+// Tracing wraps the handling of every message in a span started by t.
+func Tracing(t Tracer) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *nsq.Message) error {
+			ctx, end := t.Start(ctx, msg)
+			err := next(ctx, msg)
+			end(err)
+			return err
+		}
+	}
+}
+
+// Recover converts panics raised by the handler into errors, so that the
+// message is requeued instead of crashing the consumer.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *nsq.Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("handler panic: %v", r)
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Timeout cancels the handler context after d. When the handler gives up on
+// the deadline, returning a context error, nsqcc.ErrTimeout is returned
+// instead, any other result is returned as is. The handler runs in the
+// calling goroutine, so it must honour its context for the timeout to take
+// effect.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *nsq.Message) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			err := next(ctx, msg)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && !IsPermanent(err) &&
+				(errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)) {
+				return fmt.Errorf("%w: %w", nsqcc.ErrTimeout, err)
+			}
+			return err
+		}
+	}
+}
+
+// Dedup skips messages whose key matches one of the last size successfully
+// handled messages. When key is nil messages are keyed by a hash of their
+// body.
+func Dedup(size int, key func(*nsq.Message) string) Middleware {
+	if key == nil {
+		key = func(msg *nsq.Message) string {
+			sum := sha256.Sum256(msg.Body)
+			return string(sum[:])
+		}
+	}
+
+	var mut sync.Mutex
+	order := list.New()
+	seen := map[string]*list.Element{}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *nsq.Message) error {
+			k := key(msg)
+
+			mut.Lock()
+			_, dup := seen[k]
+			mut.Unlock()
+			if dup {
+				return nil
+			}
+
+			if err := next(ctx, msg); err != nil {
+				return err
+			}
+
+			mut.Lock()
+			if _, exists := seen[k]; !exists {
+				seen[k] = order.PushBack(k)
+				if order.Len() > size {
+					oldest := order.Front()
+					order.Remove(oldest)
+					delete(seen, oldest.Value.(string))
+				}
+			}
+			mut.Unlock()
+			return nil
+		}
+	}
+}
+
+// Validate checks every message body with fn before it reaches the handler.
+// Messages that fail validation are rejected with a permanent error.
+func Validate(fn func(body []byte) error) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *nsq.Message) error {
+			if err := fn(msg.Body); err != nil {
+				return Permanent(fmt.Errorf("invalid message: %w", err))
+			}
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Classify marks handler errors for which retryable returns false as
+// permanent, so that the messages that caused them are not requeued.
+func Classify(retryable func(error) bool) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *nsq.Message) error {
+			err := next(ctx, msg)
+			if err != nil && !retryable(err) {
+				return Permanent(err)
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package in
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc"
+	"github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMessage(body string) *nsq.Message {
+	return nsq.NewMessage(nsq.MessageID{}, []byte(body))
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, msg *nsq.Message) error {
+				calls = append(calls, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+
+	h := Chain(func(ctx context.Context, msg *nsq.Message) error {
+		calls = append(calls, "handler")
+		return nil
+	}, mark("a"), mark("b"))
+
+	assert.NoError(t, h(context.Background(), newTestMessage("foo")))
+	assert.Equal(t, []string{"a", "b", "handler"}, calls)
+}
+
+func TestRecoverAndTimeout(t *testing.T) {
+	h := Chain(func(ctx context.Context, msg *nsq.Message) error {
+		panic("boom")
+	}, Recover())
+	assert.EqualError(t, h(context.Background(), newTestMessage("foo")), "handler panic: boom")
+
+	h = Chain(func(ctx context.Context, msg *nsq.Message) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, Timeout(time.Millisecond))
+	assert.ErrorIs(t, h(context.Background(), newTestMessage("foo")), nsqcc.ErrTimeout)
+
+	// Results other than context errors are kept even past the deadline.
+	for _, want := range []error{nil, Permanent(errors.New("invalid")), Permanent(context.DeadlineExceeded)} {
+		h = Chain(func(ctx context.Context, msg *nsq.Message) error {
+			<-ctx.Done()
+			return want
+		}, Timeout(time.Millisecond))
+		assert.Equal(t, want, h(context.Background(), newTestMessage("foo")))
+	}
+}
+
+func TestDedup(t *testing.T) {
+	var handled []string
+	h := Chain(func(ctx context.Context, msg *nsq.Message) error {
+		handled = append(handled, string(msg.Body))
+		if string(msg.Body) == "fail" {
+			return errors.New("nope")
+		}
+		return nil
+	}, Dedup(2, nil))
+
+	for _, body := range []string{"a", "a", "fail", "fail", "b", "c", "a"} {
+		_ = h(context.Background(), newTestMessage(body))
+	}
+	assert.Equal(t, []string{"a", "fail", "fail", "b", "c", "a"}, handled)
+}
+
+func TestValidateAndClassify(t *testing.T) {
+	h := Chain(func(ctx context.Context, msg *nsq.Message) error {
+		return errors.New(string(msg.Body))
+	}, Validate(func(body []byte) error {
+		if len(body) == 0 {
+			return errors.New("empty body")
+		}
+		return nil
+	}), Classify(func(err error) bool {
+		return err.Error() != "fatal"
+	}))
+
+	err := h(context.Background(), newTestMessage(""))
+	assert.True(t, IsPermanent(err))
+	assert.EqualError(t, err, "invalid message: empty body")
+
+	assert.True(t, IsPermanent(h(context.Background(), newTestMessage("fatal"))))
+	assert.False(t, IsPermanent(h(context.Background(), newTestMessage("transient"))))
+}
+
+type brokenReader struct {
+	reads int
+}
+
+func (r *brokenReader) Connect(ctx context.Context) error { return nil }
+func (r *brokenReader) Close(ctx context.Context) error   { return nil }
+
+func (r *brokenReader) ReadBatch(ctx context.Context) (*nsq.Message, nsqcc.AsyncAckFn, error) {
+	r.reads++
+	return nil, nil, errors.New("connection reset")
+}
+
+type undecodableReader struct {
+	brokenReader
+}
+
+func (r *undecodableReader) ReadBatch(ctx context.Context) (*nsq.Message, nsqcc.AsyncAckFn, error) {
+	r.reads++
+	if r.reads > 10 {
+		return nil, nil, nsqcc.ErrTypeClosed
+	}
+	msg := nsq.NewMessage(nsq.MessageID{}, []byte("poison"))
+	return nil, nil, &nsqcc.DecodeError{Msg: msg, Err: errors.New("bad key")}
+}
+
+func TestConsumeSkipsDecodeErrors(t *testing.T) {
+	r := &undecodableReader{}
+	// Backing off after each of the ten failures would outlast the context.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := Consume(ctx, r, func(ctx context.Context, msg *nsq.Message) error { return nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 11, r.reads)
+}
+
+func TestConsumeBacksOff(t *testing.T) {
+	r := &brokenReader{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := Consume(ctx, r, func(ctx context.Context, msg *nsq.Message) error { return nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, r.reads, 10)
+}
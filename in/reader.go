@@ -19,13 +19,18 @@ package in
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"io"
 	"log"
 	"sync"
 
 	"github.com/deepauto-io/nsqcc"
 
+	"github.com/deepauto-io/nsqcc/auth"
+	"github.com/deepauto-io/nsqcc/compress"
+	"github.com/deepauto-io/nsqcc/encrypt"
 	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	ntls "github.com/deepauto-io/nsqcc/tls"
 	"github.com/nsqio/go-nsq"
 )
 
@@ -37,36 +42,94 @@ type nsqReader struct {
 	interruptChan    chan struct{}
 	interruptOnce    sync.Once
 	tlsConf          *tls.Config
+	tlsReloader      *ntls.Reloader
+	cipher           *encrypt.Cipher
+	secret           *auth.Secret
+	watchCtx         context.Context
+	stopWatch        context.CancelFunc
+	onError          func(error)
+	tlsHooks         ntls.ReloadHooks
 	conf             Config
 }
 
-func NewNSQReader(conf Config, mgr ifs.FS) (nsqcc.Async, error) {
+// Option configures a reader created with NewNSQReader.
+type Option func(n *nsqReader)
+
+// OnError sets a callback for errors that happen in the background, such as
+// failing to reload the auth secret or the TLS certificates, or to reconnect
+// with a rotated secret. The reader keeps its previous connection and
+// certificates when they happen.
+func OnError(fn func(err error)) Option {
+	return func(n *nsqReader) {
+		n.onError = fn
+	}
+}
+
+// TLSReloadHooks sets the callbacks of the certificate reloader used when
+// the TLS reload interval is set, for example to alert on certificates that
+// are about to expire. Reload errors go to OnError unless hooks.OnError is
+// set.
+func TLSReloadHooks(hooks ntls.ReloadHooks) Option {
+	return func(n *nsqReader) {
+		n.tlsHooks = hooks
+	}
+}
+
+func NewNSQReader(conf Config, mgr ifs.FS, opts ...Option) (nsqcc.Async, error) {
 	n := &nsqReader{
 		conf:             conf,
 		internalMessages: make(chan *nsq.Message),
 		interruptChan:    make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(n)
+	}
 
-	if conf.TLS.Enabled {
+	if conf.TLS.Enabled && conf.TLS.ReloadInterval > 0 {
+		var err error
+		if n.tlsReloader, err = ntls.NewReloader(conf.TLS, mgr, n.reloadHooks()); err != nil {
+			return nil, err
+		}
+		n.tlsConf = n.tlsReloader.TLSConfig()
+	} else if conf.TLS.Enabled {
 		var err error
 		if n.tlsConf, err = conf.TLS.Get(mgr); err != nil {
 			return nil, err
 		}
 	}
+
+	var err error
+	if n.cipher, err = conf.Encryption.Get(mgr); err != nil {
+		return nil, err
+	}
+
+	if n.secret, err = conf.Auth.Get(mgr); err != nil {
+		return nil, err
+	}
+	n.watchCtx, n.stopWatch = context.WithCancel(context.Background())
+	if n.tlsReloader != nil {
+		go n.tlsReloader.Watch(n.watchCtx)
+	}
+	if n.secret != nil {
+		go n.secret.Watch(n.watchCtx, func(string) { n.rotateSecret() }, n.reportError)
+	}
 	return n, nil
 }
 
 func (n *nsqReader) Connect(ctx context.Context) error {
 	n.cMut.Lock()
 	defer n.cMut.Unlock()
+	return n.connect()
+}
 
-	cfg := nsq.NewConfig()
-	cfg.UserAgent = n.conf.UserAgent
-	cfg.MaxInFlight = n.conf.MaxInFlight
-	cfg.MaxAttempts = n.conf.MaxAttempts
-	if n.tlsConf != nil {
-		cfg.TlsV1 = true
-		cfg.TlsConfig = n.tlsConf
+// connect replaces the consumer, the caller must hold cMut.
+func (n *nsqReader) connect() error {
+	cfg, err := n.conf.nsqConfig(n.tlsConf)
+	if err != nil {
+		return err
+	}
+	if n.secret != nil {
+		cfg.AuthSecret = n.secret.Value()
 	}
 
 	consumer, err := nsq.NewConsumer(n.conf.Topic, n.conf.Channel, cfg)
@@ -79,18 +142,56 @@ func (n *nsqReader) Connect(ctx context.Context) error {
 
 	if err = consumer.ConnectToNSQDs(n.conf.Addresses); err != nil {
 		consumer.Stop()
-		return err
+		return nsqcc.WrapAuthError(err)
 	}
 
 	if err := consumer.ConnectToNSQLookupds(n.conf.LookupAddresses); err != nil {
 		consumer.Stop()
-		return err
+		return nsqcc.WrapAuthError(err)
 	}
 
 	n.consumer = consumer
 	return nil
 }
 
+// rotateSecret replaces the consumer with one that authenticates with the
+// current secret, as nsqd only checks secrets when a connection is opened.
+// It holds cMut throughout, so that no consumer is created after Close.
+func (n *nsqReader) rotateSecret() {
+	n.cMut.Lock()
+	defer n.cMut.Unlock()
+
+	select {
+	case <-n.interruptChan:
+		return
+	default:
+	}
+	old := n.consumer
+	if old == nil {
+		return
+	}
+
+	if err := n.connect(); err != nil {
+		n.reportError(fmt.Errorf("failed to reconnect with rotated auth secret: %w", err))
+		return
+	}
+	old.Stop()
+}
+
+func (n *nsqReader) reloadHooks() ntls.ReloadHooks {
+	hooks := n.tlsHooks
+	if hooks.OnError == nil {
+		hooks.OnError = n.reportError
+	}
+	return hooks
+}
+
+func (n *nsqReader) reportError(err error) {
+	if n.onError != nil {
+		n.onError(err)
+	}
+}
+
 func (n *nsqReader) HandleMessage(message *nsq.Message) error {
 	message.DisableAutoResponse()
 	select {
@@ -107,6 +208,15 @@ func (n *nsqReader) ReadBatch(ctx context.Context) (*nsq.Message, nsqcc.AsyncAck
 	if err != nil {
 		return nil, nil, err
 	}
+
+	if err := n.decode(msg); err != nil {
+		if n.conf.DecodeFailure == DecodeFailureDrop {
+			msg.Finish()
+		} else {
+			msg.Requeue(-1)
+		}
+		return nil, nil, &nsqcc.DecodeError{Msg: msg, Err: err}
+	}
 	n.unAckMsgs = append(n.unAckMsgs, msg)
 
 	return msg, func(rctx context.Context, res error) error {
@@ -118,6 +228,37 @@ func (n *nsqReader) ReadBatch(ctx context.Context) (*nsq.Message, nsqcc.AsyncAck
 	}, nil
 }
 
+// decode reverses the payload encodings applied by the writer, so that
+// handlers receive the body as it was originally written.
+func (n *nsqReader) decode(msg *nsq.Message) error {
+	env, err := nsqcc.DecodeEnvelope(msg.Body)
+	if err != nil {
+		return err
+	}
+	// With encryption enabled plain bodies are rejected by Open, so that
+	// the encryption cannot be stripped by publishing plaintext.
+	if len(env.Headers) == 0 && n.cipher == nil {
+		return nil
+	}
+
+	if env, err = n.cipher.Open(env); err != nil {
+		return err
+	}
+
+	if name, ok := env.Headers[compress.Header]; ok {
+		c, err := compress.Get(name)
+		if err != nil {
+			return err
+		}
+		if env.Body, err = c.Decompress(env.Body); err != nil {
+			return fmt.Errorf("failed to decompress %s payload: %w", name, err)
+		}
+		delete(env.Headers, compress.Header)
+	}
+	msg.Body = env.Encode()
+	return nil
+}
+
 func (n *nsqReader) read(ctx context.Context) (*nsq.Message, error) {
 	var msg *nsq.Message
 	select {
@@ -148,6 +289,7 @@ func (n *nsqReader) disconnect() error {
 }
 
 func (n *nsqReader) Close(ctx context.Context) (err error) {
+	n.stopWatch()
 	n.interruptOnce.Do(func() {
 		close(n.interruptChan)
 	})
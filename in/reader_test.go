@@ -0,0 +1,144 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package in
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc"
+	"github.com/deepauto-io/nsqcc/compress"
+	"github.com/deepauto-io/nsqcc/encrypt"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	ntls "github.com/deepauto-io/nsqcc/tls"
+	"github.com/deepauto-io/nsqcc/tls/tlstest"
+	"github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderDecodeCompressed(t *testing.T) {
+	c, err := compress.Get(compress.Zstd)
+	require.NoError(t, err)
+
+	body, err := c.Compress([]byte("hello world"))
+	require.NoError(t, err)
+
+	msg := newTestMessage(string(nsqcc.Envelope{
+		Headers: map[string]string{compress.Header: compress.Zstd, "source": "test"},
+		Body:    body,
+	}.Encode()))
+
+	n := &nsqReader{conf: NewConfig()}
+	require.NoError(t, n.decode(msg))
+
+	env, err := nsqcc.DecodeEnvelope(msg.Body)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"source": "test"}, env.Headers)
+	assert.Equal(t, "hello world", string(env.Body))
+
+	plain := newTestMessage("plain")
+	require.NoError(t, n.decode(plain))
+	assert.Equal(t, "plain", string(plain.Body))
+
+	corrupt := newTestMessage(string(nsqcc.Envelope{
+		Headers: map[string]string{compress.Header: compress.Gzip},
+		Body:    []byte("not gzip"),
+	}.Encode()))
+	assert.Error(t, n.decode(corrupt))
+}
+
+func TestReaderDecodeRejectsPlaintext(t *testing.T) {
+	conf := encrypt.Config{
+		Enabled:   true,
+		Algorithm: encrypt.AES256GCM,
+		Keys:      "k1:" + base64.StdEncoding.EncodeToString(make([]byte, encrypt.KeySize)),
+		ActiveKey: "k1",
+	}
+	c, err := conf.Get(ifs.OS())
+	require.NoError(t, err)
+	n := &nsqReader{conf: NewConfig(), cipher: c}
+
+	sealed, err := c.Seal(nsqcc.Envelope{Body: []byte("secret")})
+	require.NoError(t, err)
+	msg := newTestMessage(string(sealed.Encode()))
+	require.NoError(t, n.decode(msg))
+	assert.Equal(t, "secret", string(msg.Body))
+
+	assert.ErrorIs(t, n.decode(newTestMessage("plain")), nsqcc.ErrDecrypt)
+	stripped := newTestMessage(string(nsqcc.Envelope{
+		Headers: map[string]string{"source": "test"},
+		Body:    []byte("plain"),
+	}.Encode()))
+	assert.ErrorIs(t, n.decode(stripped), nsqcc.ErrDecrypt)
+}
+
+func TestRotateSecret(t *testing.T) {
+	conf := NewConfig()
+	conf.Topic, conf.Channel = "orders", "test"
+	conf.Addresses, conf.LookupAddresses = []string{"127.0.0.1:1"}, nil
+
+	var errs []error
+	r, err := NewNSQReader(conf, ifs.OS(), OnError(func(err error) { errs = append(errs, err) }))
+	require.NoError(t, err)
+	n := r.(*nsqReader)
+
+	old, err := nsq.NewConsumer(conf.Topic, conf.Channel, nsq.NewConfig())
+	require.NoError(t, err)
+	old.SetLoggerLevel(nsq.LogLevelError)
+	n.consumer = old
+	n.rotateSecret()
+	require.Len(t, errs, 1)
+	assert.ErrorContains(t, errs[0], "rotated auth secret")
+	assert.Same(t, old, n.consumer)
+
+	// No consumer is created once the reader is closed.
+	require.NoError(t, r.Close(context.Background()))
+	n.consumer = old
+	n.rotateSecret()
+	assert.Len(t, errs, 1)
+	assert.Same(t, old, n.consumer)
+}
+
+func TestTLSReloadHooks(t *testing.T) {
+	m := ifs.NewMemFS()
+	leaf := tlstest.NewCA(t, tlstest.ECDSA).Client(t, tlstest.ECDSA)
+
+	conf := NewConfig()
+	conf.TLS.Enabled = true
+	conf.TLS.ReloadInterval = time.Hour
+	conf.TLS.ClientCertificates = []ntls.ClientCertConfig{leaf.WriteFiles(t, m, "certs", tlstest.PKCS8, "")}
+
+	var expiry time.Time
+	var errs []error
+	r, err := NewNSQReader(conf, m,
+		TLSReloadHooks(ntls.ReloadHooks{OnReload: func(t time.Time) { expiry = t }}),
+		OnError(func(err error) { errs = append(errs, err) }))
+	require.NoError(t, err)
+	defer r.Close(context.Background())
+	assert.True(t, leaf.Cert.NotAfter.Equal(expiry))
+
+	// Reload errors go to OnError when the hooks have no OnError of their own.
+	require.NoError(t, m.Remove("certs/key.pem"))
+	n := r.(*nsqReader)
+	if err := n.tlsReloader.Reload(); err != nil {
+		n.reloadHooks().OnError(err)
+	}
+	assert.Len(t, errs, 1)
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nsqcc
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// NSQSettings are the go-nsq settings shared by the reader and writer
+// configurations.
+type NSQSettings struct {
+	UserAgent           string
+	MaxInFlight         int
+	Snappy              bool
+	Deflate             bool
+	DeflateLevel        int
+	MsgTimeout          time.Duration
+	ClientID            string
+	Hostname            string
+	DialTimeout         time.Duration
+	ReadTimeout         time.Duration
+	WriteTimeout        time.Duration
+	HeartbeatInterval   time.Duration
+	OutputBufferTimeout time.Duration
+	OutputBufferSize    int64
+}
+
+// NSQConfig builds a go-nsq configuration from s. Zero values leave the
+// go-nsq defaults in place, a negative OutputBufferTimeout disables output
+// buffering and tlsConf enables TLS when it is not nil. set, when not nil,
+// applies the settings specific to readers or writers before the go-nsq range
+// checks are applied.
+func (s NSQSettings) NSQConfig(tlsConf *tls.Config, set func(cfg *nsq.Config) error) (*nsq.Config, error) {
+	cfg := nsq.NewConfig()
+	cfg.UserAgent = s.UserAgent
+	cfg.MaxInFlight = s.MaxInFlight
+	cfg.Snappy = s.Snappy
+	cfg.Deflate = s.Deflate
+	if s.DeflateLevel != 0 {
+		cfg.DeflateLevel = s.DeflateLevel
+	}
+	cfg.MsgTimeout = s.MsgTimeout
+	cfg.ClientID = s.ClientID
+	cfg.Hostname = s.Hostname
+
+	for _, d := range []struct {
+		dst *time.Duration
+		val time.Duration
+	}{
+		{&cfg.DialTimeout, s.DialTimeout},
+		{&cfg.ReadTimeout, s.ReadTimeout},
+		{&cfg.WriteTimeout, s.WriteTimeout},
+		{&cfg.HeartbeatInterval, s.HeartbeatInterval},
+		{&cfg.OutputBufferTimeout, s.OutputBufferTimeout},
+	} {
+		if d.val != 0 {
+			*d.dst = d.val
+		}
+	}
+	if cfg.OutputBufferTimeout < 0 {
+		cfg.OutputBufferTimeout = -time.Millisecond
+	}
+	if s.OutputBufferSize != 0 {
+		cfg.OutputBufferSize = s.OutputBufferSize
+	}
+
+	if set != nil {
+		if err := set(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.HeartbeatInterval >= cfg.ReadTimeout {
+		return nil, fmt.Errorf("nsq heartbeat interval must be less than read timeout")
+	}
+
+	if tlsConf != nil {
+		cfg.TlsV1 = true
+		cfg.TlsConfig = tlsConf
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid nsq config: %w", err)
+	}
+	return cfg, nil
+}
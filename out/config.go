@@ -17,46 +17,167 @@ limitations under the License.
 package out
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/asaskevich/govalidator"
+	"github.com/deepauto-io/nsqcc"
+	"github.com/deepauto-io/nsqcc/auth"
+	"github.com/deepauto-io/nsqcc/compress"
+	"github.com/deepauto-io/nsqcc/config"
+	"github.com/deepauto-io/nsqcc/encrypt"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
 	ntls "github.com/deepauto-io/nsqcc/tls"
-	"github.com/kelseyhightower/envconfig"
-	"log"
+	"github.com/nsqio/go-nsq"
+	"time"
 )
 
 // Config represents the configuration for the nsqcc command.
 type Config struct {
-	Address     string `json:"address" envconfig:"NSQ_WRITER_ADDRESS"                     default:"127.0.0.1:4150"`           // NSQ 地址
-	UserAgent   string `json:"user_agent" envconfig:"NSQ_WRITER_USER_AGENT"                  default:"DeepAuto Producer/1.0"` // 连接时使用的用户UA
-	MaxInFlight int    `json:"max_in_flight" envconfig:"NSQ_WRITER_MAX_IN_FLIGHT"               default:"64"`                 // 同时处理的最大消息数量
-	TLS         ntls.Config
+	Address              string `json:"address" envconfig:"NSQ_WRITER_ADDRESS"                     default:"127.0.0.1:4150" yaml:"address"`              // NSQ 地址
+	UserAgent            string `json:"user_agent" envconfig:"NSQ_WRITER_USER_AGENT"                  default:"DeepAuto Producer/1.0" yaml:"user_agent"` // 连接时使用的用户UA
+	MaxInFlight          int    `json:"max_in_flight" envconfig:"NSQ_WRITER_MAX_IN_FLIGHT"               default:"64" yaml:"max_in_flight"`              // 同时处理的最大消息数量
+	Snappy               bool   `json:"snappy" envconfig:"NSQ_WRITER_SNAPPY" yaml:"snappy"`                                                              // 连接启用 Snappy 压缩
+	Deflate              bool   `json:"deflate" envconfig:"NSQ_WRITER_DEFLATE" yaml:"deflate"`                                                           // 连接启用 Deflate 压缩
+	DeflateLevel         int    `json:"deflate_level" envconfig:"NSQ_WRITER_DEFLATE_LEVEL"               default:"6" yaml:"deflate_level"`               // Deflate 压缩级别 (1-9)
+	Compression          string `json:"compression" envconfig:"NSQ_WRITER_COMPRESSION" yaml:"compression"`                                               // 消息体压缩算法 (gzip, zstd, snappy, lz4)
+	CompressionThreshold int    `json:"compression_threshold" envconfig:"NSQ_WRITER_COMPRESSION_THRESHOLD" default:"1024" yaml:"compression_threshold"`  // 超过该字节数的消息体才压缩
+
+	DialTimeout         time.Duration `json:"dial_timeout" envconfig:"NSQ_WRITER_DIAL_TIMEOUT" default:"1s" yaml:"dial_timeout"`                               // 建立连接超时时间
+	ReadTimeout         time.Duration `json:"read_timeout" envconfig:"NSQ_WRITER_READ_TIMEOUT" default:"60s" yaml:"read_timeout"`                              // 网络读超时时间 (100ms-5m)
+	WriteTimeout        time.Duration `json:"write_timeout" envconfig:"NSQ_WRITER_WRITE_TIMEOUT" default:"1s" yaml:"write_timeout"`                            // 网络写超时时间 (100ms-5m)
+	HeartbeatInterval   time.Duration `json:"heartbeat_interval" envconfig:"NSQ_WRITER_HEARTBEAT_INTERVAL" default:"30s" yaml:"heartbeat_interval"`            // 心跳间隔, 必须小于读超时时间
+	MsgTimeout          time.Duration `json:"msg_timeout" envconfig:"NSQ_WRITER_MSG_TIMEOUT" yaml:"msg_timeout"`                                               // 服务端消息超时时间, 0 表示使用 nsqd 默认值
+	OutputBufferSize    int64         `json:"output_buffer_size" envconfig:"NSQ_WRITER_OUTPUT_BUFFER_SIZE" default:"16384" yaml:"output_buffer_size"`          // nsqd 写缓冲区大小 (字节)
+	OutputBufferTimeout time.Duration `json:"output_buffer_timeout" envconfig:"NSQ_WRITER_OUTPUT_BUFFER_TIMEOUT" default:"250ms" yaml:"output_buffer_timeout"` // nsqd 刷新写缓冲区的超时时间, 负数表示禁用
+	ClientID            string        `json:"client_id" envconfig:"NSQ_WRITER_CLIENT_ID" yaml:"client_id"`                                                     // 客户端标识, 默认为短主机名
+	Hostname            string        `json:"hostname" envconfig:"NSQ_WRITER_HOSTNAME" yaml:"hostname"`                                                        // 客户端主机名, 默认为系统主机名
+
+	// The nested blocks share the NSQ_WRITER prefix of the fields above, as
+	// in NSQ_WRITER_TLS_ENABLE, while readers use NSQ like their own fields.
+	TLS        ntls.Config    `json:"tls" yaml:"tls" envprefix:"NSQ_WRITER"`
+	Encryption encrypt.Config `json:"encryption" yaml:"encryption" envprefix:"NSQ_WRITER"`
+	Auth       auth.Config    `json:"auth" yaml:"auth" envprefix:"NSQ_WRITER"`
 }
 
-// NewConfig creates a new Config with default values.
+// NewConfig creates a new Config with the default values of its default tags.
 func NewConfig() Config {
-	return Config{
-		Address:     "127.0.0.1:4150",
-		UserAgent:   "DeepAuto Producer/1.0",
-		MaxInFlight: 64,
-		TLS:         ntls.NewConfig(),
-	}
+	return config.Defaults[Config]()
 }
 
-// Validate validates the configuration.
+// Validate validates the configuration, reporting every problem at once.
 func (c Config) Validate() error {
+	var errs []error
 	if govalidator.IsNull(c.Address) {
-		return fmt.Errorf("nsq address is required")
+		errs = append(errs, errors.New("nsq address is required"))
+	} else if err := config.CheckHostPort(c.Address); err != nil {
+		errs = append(errs, fmt.Errorf("nsq address: %w", err))
 	}
-	return nil
-}
 
-func MustLoadConfig(cfgPath string) Config {
-	cfg := Config{}
-	if cfgPath == "" {
-		if err := envconfig.Process("", &cfg); err != nil {
-			log.Fatal(err)
+	if c.MaxInFlight <= 0 {
+		errs = append(errs, errors.New("nsq max in flight must be greater than 0"))
+	}
+
+	if c.Snappy && c.Deflate {
+		errs = append(errs, errors.New("only one field between snappy and deflate can be enabled"))
+	}
+
+	if c.Deflate && (c.DeflateLevel < 1 || c.DeflateLevel > 9) {
+		errs = append(errs, errors.New("nsq deflate level must be between 1 and 9"))
+	}
+
+	if c.Compression != "" {
+		if _, err := compress.Get(c.Compression); err != nil {
+			errs = append(errs, err)
 		}
-		return cfg
 	}
-	return cfg
+
+	if c.CompressionThreshold < 0 {
+		errs = append(errs, errors.New("nsq compression threshold must not be negative"))
+	}
+
+	if err := c.TLS.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Encryption.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Auth.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := c.nsqConfig(nil); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// nsqConfig builds the go-nsq configuration of the writer, see
+// nsqcc.NSQSettings.NSQConfig.
+func (c Config) nsqConfig(tlsConf *tls.Config) (*nsq.Config, error) {
+	return nsqcc.NSQSettings{
+		UserAgent:           c.UserAgent,
+		MaxInFlight:         c.MaxInFlight,
+		Snappy:              c.Snappy,
+		Deflate:             c.Deflate,
+		DeflateLevel:        c.DeflateLevel,
+		MsgTimeout:          c.MsgTimeout,
+		ClientID:            c.ClientID,
+		Hostname:            c.Hostname,
+		DialTimeout:         c.DialTimeout,
+		ReadTimeout:         c.ReadTimeout,
+		WriteTimeout:        c.WriteTimeout,
+		HeartbeatInterval:   c.HeartbeatInterval,
+		OutputBufferTimeout: c.OutputBufferTimeout,
+		OutputBufferSize:    c.OutputBufferSize,
+	}.NSQConfig(tlsConf, nil)
+}
+
+// Describe returns every field of the writer configuration, for generating
+// documentation, see config.Describe.
+func Describe() []config.Field {
+	return config.MustDescribe[Config]()
+}
+
+// Schema returns the JSON Schema of writer configuration files.
+func Schema() *config.Schema {
+	s := config.MustSchemaOf(&Config{})
+	s.Title = "nsqcc writer"
+	s.Property("address").Pattern = config.HostPortPattern
+	s.Property("max_in_flight").Minimum = config.Float(1)
+	s.Property("deflate_level").Minimum, s.Property("deflate_level").Maximum = config.Float(1), config.Float(9)
+	// The empty string disables compression.
+	s.Property("compression").Enum = []any{""}
+	for _, name := range compress.Names() {
+		s.Property("compression").Enum = append(s.Property("compression").Enum, name)
+	}
+	s.Property("compression_threshold").Minimum = config.Float(0)
+	s.Properties["tls"] = ntls.Schema()
+	s.Properties["tls"].Dialect = ""
+	return s
+}
+
+// LoadConfig loads the configuration from files, see config.LoadFiles.
+func LoadConfig(f ifs.FS, paths ...string) (Config, error) {
+	return config.LoadFiles[Config](f, paths...)
+}
+
+// LoadEnvConfig loads the configuration from environment variables named
+// after prefix, see config.LoadEnv.
+func LoadEnvConfig(prefix string) (Config, error) {
+	return config.LoadEnv[Config](prefix)
+}
+
+// MustLoadConfig loads the configuration from the environment or from the
+// comma separated list of paths in cfgPath, see config.MustLoad.
+func MustLoadConfig(cfgPath string) Config {
+	return config.MustLoad[Config](cfgPath)
+}
+
+// String returns the configuration with its secrets redacted, so that it can
+// be printed or logged.
+func (c Config) String() string {
+	return config.Format(c)
 }
@@ -0,0 +1,137 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package out
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/config"
+	"github.com/deepauto-io/nsqcc/encrypt"
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	ntls "github.com/deepauto-io/nsqcc/tls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	m := ifs.NewMemFS()
+	require.NoError(t, m.MkdirAll("conf", 0o755))
+	require.NoError(t, ifs.WriteFile(m, "conf/tls.yaml", []byte("tls:\n  enabled: true\n  server_name: nsqd.internal\n"), 0o644))
+	require.NoError(t, ifs.WriteFile(m, "conf/writer.yaml", []byte("include: tls.yaml\naddress: nsqd:4150\ncompression: zstd\nwrite_timeout: 2s\n"), 0o644))
+
+	conf, err := LoadConfig(m, "conf/writer.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "nsqd:4150", conf.Address)
+	assert.Equal(t, "zstd", conf.Compression)
+	assert.Equal(t, 1024, conf.CompressionThreshold)
+	assert.Equal(t, 2*time.Second, conf.WriteTimeout)
+	assert.True(t, conf.TLS.Enabled)
+	require.NoError(t, conf.Validate())
+
+	cfg, err := conf.nsqConfig(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, cfg.WriteTimeout)
+	assert.Equal(t, 250*time.Millisecond, cfg.OutputBufferTimeout)
+
+	require.NoError(t, ifs.WriteFile(m, "typo.yaml", []byte("adress: nsqd:4150\n"), 0o644))
+	_, err = LoadConfig(m, "typo.yaml")
+	assert.ErrorContains(t, err, "typo.yaml: unknown key adress")
+}
+
+func TestLoadEnvConfig(t *testing.T) {
+	t.Setenv("ORDERS_NSQ_WRITER_ADDRESS", "nsqd:4150")
+	t.Setenv("ORDERS_NSQ_WRITER_TLS_ENABLE", "true")
+
+	conf, err := LoadEnvConfig("ORDERS")
+	require.NoError(t, err)
+	assert.Equal(t, "nsqd:4150", conf.Address)
+	assert.True(t, conf.TLS.Enabled)
+}
+
+func TestConfigValidate(t *testing.T) {
+	require.NoError(t, NewConfig().Validate())
+
+	conf := NewConfig()
+	conf.Address = "nsqd"
+	conf.MaxInFlight = 0
+	conf.Snappy, conf.Deflate = true, true
+	conf.Compression = "brotli"
+	conf.CompressionThreshold = -1
+	conf.HeartbeatInterval = 2 * time.Minute
+	conf.TLS.ClientCertificates = []ntls.ClientCertConfig{{PKCS12File: "a.p12", Key: "inline"}}
+	conf.Encryption = encrypt.Config{Enabled: true, Algorithm: "rot13"}
+
+	err := conf.Validate()
+	require.Error(t, err)
+	for _, want := range []string{
+		`nsq address: invalid address "nsqd"`,
+		"max in flight must be greater than 0",
+		"only one field between snappy and deflate",
+		"brotli",
+		"compression threshold must not be negative",
+		"heartbeat interval must be less than read timeout",
+		"tls client_certs[0]: pkcs12_file cannot be combined",
+		"unknown encryption algorithm: rot13",
+		"encryption requires either keyring_file or keys",
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	s := Schema()
+	assert.Equal(t, config.SchemaDialect, s.Dialect)
+	assert.Equal(t, config.HostPortPattern, s.Property("address").Pattern)
+	assert.Equal(t, []any{"", "gzip", "zstd", "snappy", "lz4"}, s.Property("compression").Enum)
+	assert.Equal(t, float64(0), *s.Property("compression_threshold").Minimum)
+	assert.Equal(t, "boolean", s.Property("tls.enabled").Type)
+	assert.Empty(t, s.Property("tls").Dialect)
+	assert.True(t, s.Property("auth.secret").WriteOnly)
+}
+
+// Files holding the JSON encoding of a configuration load back into it.
+func TestLoadConfigJSONRoundTrip(t *testing.T) {
+	conf := NewConfig()
+	conf.Compression = "zstd"
+	conf.WriteTimeout = 2 * time.Second
+	conf.TLS.Enabled = true
+	conf.TLS.InsecureSkipVerify = true
+	conf.TLS.RootCAsFile = "ca.pem"
+	conf.TLS.ClientCertificates = []ntls.ClientCertConfig{{CertFile: "cert.pem", KeyFile: "key.pem"}}
+	b, err := json.Marshal(conf)
+	require.NoError(t, err)
+
+	m := ifs.NewMemFS()
+	require.NoError(t, ifs.WriteFile(m, "writer.json", b, 0o644))
+	loaded, err := LoadConfig(m, "writer.json")
+	require.NoError(t, err)
+	assert.Equal(t, conf, loaded)
+}
+
+func TestConfigStringRedactsSecrets(t *testing.T) {
+	conf := NewConfig()
+	conf.Auth.Secret = "auth-secret"
+
+	s := conf.String()
+	assert.Contains(t, s, conf.Address)
+	assert.Contains(t, s, config.Redacted)
+	assert.NotContains(t, s, "auth-secret")
+	assert.NotContains(t, fmt.Sprintf("%v", conf), "auth-secret")
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package out
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/deepauto-io/nsqcc"
+)
+
+// ErrMessageTooLarge is returned when a message is rejected by MaxSize.
+var ErrMessageTooLarge = errors.New("message exceeds maximum size")
+
+// Publisher publishes a message to a topic.
+type Publisher func(ctx context.Context, topic string, msg []byte) error
+
+// Interceptor wraps a Publisher, it may mutate or reject messages before
+// passing them on and observe the result afterwards.
+type Interceptor func(Publisher) Publisher
+
+type interceptedSink struct {
+	nsqcc.AsyncSink
+	publish Publisher
+}
+
+// Intercept wraps sink so that every write passes through the provided
+// interceptors. The first interceptor is the outermost, and therefore sees
+// every message first.
+func Intercept(sink nsqcc.AsyncSink, interceptors ...Interceptor) nsqcc.AsyncSink {
+	publish := Publisher(sink.WriteWithContext)
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		publish = interceptors[i](publish)
+	}
+	return &interceptedSink{
+		AsyncSink: sink,
+		publish:   publish,
+	}
+}
+
+func (i *interceptedSink) WriteWithContext(ctx context.Context, topic string, msg []byte) error {
+	return i.publish(ctx, topic, msg)
+}
+
+// Transform replaces every message with the result of fn, which can be used
+// for compression, encryption or any other rewrite of the body.
+func Transform(fn func(topic string, msg []byte) ([]byte, error)) Interceptor {
+	return func(next Publisher) Publisher {
+		return func(ctx context.Context, topic string, msg []byte) error {
+			msg, err := fn(topic, msg)
+			if err != nil {
+				return err
+			}
+			return next(ctx, topic, msg)
+		}
+	}
+}
+
+// Headers adds the provided headers to every message, encoding it as an
+// nsqcc.Envelope. Headers already present on a message take precedence.
+func Headers(headers map[string]string) Interceptor {
+	return Transform(func(topic string, msg []byte) ([]byte, error) {
+		env, err := nsqcc.DecodeEnvelope(msg)
+		if err != nil {
+			return nil, err
+		}
+		if env.Headers == nil {
+			env.Headers = make(map[string]string, len(headers))
+		}
+		for k, v := range headers {
+			if _, exists := env.Headers[k]; !exists {
+				env.Headers[k] = v
+			}
+		}
+		return env.Encode(), nil
+	})
+}
+
+// Validate rejects messages for which fn returns an error.
+func Validate(fn func(topic string, msg []byte) error) Interceptor {
+	return func(next Publisher) Publisher {
+		return func(ctx context.Context, topic string, msg []byte) error {
+			if err := fn(topic, msg); err != nil {
+				return fmt.Errorf("invalid message: %w", err)
+			}
+			return next(ctx, topic, msg)
+		}
+	}
+}
+
+// MaxSize rejects messages larger than n bytes with ErrMessageTooLarge.
+func MaxSize(n int) Interceptor {
+	return func(next Publisher) Publisher {
+		return func(ctx context.Context, topic string, msg []byte) error {
+			if len(msg) > n {
+				return fmt.Errorf("%w: %d > %d bytes", ErrMessageTooLarge, len(msg), n)
+			}
+			return next(ctx, topic, msg)
+		}
+	}
+}
+
+// Observe calls fn with every message and the result of publishing it.
+func Observe(fn func(topic string, msg []byte, err error)) Interceptor {
+	return func(next Publisher) Publisher {
+		return func(ctx context.Context, topic string, msg []byte) error {
+			err := next(ctx, topic, msg)
+			fn(topic, msg, err)
+			return err
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package out
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/deepauto-io/nsqcc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSink struct {
+	written [][]byte
+}
+
+func (f *fakeSink) Connect(ctx context.Context) error { return nil }
+func (f *fakeSink) Close(ctx context.Context) error   { return nil }
+
+func (f *fakeSink) WriteWithContext(ctx context.Context, topic string, msg []byte) error {
+	f.written = append(f.written, msg)
+	return nil
+}
+
+func TestIntercept(t *testing.T) {
+	sink := &fakeSink{}
+
+	var observed []error
+	w := Intercept(sink,
+		Observe(func(topic string, msg []byte, err error) {
+			observed = append(observed, err)
+		}),
+		Validate(func(topic string, msg []byte) error {
+			if string(msg) == "bad" {
+				return errors.New("bad message")
+			}
+			return nil
+		}),
+		Headers(map[string]string{"source": "test"}),
+		MaxSize(32),
+	)
+
+	ctx := context.Background()
+	require.NoError(t, w.WriteWithContext(ctx, "foo", []byte("hello")))
+	assert.EqualError(t, w.WriteWithContext(ctx, "foo", []byte("bad")), "invalid message: bad message")
+	assert.ErrorIs(t, w.WriteWithContext(ctx, "foo", make([]byte, 30)), ErrMessageTooLarge)
+
+	require.Len(t, sink.written, 1)
+	env, err := nsqcc.DecodeEnvelope(sink.written[0])
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"source": "test"}, env.Headers)
+	assert.Equal(t, "hello", string(env.Body))
+
+	require.Len(t, observed, 3)
+	assert.NoError(t, observed[0])
+	assert.Error(t, observed[1])
+	assert.Error(t, observed[2])
+}
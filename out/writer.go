@@ -27,41 +27,114 @@ import (
 	"github.com/asaskevich/govalidator"
 	"github.com/deepauto-io/nsqcc"
 
+	"github.com/deepauto-io/nsqcc/auth"
+	"github.com/deepauto-io/nsqcc/compress"
+	"github.com/deepauto-io/nsqcc/encrypt"
 	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	ntls "github.com/deepauto-io/nsqcc/tls"
 	"github.com/nsqio/go-nsq"
 )
 
 type nsqWriter struct {
-	conf     Config
-	tlsConf  *tls.Config
-	connMut  sync.RWMutex
-	producer *nsq.Producer
+	conf        Config
+	tlsConf     *tls.Config
+	tlsReloader *ntls.Reloader
+	comp        compress.Compressor
+	cipher      *encrypt.Cipher
+	secret      *auth.Secret
+	connMut     sync.RWMutex
+	producer    *nsq.Producer
+	closed      bool
+	onError     func(error)
+	tlsHooks    ntls.ReloadHooks
+
+	watchCtx  context.Context
+	stopWatch context.CancelFunc
 }
 
-func NewNSQWriter(conf Config, mgr ifs.FS) (nsqcc.AsyncSink, error) {
+// Option configures a writer created with NewNSQWriter.
+type Option func(n *nsqWriter)
+
+// OnError sets a callback for errors that happen in the background, such as
+// failing to reload the auth secret or the TLS certificates, or to reconnect
+// with a rotated secret. The writer keeps its previous connection and
+// certificates when they happen.
+func OnError(fn func(err error)) Option {
+	return func(n *nsqWriter) {
+		n.onError = fn
+	}
+}
+
+// TLSReloadHooks sets the callbacks of the certificate reloader used when
+// the TLS reload interval is set, for example to alert on certificates that
+// are about to expire. Reload errors go to OnError unless hooks.OnError is
+// set.
+func TLSReloadHooks(hooks ntls.ReloadHooks) Option {
+	return func(n *nsqWriter) {
+		n.tlsHooks = hooks
+	}
+}
+
+func NewNSQWriter(conf Config, mgr ifs.FS, opts ...Option) (nsqcc.AsyncSink, error) {
 	n := nsqWriter{
 		conf: conf,
 	}
+	for _, opt := range opts {
+		opt(&n)
+	}
 
-	if conf.TLS.Enabled {
+	if conf.Compression != "" {
+		var err error
+		if n.comp, err = compress.Get(conf.Compression); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.TLS.Enabled && conf.TLS.ReloadInterval > 0 {
+		var err error
+		if n.tlsReloader, err = ntls.NewReloader(conf.TLS, mgr, n.reloadHooks()); err != nil {
+			return nil, err
+		}
+		n.tlsConf = n.tlsReloader.TLSConfig()
+	} else if conf.TLS.Enabled {
 		var err error
 		if n.tlsConf, err = conf.TLS.Get(mgr); err != nil {
 			return nil, err
 		}
 	}
+
+	var err error
+	if n.cipher, err = conf.Encryption.Get(mgr); err != nil {
+		return nil, err
+	}
+
+	if n.secret, err = conf.Auth.Get(mgr); err != nil {
+		return nil, err
+	}
+	n.watchCtx, n.stopWatch = context.WithCancel(context.Background())
+	if n.tlsReloader != nil {
+		go n.tlsReloader.Watch(n.watchCtx)
+	}
+	if n.secret != nil {
+		go n.secret.Watch(n.watchCtx, func(string) { n.rotateSecret() }, n.reportError)
+	}
 	return &n, nil
 }
 
 func (n *nsqWriter) Connect(ctx context.Context) error {
 	n.connMut.Lock()
 	defer n.connMut.Unlock()
+	return n.connect()
+}
 
-	cfg := nsq.NewConfig()
-	cfg.UserAgent = n.conf.UserAgent
-	cfg.MaxInFlight = n.conf.MaxInFlight
-	if n.tlsConf != nil {
-		cfg.TlsV1 = true
-		cfg.TlsConfig = n.tlsConf
+// connect replaces the producer, the caller must hold connMut.
+func (n *nsqWriter) connect() error {
+	cfg, err := n.conf.nsqConfig(n.tlsConf)
+	if err != nil {
+		return err
+	}
+	if n.secret != nil {
+		cfg.AuthSecret = n.secret.Value()
 	}
 
 	producer, err := nsq.NewProducer(n.conf.Address, cfg)
@@ -72,12 +145,46 @@ func (n *nsqWriter) Connect(ctx context.Context) error {
 	producer.SetLogger(log.New(io.Discard, "", log.Flags()), nsq.LogLevelError)
 
 	if err := producer.Ping(); err != nil {
-		return err
+		producer.Stop()
+		return nsqcc.WrapAuthError(err)
 	}
 	n.producer = producer
 	return nil
 }
 
+// rotateSecret replaces the producer with one that authenticates with the
+// current secret, as nsqd only checks secrets when a connection is opened.
+// It holds connMut throughout, so that no producer is created after Close.
+func (n *nsqWriter) rotateSecret() {
+	n.connMut.Lock()
+	defer n.connMut.Unlock()
+
+	old := n.producer
+	if n.closed || old == nil {
+		return
+	}
+
+	if err := n.connect(); err != nil {
+		n.reportError(fmt.Errorf("failed to reconnect with rotated auth secret: %w", err))
+		return
+	}
+	old.Stop()
+}
+
+func (n *nsqWriter) reloadHooks() ntls.ReloadHooks {
+	hooks := n.tlsHooks
+	if hooks.OnError == nil {
+		hooks.OnError = n.reportError
+	}
+	return hooks
+}
+
+func (n *nsqWriter) reportError(err error) {
+	if n.onError != nil {
+		n.onError(err)
+	}
+}
+
 func (n *nsqWriter) WriteWithContext(ctx context.Context, topic string, msg []byte) error {
 	n.connMut.RLock()
 	prod := n.producer
@@ -94,12 +201,50 @@ func (n *nsqWriter) WriteWithContext(ctx context.Context, topic string, msg []by
 	if len(msg) == 0 {
 		return nil
 	}
-	return prod.Publish(topic, msg)
+
+	msg, err := n.encode(msg)
+	if err != nil {
+		return err
+	}
+	return nsqcc.WrapAuthError(prod.Publish(topic, msg))
+}
+
+// encode applies the configured payload encodings to a message body, marking
+// each of them in the envelope headers so that readers can reverse them.
+func (n *nsqWriter) encode(msg []byte) ([]byte, error) {
+	if n.comp == nil && n.cipher == nil {
+		return msg, nil
+	}
+
+	env, err := nsqcc.DecodeEnvelope(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.comp != nil && len(env.Body) > n.conf.CompressionThreshold {
+		if env.Body, err = n.comp.Compress(env.Body); err != nil {
+			return nil, fmt.Errorf("failed to compress payload: %w", err)
+		}
+		if env.Headers == nil {
+			env.Headers = map[string]string{}
+		}
+		env.Headers[compress.Header] = n.comp.Name()
+	}
+
+	// Encryption comes last, compressing ciphertext would be pointless.
+	if n.cipher != nil {
+		if env, err = n.cipher.Seal(env); err != nil {
+			return nil, fmt.Errorf("failed to encrypt payload: %w", err)
+		}
+	}
+	return env.Encode(), nil
 }
 
 func (n *nsqWriter) Close(ctx context.Context) error {
+	n.stopWatch()
 	go func() {
 		n.connMut.Lock()
+		n.closed = true
 		if n.producer != nil {
 			n.producer.Stop()
 			n.producer = nil
@@ -19,7 +19,9 @@ package out
 import (
 	"context"
 	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/nsqio/go-nsq"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 )
 
@@ -34,3 +36,29 @@ func TestNewNSQWriter(t *testing.T) {
 	err = write.WriteWithContext(context.Background(), "hello", []byte("world"))
 	assert.NoError(t, err)
 }
+
+func TestRotateSecret(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Address = "127.0.0.1:1"
+
+	var errs []error
+	write, err := NewNSQWriter(cfg, ifs.OS(), OnError(func(err error) { errs = append(errs, err) }))
+	require.NoError(t, err)
+	n := write.(*nsqWriter)
+
+	old, err := nsq.NewProducer(cfg.Address, nsq.NewConfig())
+	require.NoError(t, err)
+	old.SetLoggerLevel(nsq.LogLevelError)
+	n.producer = old
+	n.rotateSecret()
+	require.Len(t, errs, 1)
+	assert.ErrorContains(t, errs[0], "rotated auth secret")
+	assert.Same(t, old, n.producer)
+
+	n.connMut.Lock()
+	n.closed = true
+	n.connMut.Unlock()
+	n.rotateSecret()
+	assert.Len(t, errs, 1)
+	assert.Same(t, old, n.producer)
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nsqcc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Processor transforms a message body on its way through a Pipeline.
+// Returning no bodies filters the message out, returning more than one splits
+// it into several messages. An error causes the source message to be
+// requeued.
+type Processor func(ctx context.Context, body []byte) ([][]byte, error)
+
+// PipelineConfig contains configuration params for a Pipeline.
+type PipelineConfig struct {
+	// Topic is the topic processed messages are written to.
+	Topic string
+	// Concurrency is the maximum number of messages processed in parallel,
+	// defaults to 1.
+	Concurrency int
+	// RateLimit is the maximum number of messages read per second, zero
+	// means unlimited.
+	RateLimit float64
+	// RetryBackoff spaces out reads after consecutive read errors, the zero
+	// value uses the default delays.
+	RetryBackoff RetryBackoff
+	// OnError, if set, is called with errors that did not stop the pipeline,
+	// such as failed writes that caused a message to be requeued.
+	OnError func(error)
+}
+
+// Pipeline reads messages from an Async source, runs them through a chain of
+// processors and writes the results to an AsyncSink. A source message is only
+// acknowledged once every resulting message has been written, and is
+// requeued otherwise, giving at-least-once delivery.
+type Pipeline struct {
+	source Async
+	sink   AsyncSink
+	conf   PipelineConfig
+	procs  []Processor
+}
+
+// NewPipeline creates a new Pipeline. The source and sink must be connected
+// before Run is called.
+func NewPipeline(source Async, sink AsyncSink, conf PipelineConfig, procs ...Processor) (*Pipeline, error) {
+	if conf.Topic == "" {
+		return nil, errors.New("pipeline topic is required")
+	}
+	if conf.Concurrency < 0 {
+		return nil, errors.New("pipeline concurrency must not be negative")
+	}
+	if conf.RateLimit < 0 || math.IsNaN(conf.RateLimit) {
+		return nil, errors.New("pipeline rate limit must not be negative")
+	}
+	if conf.Concurrency == 0 {
+		conf.Concurrency = 1
+	}
+	return &Pipeline{
+		source: source,
+		sink:   sink,
+		conf:   conf,
+		procs:  procs,
+	}, nil
+}
+
+// Run consumes messages until the context is cancelled or the source is
+// closed, and blocks until all in-flight messages have been acknowledged.
+// Closing the source results in a nil error. Read errors are reported to
+// OnError and, unless they are decode failures of a single message, retried
+// after a growing delay, see RetryBackoff.
+func (p *Pipeline) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var tick <-chan time.Time
+	if p.conf.RateLimit > 0 {
+		// Rates above one message per nanosecond are as good as unlimited,
+		// but a zero interval would make NewTicker panic.
+		ticker := time.NewTicker(max(time.Duration(float64(time.Second)/p.conf.RateLimit), 1))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	backoff := p.conf.RetryBackoff
+	sem := make(chan struct{}, p.conf.Concurrency)
+	for {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		msg, ack, err := p.source.ReadBatch(ctx)
+		if err != nil {
+			<-sem
+			switch {
+			case errors.Is(err, ErrTypeClosed):
+				return nil
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case errors.Is(err, ErrTimeout):
+			case errors.Is(err, ErrDecode):
+				// The source has settled the undecodable message, the
+				// next read is unaffected by it.
+				p.reportError(err)
+			default:
+				p.reportError(err)
+				if err := backoff.Wait(ctx); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		backoff.Reset()
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res := p.process(ctx, msg.Body)
+			if res != nil {
+				p.reportError(res)
+			}
+			// Acknowledge even when the run context has been cancelled so
+			// that unwritten messages are requeued promptly.
+			if err := ack(context.WithoutCancel(ctx), res); err != nil {
+				p.reportError(err)
+			}
+		}()
+	}
+}
+
+func (p *Pipeline) process(ctx context.Context, body []byte) error {
+	bodies := [][]byte{body}
+	for _, proc := range p.procs {
+		var next [][]byte
+		for _, b := range bodies {
+			out, err := proc(ctx, b)
+			if err != nil {
+				return err
+			}
+			next = append(next, out...)
+		}
+		if bodies = next; len(bodies) == 0 {
+			return nil
+		}
+	}
+
+	for _, b := range bodies {
+		if err := p.sink.WriteWithContext(ctx, p.conf.Topic, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) reportError(err error) {
+	if p.conf.OnError != nil {
+		p.conf.OnError(err)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2024 The nsqcc Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nsqcc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSource struct {
+	mut   sync.Mutex
+	msgs  [][]byte
+	acked map[string]error
+}
+
+func (f *fakeSource) Connect(ctx context.Context) error { return nil }
+func (f *fakeSource) Close(ctx context.Context) error   { return nil }
+
+func (f *fakeSource) ReadBatch(ctx context.Context) (*nsq.Message, AsyncAckFn, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if len(f.msgs) == 0 {
+		return nil, nil, ErrTypeClosed
+	}
+	body := f.msgs[0]
+	f.msgs = f.msgs[1:]
+	return nsq.NewMessage(nsq.MessageID{}, body), func(ctx context.Context, res error) error {
+		f.mut.Lock()
+		f.acked[string(body)] = res
+		f.mut.Unlock()
+		return nil
+	}, nil
+}
+
+type fakeSink struct {
+	mut     sync.Mutex
+	written []string
+	failOn  string
+}
+
+func (f *fakeSink) Connect(ctx context.Context) error { return nil }
+func (f *fakeSink) Close(ctx context.Context) error   { return nil }
+
+func (f *fakeSink) WriteWithContext(ctx context.Context, topic string, msg []byte) error {
+	if string(msg) == f.failOn {
+		return errors.New("write failed")
+	}
+	f.mut.Lock()
+	f.written = append(f.written, topic+":"+string(msg))
+	f.mut.Unlock()
+	return nil
+}
+
+func TestPipeline(t *testing.T) {
+	source := &fakeSource{
+		msgs:  [][]byte{[]byte("a,b"), []byte("skip"), []byte("c"), []byte("bad")},
+		acked: map[string]error{},
+	}
+	sink := &fakeSink{failOn: "BAD"}
+
+	split := func(ctx context.Context, body []byte) ([][]byte, error) {
+		return bytes.Split(body, []byte(",")), nil
+	}
+	filter := func(ctx context.Context, body []byte) ([][]byte, error) {
+		if string(body) == "skip" {
+			return nil, nil
+		}
+		return [][]byte{bytes.ToUpper(body)}, nil
+	}
+
+	var errs []error
+	var errMut sync.Mutex
+	p, err := NewPipeline(source, sink, PipelineConfig{
+		Topic:       "copy",
+		Concurrency: 2,
+		OnError: func(err error) {
+			errMut.Lock()
+			errs = append(errs, err)
+			errMut.Unlock()
+		},
+	}, split, filter)
+	require.NoError(t, err)
+	require.NoError(t, p.Run(context.Background()))
+
+	assert.ElementsMatch(t, []string{"copy:A", "copy:B", "copy:C"}, sink.written)
+	assert.NoError(t, source.acked["a,b"])
+	assert.NoError(t, source.acked["skip"])
+	assert.NoError(t, source.acked["c"])
+	assert.Error(t, source.acked["bad"])
+	assert.Len(t, errs, 1)
+}
+
+func TestPipelineConfig(t *testing.T) {
+	_, err := NewPipeline(&fakeSource{}, &fakeSink{}, PipelineConfig{})
+	assert.Error(t, err)
+
+	_, err = NewPipeline(&fakeSource{}, &fakeSink{}, PipelineConfig{Topic: "a", RateLimit: -1})
+	assert.Error(t, err)
+
+	// Rates too high for a ticker interval run unlimited instead of panicking.
+	source := &fakeSource{msgs: [][]byte{[]byte("a")}, acked: map[string]error{}}
+	p, err := NewPipeline(source, &fakeSink{}, PipelineConfig{Topic: "a", RateLimit: 1e12})
+	require.NoError(t, err)
+	require.NoError(t, p.Run(context.Background()))
+	assert.Contains(t, source.acked, "a")
+}
+
+type failingSource struct {
+	fakeSource
+	reads int
+}
+
+func (f *failingSource) ReadBatch(ctx context.Context) (*nsq.Message, AsyncAckFn, error) {
+	f.reads++
+	return nil, nil, errors.New("connection reset")
+}
+
+func TestPipelineReadErrorBackoff(t *testing.T) {
+	source := &failingSource{}
+	var errs int
+	p, err := NewPipeline(source, &fakeSink{}, PipelineConfig{
+		Topic:        "a",
+		RetryBackoff: RetryBackoff{Min: 5 * time.Millisecond, Max: 20 * time.Millisecond},
+		OnError:      func(error) { errs++ },
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.Run(ctx), context.DeadlineExceeded)
+	// 5+10+20+20+20 ms: a handful of reads rather than a busy loop.
+	assert.Less(t, source.reads, 10)
+	assert.Equal(t, source.reads, errs)
+}
+
+type undecodableSource struct {
+	fakeSource
+	reads int
+}
+
+func (u *undecodableSource) ReadBatch(ctx context.Context) (*nsq.Message, AsyncAckFn, error) {
+	u.reads++
+	if u.reads > 3 {
+		return nil, nil, ErrTypeClosed
+	}
+	msg := nsq.NewMessage(nsq.MessageID{}, []byte("poison"))
+	return nil, nil, &DecodeError{Msg: msg, Err: errors.New("bad key")}
+}
+
+func TestPipelineDecodeErrorNoBackoff(t *testing.T) {
+	source := &undecodableSource{}
+	var errs []error
+	p, err := NewPipeline(source, &fakeSink{}, PipelineConfig{
+		Topic:        "a",
+		RetryBackoff: RetryBackoff{Min: time.Hour, Max: time.Hour},
+		OnError:      func(err error) { errs = append(errs, err) },
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, p.Run(ctx))
+	require.Len(t, errs, 3)
+	assert.ErrorIs(t, errs[0], ErrDecode)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	b := RetryBackoff{Min: time.Millisecond, Max: 5 * time.Millisecond}
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, b.next())
+	}
+	assert.Equal(t, []time.Duration{1, 2, 4, 5, 5}, scale(delays, time.Millisecond))
+
+	b.Reset()
+	assert.Equal(t, time.Millisecond, b.next())
+	assert.Equal(t, DefaultRetryMin, (&RetryBackoff{}).next())
+}
+
+func scale(ds []time.Duration, unit time.Duration) []time.Duration {
+	for i := range ds {
+		ds[i] /= unit
+	}
+	return ds
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/nsqio/go-nsq"
+)
+
+// CertificateInfo describes a certificate.
+type CertificateInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	IPAddresses []string  `json:"ip_addresses,omitempty"`
+	URIs        []string  `json:"uris,omitempty"`
+	Emails      []string  `json:"emails,omitempty"`
+	Serial      string    `json:"serial"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Expired     bool      `json:"expired"`
+	KeyType     string    `json:"key_type"`
+	SPKIPin     string    `json:"spki_pin"`
+}
+
+// ClientCertificateReport describes a configured client certificate and
+// whether its chain verifies against the configured root CAs.
+type ClientCertificateReport struct {
+	CertificateInfo
+	Chain       []CertificateInfo `json:"chain,omitempty"`
+	VerifyError string            `json:"verify_error,omitempty"`
+}
+
+// HandshakeReport describes the outcome of a TLS handshake with nsqd.
+type HandshakeReport struct {
+	Address     string            `json:"address"`
+	Version     string            `json:"version,omitempty"`
+	CipherSuite string            `json:"cipher_suite,omitempty"`
+	Server      []CertificateInfo `json:"server_certificates,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// Report is the result of Inspect.
+type Report struct {
+	Enabled            bool                      `json:"enabled"`
+	ClientCertificates []ClientCertificateReport `json:"client_certificates"`
+	Handshake          *HandshakeReport          `json:"handshake,omitempty"`
+}
+
+// Err returns the problems found by the inspection joined together, or nil if
+// there are none.
+func (r *Report) Err() error {
+	var errs []error
+	for i, cc := range r.ClientCertificates {
+		if cc.Expired {
+			errs = append(errs, fmt.Errorf("client certificate %d (%s) expired at %s", i, cc.Subject, cc.NotAfter.Format(time.RFC3339)))
+		}
+		if cc.VerifyError != "" {
+			errs = append(errs, fmt.Errorf("client certificate %d (%s) does not verify: %s", i, cc.Subject, cc.VerifyError))
+		}
+	}
+	if r.Handshake != nil && r.Handshake.Error != "" {
+		errs = append(errs, fmt.Errorf("handshake with %s failed: %s", r.Handshake.Address, r.Handshake.Error))
+	}
+	return errors.Join(errs...)
+}
+
+// InspectOptions configures Inspect.
+type InspectOptions struct {
+	// Address is an nsqd TCP address to handshake with. No handshake is made
+	// when it is empty.
+	Address string
+	// Timeout bounds the handshake, defaults to 5 seconds.
+	Timeout time.Duration
+	// AuthSecret is sent to nsqd if it requires authentication.
+	AuthSecret string
+}
+
+// Inspect loads the TLS configuration the same way readers and writers do and
+// reports on its client certificates, optionally handshaking with nsqd to
+// confirm that mutual TLS works. An error is returned only when the
+// configuration cannot be loaded at all; other problems are described by the
+// report, see Report.Err.
+func Inspect(c Config, f ifs.FS, opts InspectOptions) (*Report, error) {
+	tlsConf, err := c.GetNonToggled(f)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf == nil {
+		tlsConf = defaultTLSConfig()
+	}
+	roots, err := c.loadRootCAs(f)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Enabled: c.Enabled, ClientCertificates: []ClientCertificateReport{}}
+	for i, cert := range tlsConf.Certificates {
+		cc, err := inspectClientCertificate(cert, roots)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate %d: %w", i, err)
+		}
+		report.ClientCertificates = append(report.ClientCertificates, cc)
+	}
+
+	if opts.Address != "" {
+		report.Handshake = handshakeNSQ(tlsConf, opts)
+	}
+	return report, nil
+}
+
+func inspectClientCertificate(cert tls.Certificate, roots *x509.CertPool) (ClientCertificateReport, error) {
+	chain := make([]*x509.Certificate, 0, len(cert.Certificate))
+	for _, der := range cert.Certificate {
+		parsed, err := x509.ParseCertificate(der)
+		if err != nil {
+			return ClientCertificateReport{}, err
+		}
+		chain = append(chain, parsed)
+	}
+	if len(chain) == 0 {
+		return ClientCertificateReport{}, errors.New("no certificate found")
+	}
+
+	cc := ClientCertificateReport{CertificateInfo: describeCertificate(chain[0])}
+	for _, parsed := range chain[1:] {
+		cc.Chain = append(cc.Chain, describeCertificate(parsed))
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, parsed := range chain[1:] {
+		opts.Intermediates.AddCert(parsed)
+	}
+	if _, err := chain[0].Verify(opts); err != nil {
+		cc.VerifyError = err.Error()
+	}
+	return cc, nil
+}
+
+func describeCertificate(cert *x509.Certificate) CertificateInfo {
+	info := CertificateInfo{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		DNSNames:  cert.DNSNames,
+		Emails:    cert.EmailAddresses,
+		Serial:    cert.SerialNumber.Text(16),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Expired:   time.Now().After(cert.NotAfter),
+		KeyType:   keyType(cert),
+		SPKIPin:   SPKIPin(cert),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+	return info
+}
+
+func keyType(cert *x509.Certificate) string {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + pub.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
+
+// handshakeNSQ connects to nsqd the same way go-nsq does, negotiating TLS
+// through IDENTIFY, and records the resulting connection state.
+func handshakeNSQ(tlsConf *tls.Config, opts InspectOptions) *HandshakeReport {
+	report := &HandshakeReport{Address: opts.Address}
+
+	var state *tls.ConnectionState
+	conf := tlsConf.Clone()
+	verify := conf.VerifyConnection
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		state = &cs
+		if verify != nil {
+			return verify(cs)
+		}
+		return nil
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	cfg := nsq.NewConfig()
+	cfg.TlsV1 = true
+	cfg.TlsConfig = conf
+	cfg.DialTimeout = timeout
+	cfg.ReadTimeout = timeout
+	cfg.WriteTimeout = timeout
+	cfg.AuthSecret = opts.AuthSecret
+
+	conn := nsq.NewConn(opts.Address, cfg, nopConnDelegate{})
+	conn.SetLogger(nil, nsq.LogLevelError, "")
+	resp, err := conn.Connect()
+	_ = conn.Close()
+
+	if state != nil {
+		report.Version = tls.VersionName(state.Version)
+		report.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+		for _, cert := range state.PeerCertificates {
+			report.Server = append(report.Server, describeCertificate(cert))
+		}
+	}
+	switch {
+	case err != nil:
+		report.Error = err.Error()
+	case resp == nil || !resp.TLSv1:
+		report.Error = "nsqd did not negotiate TLS, check its --tls-cert and --tls-key flags"
+	}
+	return report
+}
+
+// nopConnDelegate ignores every connection event, it is used for one-off
+// connections that never subscribe or publish.
+type nopConnDelegate struct{}
+
+func (nopConnDelegate) OnResponse(*nsq.Conn, []byte)              {}
+func (nopConnDelegate) OnError(*nsq.Conn, []byte)                 {}
+func (nopConnDelegate) OnMessage(*nsq.Conn, *nsq.Message)         {}
+func (nopConnDelegate) OnMessageFinished(*nsq.Conn, *nsq.Message) {}
+func (nopConnDelegate) OnMessageRequeued(*nsq.Conn, *nsq.Message) {}
+func (nopConnDelegate) OnBackoff(*nsq.Conn)                       {}
+func (nopConnDelegate) OnContinue(*nsq.Conn)                      {}
+func (nopConnDelegate) OnResume(*nsq.Conn)                        {}
+func (nopConnDelegate) OnIOError(*nsq.Conn, error)                {}
+func (nopConnDelegate) OnHeartbeat(*nsq.Conn)                     {}
+func (nopConnDelegate) OnClose(*nsq.Conn)                         {}
@@ -0,0 +1,147 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFrame(w io.Writer, data []byte) error {
+	buf := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(4+len(data)))
+	binary.BigEndian.PutUint32(buf[4:], 0) // FrameTypeResponse
+	_, err := w.Write(append(buf, data...))
+	return err
+}
+
+// fakeNSQD accepts a single connection, answers IDENTIFY with tls_v1 and
+// upgrades the connection to TLS requiring a client certificate.
+func fakeNSQD(t *testing.T, cert tls.Certificate, clientCAs *x509.CertPool) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(r, magic); err != nil {
+			return
+		}
+		if _, err := r.ReadString('\n'); err != nil {
+			return
+		}
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			return
+		}
+		if err := writeFrame(conn, []byte(`{"tls_v1":true,"max_rdy_count":2500}`)); err != nil {
+			return
+		}
+
+		server := tls.Server(conn, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+		})
+		if err := server.Handshake(); err != nil {
+			return
+		}
+		_ = writeFrame(server, []byte("OK"))
+		_, _ = io.Copy(io.Discard, server)
+	}()
+	return l.Addr().String()
+}
+
+func TestInspect(t *testing.T) {
+	certPEM, keyPEM := selfSigned(t, time.Now().Add(time.Hour), "localhost")
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(certPEM))
+
+	conf := NewConfig()
+	conf.Enabled = true
+	conf.RootCAs = string(certPEM)
+	conf.ServerName = "localhost"
+	conf.ClientCertificates = []ClientCertConfig{{Cert: string(certPEM), Key: string(keyPEM)}}
+
+	report, err := Inspect(conf, ifs.OS(), InspectOptions{Address: fakeNSQD(t, cert, pool)})
+	require.NoError(t, err)
+	require.NoError(t, report.Err())
+	require.Len(t, report.ClientCertificates, 1)
+
+	cc := report.ClientCertificates[0]
+	assert.Equal(t, "CN=nsqcc", cc.Subject)
+	assert.Equal(t, []string{"localhost"}, cc.DNSNames)
+	assert.Equal(t, "ECDSA P-256", cc.KeyType)
+	assert.False(t, cc.Expired)
+	assert.Empty(t, cc.VerifyError)
+
+	require.NotNil(t, report.Handshake)
+	assert.Equal(t, "TLS 1.3", report.Handshake.Version)
+	require.Len(t, report.Handshake.Server, 1)
+	assert.Equal(t, cc.SPKIPin, report.Handshake.Server[0].SPKIPin)
+}
+
+func TestInspectProblems(t *testing.T) {
+	certPEM, keyPEM := selfSigned(t, time.Now().Add(time.Hour), "localhost")
+	otherPEM, _ := selfSigned(t, time.Now().Add(time.Hour))
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	// The server trusts no client certificate, and the client does not trust
+	// the server.
+	conf := NewConfig()
+	conf.Enabled = true
+	conf.RootCAs = string(otherPEM)
+	conf.ClientCertificates = []ClientCertConfig{{Cert: string(certPEM), Key: string(keyPEM)}}
+
+	report, err := Inspect(conf, ifs.OS(), InspectOptions{
+		Address: fakeNSQD(t, cert, x509.NewCertPool()),
+		Timeout: time.Second,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, report.ClientCertificates[0].VerifyError)
+	assert.NotEmpty(t, report.Handshake.Error)
+	assert.Error(t, report.Err())
+
+	conf.ClientCertificates = []ClientCertConfig{{Cert: string(certPEM), Key: "garbage"}}
+	_, err = Inspect(conf, ifs.OS(), InspectOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls_test
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	ntls "github.com/deepauto-io/nsqcc/tls"
+	"github.com/deepauto-io/nsqcc/tls/tlstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadKeyPair(t *testing.T) {
+	ca := tlstest.NewCA(t, tlstest.ECDSA)
+
+	tests := []struct {
+		name    string
+		keyType tlstest.KeyType
+		format  tlstest.KeyFormat
+	}{
+		{"rsa pkcs8", tlstest.RSA, tlstest.PKCS8},
+		{"ecdsa pkcs8", tlstest.ECDSA, tlstest.PKCS8},
+		{"ed25519 pkcs8", tlstest.Ed25519, tlstest.PKCS8},
+		{"rsa pkcs1", tlstest.RSA, tlstest.PKCS1},
+		{"ecdsa sec1", tlstest.ECDSA, tlstest.SEC1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			leaf := ca.Client(t, test.keyType)
+
+			for _, password := range []string{"", "hunter2"} {
+				conf := leaf.ClientCertConfig(t, test.format, password)
+				cert, err := conf.Load(ifs.OS())
+				require.NoError(t, err)
+				assert.Equal(t, leaf.Cert.Raw, cert.Certificate[0])
+
+				conf = leaf.WriteFiles(t, ifs.OS(), t.TempDir(), test.format, password)
+				cert, err = conf.Load(ifs.OS())
+				require.NoError(t, err)
+				assert.Equal(t, leaf.Cert.Raw, cert.Certificate[0])
+			}
+
+			conf := leaf.ClientCertConfig(t, test.format, "hunter2")
+			conf.Password = ""
+			_, err := conf.Load(ifs.OS())
+			assert.ErrorContains(t, err, "missing password")
+
+			conf.Password = "wrong"
+			_, err = conf.Load(ifs.OS())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadPKCS12(t *testing.T) {
+	ca := tlstest.NewCA(t, tlstest.RSA)
+	for _, kt := range []tlstest.KeyType{tlstest.RSA, tlstest.ECDSA, tlstest.Ed25519} {
+		t.Run(string(kt), func(t *testing.T) {
+			leaf := ca.Client(t, kt)
+			conf := leaf.WritePKCS12(t, ifs.OS(), t.TempDir(), "hunter2")
+
+			cert, err := conf.Load(ifs.OS())
+			require.NoError(t, err)
+			assert.Equal(t, [][]byte{leaf.Cert.Raw, ca.Cert.Raw}, cert.Certificate)
+			assert.Equal(t, leaf.Cert, cert.Leaf)
+
+			conf.Password = "wrong"
+			_, err = conf.Load(ifs.OS())
+			assert.Error(t, err)
+
+			conf.KeyFile = "key.pem"
+			_, err = conf.Load(ifs.OS())
+			assert.ErrorContains(t, err, "cannot be combined")
+		})
+	}
+}
+
+func TestLoadKeyPairErrors(t *testing.T) {
+	leaf := tlstest.NewCA(t, tlstest.ECDSA).Client(t, tlstest.ECDSA)
+
+	tests := map[string]ntls.ClientCertConfig{
+		"undecodable key": {Cert: string(leaf.CertPEM), Key: "garbage"},
+		"missing key":     {Cert: string(leaf.CertPEM)},
+		"missing cert":    {Key: string(leaf.KeyPEM(t, tlstest.PKCS8, ""))},
+		"missing keyfile": {CertFile: "cert.pem"},
+		"missing file":    {CertFile: "missing.pem", KeyFile: "missing.key"},
+		"mismatched key": {
+			Cert: string(leaf.CertPEM),
+			Key:  string(tlstest.NewCA(t, tlstest.ECDSA).Client(t, tlstest.ECDSA).KeyPEM(t, tlstest.PKCS8, "")),
+		},
+	}
+	for name, conf := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := conf.Load(ifs.OS())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := tlstest.NewCA(t, tlstest.Ed25519)
+	server := ca.Server(t, tlstest.ECDSA, "127.0.0.1")
+	client := ca.Client(t, tlstest.RSA)
+
+	dir := t.TempDir()
+	conf := ntls.NewConfig()
+	conf.Enabled = true
+	conf.RootCAsFile = ca.WriteRootCAs(t, ifs.OS(), dir)
+	conf.ClientCertificates = []ntls.ClientCertConfig{
+		client.WriteFiles(t, ifs.OS(), dir, tlstest.PKCS8, "hunter2"),
+	}
+	clientConf, err := conf.Get(ifs.OS())
+	require.NoError(t, err)
+	clientConf.ServerName = "127.0.0.1"
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		serverErr <- tls.Server(serverConn, server.ServerTLSConfig()).Handshake()
+	}()
+
+	require.NoError(t, tls.Client(clientConn, clientConf).Handshake())
+	require.NoError(t, <-serverErr)
+}
+
+func TestLoadKeyPairChroot(t *testing.T) {
+	leaf := tlstest.NewCA(t, tlstest.ECDSA).Client(t, tlstest.ECDSA)
+
+	mfs := ifs.NewMemFS()
+	leaf.WriteFiles(t, mfs, "tenants/a/certs", tlstest.PKCS8, "")
+	leaf.WriteFiles(t, mfs, "tenants/b/certs", tlstest.PKCS8, "")
+	tenant := ifs.Chroot(mfs, "tenants/a", ifs.ChrootReadOnly())
+
+	conf := ntls.ClientCertConfig{CertFile: "certs/cert.pem", KeyFile: "certs/key.pem"}
+	_, err := conf.Load(tenant)
+	require.NoError(t, err)
+
+	conf.KeyFile = "../b/certs/key.pem"
+	_, err = conf.Load(tenant)
+	assert.ErrorIs(t, err, ifs.ErrEscapesRoot)
+}
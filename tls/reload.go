@@ -0,0 +1,241 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+)
+
+// ReloadHooks are optional callbacks invoked by a Reloader.
+type ReloadHooks struct {
+	// OnError is called when reloading fails, the previously loaded
+	// certificates stay in use.
+	OnError func(err error)
+	// OnReload is called every time certificates are loaded, with the expiry
+	// time of the client certificate that expires first.
+	OnReload func(expiry time.Time)
+}
+
+// Reloader serves a *tls.Config whose client certificates and root CAs are
+// re-read whenever their files change, so that long-running processes pick
+// up renewed certificates without restarting.
+type Reloader struct {
+	conf  Config
+	f     ifs.FS
+	hooks ReloadHooks
+	base  *tls.Config
+
+	mut    sync.RWMutex
+	certs  []tls.Certificate
+	roots  *x509.CertPool
+	expiry time.Time
+	stamps map[string]fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader creates a Reloader and loads the certificates of c for the
+// first time.
+func NewReloader(c Config, f ifs.FS, hooks ReloadHooks) (*Reloader, error) {
+	base, err := c.GetNonToggled(f)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		base = defaultTLSConfig()
+	}
+
+	r := &Reloader{conf: c, f: f, hooks: hooks, base: base}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a *tls.Config that always presents the most recently
+// loaded client certificates and verifies peers against the most recently
+// loaded root CAs.
+func (r *Reloader) TLSConfig() *tls.Config {
+	conf := r.base.Clone()
+	conf.Certificates = nil
+	conf.RootCAs = nil
+	conf.GetClientCertificate = r.getClientCertificate
+
+	// Verification is performed by the verifier instead, as the static
+	// RootCAs field cannot change after the handshake starts.
+	pins, _ := parsePins(r.conf.PinnedSPKI)
+	v := verifier{
+		roots:      r.currentRoots,
+		serverName: r.conf.ServerName,
+		skipChain:  r.conf.InsecureSkipVerify,
+		pins:       pins,
+	}
+	conf.InsecureSkipVerify = true
+	conf.VerifyConnection = nil
+	if !v.skipChain || len(pins) > 0 {
+		conf.VerifyConnection = v.verify
+	}
+	return conf
+}
+
+// Expiry returns the expiry time of the loaded client certificate that
+// expires first, or the zero time if there are none.
+func (r *Reloader) Expiry() time.Time {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return r.expiry
+}
+
+func (r *Reloader) getClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	for i := range r.certs {
+		if cri.SupportsCertificate(&r.certs[i]) == nil {
+			return &r.certs[i], nil
+		}
+	}
+	if len(r.certs) > 0 {
+		return &r.certs[0], nil
+	}
+	return &tls.Certificate{}, nil
+}
+
+func (r *Reloader) currentRoots() *x509.CertPool {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return r.roots
+}
+
+// watchedFiles returns the paths of every file the configuration reads.
+func (r *Reloader) watchedFiles() []string {
+	var paths []string
+	if r.conf.RootCAsFile != "" {
+		paths = append(paths, r.conf.RootCAsFile)
+	}
+	for _, cc := range r.conf.ClientCertificates {
+		if cc.CertFile != "" {
+			paths = append(paths, cc.CertFile)
+		}
+		if cc.KeyFile != "" {
+			paths = append(paths, cc.KeyFile)
+		}
+		if cc.PKCS12File != "" {
+			paths = append(paths, cc.PKCS12File)
+		}
+	}
+	return paths
+}
+
+func (r *Reloader) stat() map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	for _, p := range r.watchedFiles() {
+		if info, err := r.f.Stat(p); err == nil {
+			stamps[p] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return stamps
+}
+
+// Reload reads the certificate and root CA files again. On failure the
+// previously loaded certificates are kept.
+func (r *Reloader) Reload() error {
+	stamps := r.stat()
+
+	roots, err := r.conf.loadRootCAs(r.f)
+	if err != nil {
+		return err
+	}
+
+	var certs []tls.Certificate
+	var expiry time.Time
+	for _, cc := range r.conf.ClientCertificates {
+		cert, err := cc.Load(r.f)
+		if err != nil {
+			return err
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		cert.Leaf = leaf
+		if expiry.IsZero() || leaf.NotAfter.Before(expiry) {
+			expiry = leaf.NotAfter
+		}
+		certs = append(certs, cert)
+	}
+
+	r.mut.Lock()
+	r.certs, r.roots, r.expiry, r.stamps = certs, roots, expiry, stamps
+	r.mut.Unlock()
+
+	if r.hooks.OnReload != nil {
+		r.hooks.OnReload(expiry)
+	}
+	return nil
+}
+
+func (r *Reloader) changed() bool {
+	stamps := r.stat()
+
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	if len(stamps) != len(r.stamps) {
+		return true
+	}
+	for p, s := range stamps {
+		if prev, ok := r.stamps[p]; !ok || prev != s {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch checks the watched files for changes every ReloadInterval until the
+// context is cancelled, reloading them when they do. It returns immediately
+// when ReloadInterval is not positive.
+func (r *Reloader) Watch(ctx context.Context) {
+	if r.conf.ReloadInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.conf.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if !r.changed() {
+			continue
+		}
+		if err := r.Reload(); err != nil && r.hooks.OnError != nil {
+			r.hooks.OnError(err)
+		}
+	}
+}
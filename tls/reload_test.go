@@ -0,0 +1,148 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSigned returns a PEM encoded self-signed certificate valid for
+// dnsNames and its PKCS#8 private key.
+func selfSigned(t *testing.T, notAfter time.Time, dnsNames ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "nsqcc"},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeSelfSigned(t *testing.T, dir string, notAfter time.Time) {
+	t.Helper()
+
+	certPEM, keyPEM := selfSigned(t, notAfter)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "client.pem"), certPEM, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "client.key"), keyPEM, 0o600))
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	first := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	writeSelfSigned(t, dir, first)
+
+	conf := NewConfig()
+	conf.Enabled = true
+	conf.ReloadInterval = 5 * time.Millisecond
+	conf.ClientCertificates = []ClientCertConfig{{
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+	}}
+
+	reloaded := make(chan time.Time, 10)
+	errs := make(chan error, 10)
+	r, err := NewReloader(conf, ifs.OS(), ReloadHooks{
+		OnReload: func(expiry time.Time) { reloaded <- expiry },
+		OnError:  func(err error) { errs <- err },
+	})
+	require.NoError(t, err)
+	assert.True(t, first.Equal(<-reloaded))
+	assert.True(t, first.Equal(r.Expiry()))
+
+	tlsConf := r.TLSConfig()
+	assert.True(t, tlsConf.InsecureSkipVerify)
+	assert.NotNil(t, tlsConf.VerifyConnection)
+	cert, err := tlsConf.GetClientCertificate(&tls.CertificateRequestInfo{})
+	require.NoError(t, err)
+	assert.True(t, first.Equal(cert.Leaf.NotAfter))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx)
+
+	// Ensure the modification time moves even on coarse filesystems.
+	second := first.Add(24 * time.Hour)
+	writeSelfSigned(t, dir, second)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "client.pem"), future, future))
+
+	select {
+	case expiry := <-reloaded:
+		assert.True(t, second.Equal(expiry))
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for reload")
+	}
+
+	cert, err = tlsConf.GetClientCertificate(&tls.CertificateRequestInfo{})
+	require.NoError(t, err)
+	assert.True(t, second.Equal(cert.Leaf.NotAfter))
+}
+
+func TestReloaderKeepsCertsOnError(t *testing.T) {
+	dir := t.TempDir()
+	writeSelfSigned(t, dir, time.Now().Add(time.Hour))
+
+	conf := NewConfig()
+	conf.Enabled = true
+	conf.ClientCertificates = []ClientCertConfig{{
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+	}}
+
+	r, err := NewReloader(conf, ifs.OS(), ReloadHooks{})
+	require.NoError(t, err)
+	expiry := r.Expiry()
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "client.key"), []byte("garbage"), 0o600))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, expiry, r.Expiry())
+}
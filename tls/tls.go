@@ -17,59 +17,123 @@ limitations under the License.
 package tls
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"github.com/deepauto-io/nsqcc/config"
 	"github.com/deepauto-io/nsqcc/filepath/ifs"
-	"github.com/kelseyhightower/envconfig"
-	"log"
+	"strings"
+	"time"
 
 	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 // ClientCertConfig contains config fields for a client certificate.
 type ClientCertConfig struct {
-	CertFile string `json:"cert_file" envconfig:"TLS_CERT_FILE" json:"cert_file" yaml:"cert_file"`
-	KeyFile  string `json:"key_file" envconfig:"TLS_KEY_FILE" json:"key_file" yaml:"key_file"`
-	Cert     string `json:"cert" envconfig:"TLS_CERT" json:"cert" yaml:"cert"`
-	Key      string `json:"key" envconfig:"TLS_KEY" json:"key" yaml:"key"`
-	Password string `json:"password" envconfig:"TLS_PASSWORD" json:"password" yaml:"password"`
+	CertFile string `json:"cert_file" envconfig:"TLS_CERT_FILE" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" envconfig:"TLS_KEY_FILE" yaml:"key_file"`
+	Cert     string `json:"cert" envconfig:"TLS_CERT" yaml:"cert"`
+	Key      string `json:"key" envconfig:"TLS_KEY" yaml:"key" secret:"true"`
+	Password string `json:"password" envconfig:"TLS_PASSWORD" yaml:"password" secret:"true"`
+	// PKCS12File is a .p12 or .pfx bundle holding the certificate, its chain
+	// and the private key, decrypted with Password.
+	PKCS12File string `json:"pkcs12_file" envconfig:"TLS_PKCS12_FILE" yaml:"pkcs12_file"`
 }
 
 // Config contains configuration params for TLS.
 type Config struct {
-	Enabled             bool               `json:"enabled" envconfig:"TLS_ENABLE"   json:"enabled" yaml:"enabled"`
-	RootCAs             string             `json:"root_c_as" envconfig:"TLS_ROOTCAS"  json:"root_cas" yaml:"root_cas"`
-	RootCAsFile         string             `json:"root_c_as_file" envconfig:"TLS_ROOTCAS_FILE"             json:"root_cas_file" yaml:"root_cas_file"`
-	InsecureSkipVerify  bool               `json:"insecure_skip_verify" envconfig:"TLS_INSECURE_SKIP_VERIFY" json:"skip_cert_verify" yaml:"skip_cert_verify"`
+	Enabled             bool               `json:"enabled" envconfig:"TLS_ENABLE" yaml:"enabled"`
+	RootCAs             string             `json:"root_cas" envconfig:"TLS_ROOTCAS" yaml:"root_cas"`
+	RootCAsFile         string             `json:"root_cas_file" envconfig:"TLS_ROOTCAS_FILE" yaml:"root_cas_file"`
+	InsecureSkipVerify  bool               `json:"skip_cert_verify" envconfig:"TLS_INSECURE_SKIP_VERIFY" yaml:"skip_cert_verify"`
 	ClientCertificates  []ClientCertConfig `json:"client_certs" yaml:"client_certs"`
-	EnableRenegotiation bool               `json:"enable_renegotiation" envconfig:"TLS_ENABLE_RENEGOTIATION" json:"enable_renegotiation" yaml:"enable_renegotiation"`
+	EnableRenegotiation bool               `json:"enable_renegotiation" envconfig:"TLS_ENABLE_RENEGOTIATION" yaml:"enable_renegotiation"`
+	ReloadInterval      time.Duration      `json:"reload_interval" envconfig:"TLS_RELOAD_INTERVAL" yaml:"reload_interval"`
+	ServerName          string             `json:"server_name" envconfig:"TLS_SERVER_NAME" yaml:"server_name"`
+	MinVersion          string             `json:"min_version" envconfig:"TLS_MIN_VERSION" yaml:"min_version"`
+	MaxVersion          string             `json:"max_version" envconfig:"TLS_MAX_VERSION" yaml:"max_version"`
+	CipherSuites        []string           `json:"cipher_suites" envconfig:"TLS_CIPHER_SUITES" yaml:"cipher_suites"`
+	CurvePreferences    []string           `json:"curve_preferences" envconfig:"TLS_CURVE_PREFERENCES" yaml:"curve_preferences"`
+	AppendSystemCAs     bool               `json:"append_system_cas" envconfig:"TLS_APPEND_SYSTEM_CAS" yaml:"append_system_cas"`
+	PinnedSPKI          []string           `json:"pinned_spki" envconfig:"TLS_PINNED_SPKI" yaml:"pinned_spki"`
 }
 
-// NewConfig creates a new Config with default values.
+// NewConfig creates a new Config with the default values of its default tags.
 func NewConfig() Config {
-	return Config{
-		Enabled:             false,
-		RootCAs:             "",
-		RootCAsFile:         "",
-		InsecureSkipVerify:  false,
-		ClientCertificates:  []ClientCertConfig{},
-		EnableRenegotiation: false,
-	}
+	return config.Defaults[Config]()
+}
+
+// Describe returns every field of the TLS configuration, for generating
+// documentation, see config.Describe.
+func Describe() []config.Field {
+	return config.MustDescribe[Config]()
+}
+
+// Schema returns the JSON Schema of TLS configuration files.
+func Schema() *config.Schema {
+	s := config.MustSchemaOf(&Config{})
+	s.Title = "nsqcc tls"
+	return s
+}
+
+// LoadConfig loads the configuration from files, see config.LoadFiles.
+func LoadConfig(f ifs.FS, paths ...string) (Config, error) {
+	return config.LoadFiles[Config](f, paths...)
+}
+
+// LoadEnvConfig loads the configuration from environment variables named
+// after prefix, see config.LoadEnv.
+func LoadEnvConfig(prefix string) (Config, error) {
+	return config.LoadEnv[Config](prefix)
 }
 
+// MustLoadConfig loads the configuration from the environment or from the
+// comma separated list of paths in cfgPath, see config.MustLoad.
 func MustLoadConfig(cfgPath string) Config {
-	cfg := Config{}
-	if cfgPath == "" {
-		if err := envconfig.Process("", &cfg); err != nil {
-			log.Fatal(err)
+	return config.MustLoad[Config](cfgPath)
+}
+
+// String returns the configuration with its secrets redacted, so that it can
+// be printed or logged.
+func (c Config) String() string {
+	return config.Format(c)
+}
+
+// loadRootCAs returns the pool of root CAs configured with either root_cas or
+// root_cas_file, or nil if neither is set.
+func (c *Config) loadRootCAs(f ifs.FS) (*x509.CertPool, error) {
+	if len(c.RootCAs) > 0 && len(c.RootCAsFile) > 0 {
+		return nil, errors.New("only one field between root_cas and root_cas_file can be specified")
+	}
+
+	var caCert []byte
+	switch {
+	case len(c.RootCAsFile) > 0:
+		var err error
+		if caCert, err = ifs.ReadFile(f, c.RootCAsFile); err != nil {
+			return nil, err
+		}
+	case len(c.RootCAs) > 0:
+		caCert = []byte(c.RootCAs)
+	default:
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if c.AppendSystemCAs {
+		var err error
+		if pool, err = x509.SystemCertPool(); err != nil {
+			return nil, fmt.Errorf("failed to load system root CAs: %w", err)
 		}
-		return cfg
 	}
-	return cfg
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("no valid certificates found in root CAs")
+	}
+	return pool, nil
 }
 
 func defaultTLSConfig() *tls.Config {
@@ -78,10 +142,194 @@ func defaultTLSConfig() *tls.Config {
 	}
 }
 
+// Validate validates the configuration.
+func (c Config) Validate() error {
+	var errs []error
+	if len(c.RootCAs) > 0 && len(c.RootCAsFile) > 0 {
+		errs = append(errs, errors.New("only one field between root_cas and root_cas_file can be specified"))
+	}
+	if c.ReloadInterval < 0 {
+		errs = append(errs, errors.New("tls reload interval must not be negative"))
+	}
+	if strings.ContainsAny(c.ServerName, ":/ ") {
+		errs = append(errs, fmt.Errorf("invalid tls server name: %q", c.ServerName))
+	}
+	for i, cc := range c.ClientCertificates {
+		if err := cc.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("tls client_certs[%d]: %w", i, err))
+		}
+	}
+
+	suites, err := parseCipherSuites(c.CipherSuites)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	minVersion, maxVersion, err := c.versions()
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		if len(suites) > 0 && minVersion == tls.VersionTLS13 {
+			errs = append(errs, errors.New("cipher_suites cannot be configured when min_version is 1.3"))
+		}
+		if len(suites) > 0 && maxVersion != 0 && maxVersion < tls.VersionTLS12 {
+			errs = append(errs, errors.New("cipher_suites requires max_version 1.2 or above"))
+		}
+	}
+	if _, err := parseCurves(c.CurvePreferences); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parsePins(c.PinnedSPKI); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// versions returns the parsed min and max versions, zero meaning unset.
+func (c Config) versions() (minVersion, maxVersion uint16, err error) {
+	if minVersion, err = parseVersion(c.MinVersion); err != nil {
+		return 0, 0, fmt.Errorf("invalid tls min_version: %w", err)
+	}
+	if maxVersion, err = parseVersion(c.MaxVersion); err != nil {
+		return 0, 0, fmt.Errorf("invalid tls max_version: %w", err)
+	}
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		return 0, 0, fmt.Errorf("tls min_version %s is above max_version %s", c.MinVersion, c.MaxVersion)
+	}
+	if minVersion == 0 && maxVersion != 0 && maxVersion < tls.VersionTLS12 {
+		return 0, 0, fmt.Errorf("tls max_version %s is below the default min_version 1.2", c.MaxVersion)
+	}
+	return minVersion, maxVersion, nil
+}
+
+func (c Config) customized() bool {
+	return c.ServerName != "" || c.MinVersion != "" || c.MaxVersion != "" ||
+		len(c.CipherSuites) > 0 || len(c.CurvePreferences) > 0 || len(c.PinnedSPKI) > 0
+}
+
+// applyOptions sets the version, cipher suite, curve and verification
+// options of c on tlsConf. roots returns the pool that server certificates
+// are verified against.
+func (c Config) applyOptions(tlsConf *tls.Config, roots func() *x509.CertPool) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	minVersion, maxVersion, _ := c.versions()
+	if minVersion != 0 {
+		tlsConf.MinVersion = minVersion
+	}
+	tlsConf.MaxVersion = maxVersion
+	tlsConf.CipherSuites, _ = parseCipherSuites(c.CipherSuites)
+	tlsConf.CurvePreferences, _ = parseCurves(c.CurvePreferences)
+	tlsConf.ServerName = c.ServerName
+
+	pins, _ := parsePins(c.PinnedSPKI)
+	v := verifier{
+		roots:      roots,
+		serverName: c.ServerName,
+		skipChain:  c.InsecureSkipVerify,
+		pins:       pins,
+	}
+	switch {
+	case c.ServerName != "" && !c.InsecureSkipVerify:
+		// go-nsq replaces ServerName with the nsqd host before the handshake,
+		// so the override is enforced by verifying the chain ourselves.
+		tlsConf.InsecureSkipVerify = true
+		tlsConf.VerifyConnection = v.verify
+	case len(pins) > 0:
+		// The chain, if any, is verified by crypto/tls before this runs.
+		v.skipChain = true
+		tlsConf.VerifyConnection = v.verify
+	}
+	return nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseVersion parses versions such as "1.2" or "TLS1.3". An empty string
+// returns zero.
+func parseVersion(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	name := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "tls")
+	name = strings.TrimPrefix(name, "v")
+	if v, ok := tlsVersions[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unsupported tls version %q, expected one of 1.0, 1.1, 1.2 or 1.3", s)
+}
+
+// parseCipherSuites maps IANA cipher suite names, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, to their IDs. Suites considered
+// insecure by crypto/tls are rejected.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	insecure := map[string]bool{}
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		id, ok := known[name]
+		switch {
+		case ok:
+			ids = append(ids, id)
+		case insecure[name]:
+			return nil, fmt.Errorf("tls cipher suite %s is insecure", name)
+		default:
+			return nil, fmt.Errorf("unknown tls cipher suite %q", name)
+		}
+	}
+	return ids, nil
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// parseCurves maps curve names such as X25519 or P-256 to their IDs.
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		key := strings.ReplaceAll(strings.ToUpper(strings.TrimSpace(name)), "-", "")
+		key = strings.TrimPrefix(key, "CURVE")
+		id, ok := tlsCurves[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls curve %q, expected one of X25519, P256, P384 or P521", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
 // GetNonToggled returns a valid *tls.Config based on the configuration values
 // of Config. If none of the config fields are set then a nil config is
 // returned.
 func (c *Config) GetNonToggled(f ifs.FS) (*tls.Config, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
 	var tlsConf *tls.Config
 	initConf := func() {
 		if tlsConf != nil {
@@ -90,24 +338,13 @@ func (c *Config) GetNonToggled(f ifs.FS) (*tls.Config, error) {
 		tlsConf = defaultTLSConfig()
 	}
 
-	if len(c.RootCAs) > 0 && len(c.RootCAsFile) > 0 {
-		return nil, errors.New("only one field between root_cas and root_cas_file can be specified")
-	}
-
-	if len(c.RootCAsFile) > 0 {
-		caCert, err := ifs.ReadFile(f, c.RootCAsFile)
-		if err != nil {
-			return nil, err
-		}
-		initConf()
-		tlsConf.RootCAs = x509.NewCertPool()
-		tlsConf.RootCAs.AppendCertsFromPEM(caCert)
+	rootCAs, err := c.loadRootCAs(f)
+	if err != nil {
+		return nil, err
 	}
-
-	if len(c.RootCAs) > 0 {
+	if rootCAs != nil {
 		initConf()
-		tlsConf.RootCAs = x509.NewCertPool()
-		tlsConf.RootCAs.AppendCertsFromPEM([]byte(c.RootCAs))
+		tlsConf.RootCAs = rootCAs
 	}
 
 	for _, conf := range c.ClientCertificates {
@@ -129,6 +366,13 @@ func (c *Config) GetNonToggled(f ifs.FS) (*tls.Config, error) {
 		tlsConf.InsecureSkipVerify = true
 	}
 
+	if c.customized() {
+		initConf()
+		if err := c.applyOptions(tlsConf, func() *x509.CertPool { return rootCAs }); err != nil {
+			return nil, err
+		}
+	}
+
 	return tlsConf, nil
 }
 
@@ -195,27 +439,63 @@ func loadKeyPair(cert, key []byte, password string) (tls.Certificate, error) {
 			return tls.Certificate{}, errors.New("missing password for PKCS#8 encrypted private key")
 		}
 
-		var decryptedKey *rsa.PrivateKey
-		if decryptedKey, err = pkcs8.ParsePKCS8PrivateKeyRSA(keyPem.Bytes, []byte(password)); err != nil {
+		var decryptedKey any
+		if decryptedKey, err = pkcs8.ParsePKCS8PrivateKey(keyPem.Bytes, []byte(password)); err != nil {
 			return tls.Certificate{}, fmt.Errorf("failed to parse encrypted PKCS#8 private key: %s", err)
 		}
-		return getKeyPair(cert, keyPem.Type, x509.MarshalPKCS1PrivateKey(decryptedKey))
+
+		// Re-encoding as unencrypted PKCS#8 supports RSA, ECDSA and Ed25519
+		// keys alike.
+		var keyBytes []byte
+		if keyBytes, err = x509.MarshalPKCS8PrivateKey(decryptedKey); err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to encode decrypted PKCS#8 private key: %s", err)
+		}
+		return getKeyPair(cert, "PRIVATE KEY", keyBytes)
 	}
 
 	return tls.X509KeyPair(cert, key)
 }
 
+func loadPKCS12(data []byte, password string) (tls.Certificate, error) {
+	key, leaf, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to decode PKCS#12 bundle: %s", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return tls.Certificate{}, fmt.Errorf("unsupported PKCS#12 private key type %T", key)
+	}
+	if pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(leaf.PublicKey) {
+		return tls.Certificate{}, errors.New("PKCS#12 private key does not match its certificate")
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, ca := range caCerts {
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+	return cert, nil
+}
+
 // Load returns a TLS certificate, based on either file paths in the
-// config or the raw certs as strings.
+// config, a PKCS#12 bundle or the raw certs as strings.
 func (c *ClientCertConfig) Load(f ifs.FS) (tls.Certificate, error) {
-	if c.CertFile != "" || c.KeyFile != "" {
-		if c.CertFile == "" {
-			return tls.Certificate{}, errors.New("missing cert_file field in client certificate config")
-		}
-		if c.KeyFile == "" {
-			return tls.Certificate{}, errors.New("missing key_file field in client certificate config")
+	if err := c.Validate(); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if c.PKCS12File != "" {
+		data, err := ifs.ReadFile(f, c.PKCS12File)
+		if err != nil {
+			return tls.Certificate{}, err
 		}
+		return loadPKCS12(data, c.Password)
+	}
 
+	if c.CertFile != "" {
 		cert, err := ifs.ReadFile(f, c.CertFile)
 		if err != nil {
 			return tls.Certificate{}, err
@@ -225,16 +505,37 @@ func (c *ClientCertConfig) Load(f ifs.FS) (tls.Certificate, error) {
 		if err != nil {
 			return tls.Certificate{}, err
 		}
-
 		return loadKeyPair(cert, key, c.Password)
 	}
 
-	if c.Cert == "" {
-		return tls.Certificate{}, errors.New("missing cert field in client certificate config")
-	}
-	if c.Key == "" {
-		return tls.Certificate{}, errors.New("missing key field in client certificate config")
-	}
-
 	return loadKeyPair([]byte(c.Cert), []byte(c.Key), c.Password)
 }
+
+// Validate checks that the certificate is given either as a pkcs12_file, as
+// a cert_file and key_file pair or as an inline cert and key pair.
+func (c ClientCertConfig) Validate() error {
+	switch {
+	case c.PKCS12File != "":
+		if c.CertFile != "" || c.KeyFile != "" || c.Cert != "" || c.Key != "" {
+			return errors.New("pkcs12_file cannot be combined with cert, key, cert_file or key_file fields in client certificate config")
+		}
+	case c.CertFile != "" || c.KeyFile != "":
+		if c.Cert != "" || c.Key != "" {
+			return errors.New("cert_file and key_file cannot be combined with cert or key fields in client certificate config")
+		}
+		if c.CertFile == "" {
+			return errors.New("missing cert_file field in client certificate config")
+		}
+		if c.KeyFile == "" {
+			return errors.New("missing key_file field in client certificate config")
+		}
+	default:
+		if c.Cert == "" {
+			return errors.New("missing cert field in client certificate config")
+		}
+		if c.Key == "" {
+			return errors.New("missing key field in client certificate config")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := map[string]func(c *Config){
+		"unknown version":       func(c *Config) { c.MinVersion = "1.4" },
+		"min above max":         func(c *Config) { c.MinVersion, c.MaxVersion = "1.3", "1.2" },
+		"max below default min": func(c *Config) { c.MaxVersion = "1.1" },
+		"unknown cipher suite":  func(c *Config) { c.CipherSuites = []string{"TLS_FOO"} },
+		"insecure cipher suite": func(c *Config) { c.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"} },
+		"cipher suites with 1.3": func(c *Config) {
+			c.MinVersion, c.CipherSuites = "1.3", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+		},
+		"unknown curve":         func(c *Config) { c.CurvePreferences = []string{"P-224"} },
+		"bad pin":               func(c *Config) { c.PinnedSPKI = []string{"sha256/abc"} },
+		"server name with port": func(c *Config) { c.ServerName = "nsqd:4150" },
+		"both root cas":         func(c *Config) { c.RootCAs, c.RootCAsFile = "a", "b" },
+		"pkcs12 with cert file": func(c *Config) {
+			c.ClientCertificates = []ClientCertConfig{{PKCS12File: "a.p12", CertFile: "a.pem"}}
+		},
+		"cert file with inline key": func(c *Config) {
+			c.ClientCertificates = []ClientCertConfig{{CertFile: "a.pem", KeyFile: "a.key", Key: "inline"}}
+		},
+		"cert file without key file": func(c *Config) { c.ClientCertificates = []ClientCertConfig{{CertFile: "a.pem"}} },
+		"empty client certificate":   func(c *Config) { c.ClientCertificates = []ClientCertConfig{{}} },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := NewConfig()
+			mutate(&c)
+			assert.Error(t, c.Validate())
+		})
+	}
+
+	c := NewConfig()
+	c.MinVersion = "1.4"
+	c.PinnedSPKI = []string{"sha256/abc"}
+	c.ClientCertificates = []ClientCertConfig{{CertFile: "a.pem", KeyFile: "a.key"}, {Cert: "inline"}}
+	err := c.Validate()
+	assert.ErrorContains(t, err, "min_version")
+	assert.ErrorContains(t, err, "pin")
+	assert.ErrorContains(t, err, "client_certs[1]: missing key field")
+	assert.NotContains(t, err.Error(), "client_certs[0]")
+}
+
+func TestConfigOptions(t *testing.T) {
+	c := NewConfig()
+	c.MinVersion = "TLS1.2"
+	c.MaxVersion = "1.3"
+	c.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}
+	c.CurvePreferences = []string{"X25519", "P-256"}
+
+	tlsConf, err := c.GetNonToggled(ifs.OS())
+	require.NoError(t, err)
+	require.NotNil(t, tlsConf)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConf.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConf.MaxVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, tlsConf.CipherSuites)
+	assert.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP256}, tlsConf.CurvePreferences)
+	assert.Nil(t, tlsConf.VerifyConnection)
+
+	c = NewConfig()
+	c.RootCAs = "not a certificate"
+	_, err = c.GetNonToggled(ifs.OS())
+	assert.Error(t, err)
+}
+
+// handshake connects a client using conf to a server presenting cert, setting
+// the client ServerName to the dialled host the same way go-nsq does.
+func handshake(t *testing.T, conf *tls.Config, cert tls.Certificate) error {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	server := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	go func() {
+		defer serverConn.Close()
+		_ = server.Handshake()
+	}()
+
+	conf = conf.Clone()
+	conf.ServerName = "127.0.0.1"
+	return tls.Client(clientConn, conf).Handshake()
+}
+
+func TestServerNameAndPins(t *testing.T) {
+	certPEM, keyPEM := selfSigned(t, time.Now().Add(time.Hour), "nsqd.internal")
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	block, _ := pem.Decode(certPEM)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	otherPEM, _ := selfSigned(t, time.Now().Add(time.Hour))
+	otherBlock, _ := pem.Decode(otherPEM)
+	other, err := x509.ParseCertificate(otherBlock.Bytes)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		serverName string
+		insecure   bool
+		pins       []string
+		wantErr    bool
+	}{
+		"dialled host does not match":  {wantErr: true},
+		"server name override":         {serverName: "nsqd.internal"},
+		"wrong server name override":   {serverName: "other.internal", wantErr: true},
+		"matching pin":                 {serverName: "nsqd.internal", pins: []string{SPKIPin(leaf)}},
+		"mismatching pin":              {serverName: "nsqd.internal", pins: []string{SPKIPin(other)}, wantErr: true},
+		"pin without chain validation": {insecure: true, pins: []string{SPKIPin(leaf)}},
+		"pin mismatch when insecure":   {insecure: true, pins: []string{SPKIPin(other)}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := NewConfig()
+			c.Enabled = true
+			c.RootCAs = string(certPEM)
+			c.ServerName = test.serverName
+			c.InsecureSkipVerify = test.insecure
+			c.PinnedSPKI = test.pins
+
+			tlsConf, err := c.Get(ifs.OS())
+			require.NoError(t, err)
+
+			err = handshake(t, tlsConf, cert)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
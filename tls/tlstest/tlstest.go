@@ -0,0 +1,368 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tlstest generates throwaway certificate authorities and leaf
+// certificates for tests exercising TLS configuration.
+package tlstest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/deepauto-io/nsqcc/filepath/ifs"
+	ntls "github.com/deepauto-io/nsqcc/tls"
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// KeyType is the type of key generated for a certificate.
+type KeyType string
+
+const (
+	RSA     KeyType = "rsa"
+	ECDSA   KeyType = "ecdsa"
+	Ed25519 KeyType = "ed25519"
+)
+
+// KeyFormat is the PEM encoding of a private key.
+type KeyFormat string
+
+const (
+	// PKCS8 encodes keys of every type as "PRIVATE KEY", or as "ENCRYPTED
+	// PRIVATE KEY" when a password is given.
+	PKCS8 KeyFormat = "pkcs8"
+	// PKCS1 encodes RSA keys as "RSA PRIVATE KEY", encrypted with the legacy
+	// PEM encryption when a password is given.
+	PKCS1 KeyFormat = "pkcs1"
+	// SEC1 encodes ECDSA keys as "EC PRIVATE KEY", encrypted with the legacy
+	// PEM encryption when a password is given.
+	SEC1 KeyFormat = "sec1"
+)
+
+// GenerateKey generates a private key of the given type.
+func GenerateKey(t testing.TB, kt KeyType) crypto.Signer {
+	t.Helper()
+
+	var key crypto.Signer
+	var err error
+	switch kt {
+	case RSA:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ECDSA, "":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case Ed25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unknown key type %q", kt)
+	}
+	if err != nil {
+		t.Fatalf("failed to generate %s key: %v", kt, err)
+	}
+	return key
+}
+
+// CA is a certificate authority issuing leaf certificates.
+type CA struct {
+	Cert    *x509.Certificate
+	Key     crypto.Signer
+	CertPEM []byte
+}
+
+// NewCA creates a self-signed CA with a key of the given type.
+func NewCA(t testing.TB, kt KeyType) *CA {
+	t.Helper()
+
+	key := GenerateKey(t, kt)
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial(t),
+		Subject:               pkix.Name{CommonName: "nsqcc test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert := create(t, tmpl, tmpl, key.Public(), key)
+	return &CA{Cert: cert, Key: key, CertPEM: encodeCert(cert)}
+}
+
+// Pool returns a pool containing the CA certificate.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// WriteRootCAs writes the CA certificate to dir on f and returns its path,
+// suitable for the root_cas_file field.
+func (ca *CA) WriteRootCAs(t testing.TB, f ifs.FS, dir string) string {
+	t.Helper()
+
+	p := path.Join(dir, "ca.pem")
+	writeFile(t, f, p, ca.CertPEM)
+	return p
+}
+
+// LeafOptions configure a certificate issued by a CA.
+type LeafOptions struct {
+	KeyType    KeyType
+	CommonName string
+	// Hosts are added as IP or DNS subject alternative names.
+	Hosts []string
+	// NotAfter defaults to a day from now.
+	NotAfter time.Time
+	Server   bool
+	Client   bool
+}
+
+// Leaf is a certificate issued by a CA and its private key.
+type Leaf struct {
+	Cert    *x509.Certificate
+	Key     crypto.Signer
+	CertPEM []byte
+	// ChainPEM holds the leaf followed by the CA certificate.
+	ChainPEM []byte
+	CA       *CA
+}
+
+// Issue creates a leaf certificate signed by the CA.
+func (ca *CA) Issue(t testing.TB, opts LeafOptions) *Leaf {
+	t.Helper()
+
+	if opts.NotAfter.IsZero() {
+		opts.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+	key := GenerateKey(t, opts.KeyType)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial(t),
+		Subject:      pkix.Name{CommonName: opts.CommonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     opts.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if opts.Server {
+		tmpl.ExtKeyUsage = append(tmpl.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	if opts.Client {
+		tmpl.ExtKeyUsage = append(tmpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	for _, h := range opts.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	cert := create(t, tmpl, ca.Cert, key.Public(), ca.Key)
+	certPEM := encodeCert(cert)
+	return &Leaf{
+		Cert:     cert,
+		Key:      key,
+		CertPEM:  certPEM,
+		ChainPEM: append(append([]byte{}, certPEM...), ca.CertPEM...),
+		CA:       ca,
+	}
+}
+
+// Server issues a server certificate valid for hosts.
+func (ca *CA) Server(t testing.TB, kt KeyType, hosts ...string) *Leaf {
+	t.Helper()
+	return ca.Issue(t, LeafOptions{KeyType: kt, CommonName: "nsqd", Hosts: hosts, Server: true})
+}
+
+// Client issues a client certificate.
+func (ca *CA) Client(t testing.TB, kt KeyType) *Leaf {
+	t.Helper()
+	return ca.Issue(t, LeafOptions{KeyType: kt, CommonName: "nsqcc client", Client: true})
+}
+
+// TLSCertificate returns the leaf as a tls.Certificate.
+func (l *Leaf) TLSCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{l.Cert.Raw, l.CA.Cert.Raw},
+		PrivateKey:  l.Key,
+		Leaf:        l.Cert,
+	}
+}
+
+// ServerTLSConfig returns a server side *tls.Config presenting the leaf and
+// requiring client certificates issued by the same CA.
+func (l *Leaf) ServerTLSConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{l.TLSCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    l.CA.Pool(),
+	}
+}
+
+// KeyPEM encodes the private key in the given format, encrypted with
+// password unless it is empty.
+func (l *Leaf) KeyPEM(t testing.TB, format KeyFormat, password string) []byte {
+	t.Helper()
+
+	var block *pem.Block
+	var err error
+	switch format {
+	case PKCS8, "":
+		if password != "" {
+			var der []byte
+			if der, err = pkcs8.MarshalPrivateKey(l.Key, []byte(password), nil); err == nil {
+				block = &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}
+			}
+			break
+		}
+		var der []byte
+		if der, err = x509.MarshalPKCS8PrivateKey(l.Key); err == nil {
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		}
+	case PKCS1:
+		key, ok := l.Key.(*rsa.PrivateKey)
+		if !ok {
+			t.Fatalf("PKCS#1 requires an RSA key, got %T", l.Key)
+		}
+		block, err = legacyBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), password)
+	case SEC1:
+		key, ok := l.Key.(*ecdsa.PrivateKey)
+		if !ok {
+			t.Fatalf("SEC1 requires an ECDSA key, got %T", l.Key)
+		}
+		var der []byte
+		if der, err = x509.MarshalECPrivateKey(key); err == nil {
+			block, err = legacyBlock("EC PRIVATE KEY", der, password)
+		}
+	default:
+		t.Fatalf("unknown key format %q", format)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode %s key: %v", format, err)
+	}
+	return pem.EncodeToMemory(block)
+}
+
+func legacyBlock(blockType string, der []byte, password string) (*pem.Block, error) {
+	if password == "" {
+		return &pem.Block{Type: blockType, Bytes: der}, nil
+	}
+	//nolint:staticcheck // SA1019 Legacy PEM encryption is what the loader has to support
+	return x509.EncryptPEMBlock(rand.Reader, blockType, der, []byte(password), x509.PEMCipherAES256)
+}
+
+// PKCS12 encodes the leaf, its CA and private key as a PKCS#12 bundle.
+func (l *Leaf) PKCS12(t testing.TB, password string) []byte {
+	t.Helper()
+
+	pfx, err := pkcs12.Modern.Encode(l.Key, l.Cert, []*x509.Certificate{l.CA.Cert}, password)
+	if err != nil {
+		t.Fatalf("failed to encode PKCS#12 bundle: %v", err)
+	}
+	return pfx
+}
+
+// ClientCertConfig returns a config holding the certificate chain and the key
+// inline, encoded in the given format.
+func (l *Leaf) ClientCertConfig(t testing.TB, format KeyFormat, password string) ntls.ClientCertConfig {
+	t.Helper()
+
+	return ntls.ClientCertConfig{
+		Cert:     string(l.ChainPEM),
+		Key:      string(l.KeyPEM(t, format, password)),
+		Password: password,
+	}
+}
+
+// WriteFiles writes the certificate chain and the key, encoded in the given
+// format, to dir on f and returns a config referencing them.
+func (l *Leaf) WriteFiles(t testing.TB, f ifs.FS, dir string, format KeyFormat, password string) ntls.ClientCertConfig {
+	t.Helper()
+
+	conf := ntls.ClientCertConfig{
+		CertFile: path.Join(dir, "cert.pem"),
+		KeyFile:  path.Join(dir, "key.pem"),
+		Password: password,
+	}
+	writeFile(t, f, conf.CertFile, l.ChainPEM)
+	writeFile(t, f, conf.KeyFile, l.KeyPEM(t, format, password))
+	return conf
+}
+
+// WritePKCS12 writes a PKCS#12 bundle of the leaf to dir on f and returns a
+// config referencing it.
+func (l *Leaf) WritePKCS12(t testing.TB, f ifs.FS, dir, password string) ntls.ClientCertConfig {
+	t.Helper()
+
+	conf := ntls.ClientCertConfig{
+		PKCS12File: path.Join(dir, "client.p12"),
+		Password:   password,
+	}
+	writeFile(t, f, conf.PKCS12File, l.PKCS12(t, password))
+	return conf
+}
+
+func writeFile(t testing.TB, f ifs.FS, name string, data []byte) {
+	t.Helper()
+
+	if err := f.MkdirAll(path.Dir(name), 0o755); err != nil {
+		t.Fatalf("failed to create %s: %v", path.Dir(name), err)
+	}
+	if err := ifs.WriteFile(f, name, data, 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+func serial(t testing.TB) *big.Int {
+	t.Helper()
+
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		t.Fatalf("failed to generate serial number: %v", err)
+	}
+	return n
+}
+
+func create(t testing.TB, tmpl, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func encodeCert(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022 The deepauto-io LLC.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tls
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPinMismatch is returned when none of the server certificates match the
+// configured SPKI pins.
+var ErrPinMismatch = errors.New("no server certificate matches the pinned public keys")
+
+// SPKIPin returns the pin of cert in the format accepted by pinned_spki: the
+// base64 encoded SHA-256 digest of its DER encoded SubjectPublicKeyInfo,
+// prefixed with "sha256/".
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// parsePins decodes pins given either as "sha256/<base64>" or as bare base64.
+func parsePins(pins []string) ([][]byte, error) {
+	if len(pins) == 0 {
+		return nil, nil
+	}
+	digests := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		b64 := strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+		digest, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls spki pin %q: %w", pin, err)
+		}
+		if len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid tls spki pin %q: expected a sha256 digest", pin)
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// verifier checks server certificates from a tls.Config VerifyConnection
+// callback.
+type verifier struct {
+	// roots returns the pool the chain is verified against, nil meaning the
+	// system pool.
+	roots func() *x509.CertPool
+	// serverName overrides the name the leaf certificate is verified for.
+	serverName string
+	// skipChain disables chain verification, pins are still checked.
+	skipChain bool
+	pins      [][]byte
+}
+
+func (v verifier) verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificates")
+	}
+
+	chains := cs.VerifiedChains
+	if !v.skipChain {
+		name := v.serverName
+		if name == "" {
+			name = cs.ServerName
+		}
+		opts := x509.VerifyOptions{
+			DNSName:       name,
+			Intermediates: x509.NewCertPool(),
+		}
+		if v.roots != nil {
+			opts.Roots = v.roots()
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		var err error
+		if chains, err = cs.PeerCertificates[0].Verify(opts); err != nil {
+			return err
+		}
+	}
+
+	if len(v.pins) == 0 {
+		return nil
+	}
+	if len(chains) == 0 {
+		chains = [][]*x509.Certificate{cs.PeerCertificates}
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range v.pins {
+				if bytes.Equal(sum[:], pin) {
+					return nil
+				}
+			}
+		}
+	}
+	return ErrPinMismatch
+}